@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// twitchChannelName matches a valid Twitch channel (user) name
+var twitchChannelName = regexp.MustCompile(`^[a-z0-9_]{1,25}$`)
+
+// handleWhisper runs the admin command in a whisper to the bot, whispers from anyone who isn't an admin
+// are ignored
+func (b *botConfig) handleWhisper(ctx context.Context, m chatMessage) {
+	fields := strings.Fields(m.text)
+	if len(fields) == 0 || !b.adminUsers[m.user] {
+		return
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "!add":
+		if len(fields) != 3 {
+			b.whisper(ctx, m.user, "Usage: !add <steamid> <channel>")
+			return
+		}
+		b.whisper(ctx, m.user, b.updateChannels(ctx, m.user, fields[1], normalizeChannelName(fields[2]), addChannel))
+	case "!remove":
+		if len(fields) != 2 && len(fields) != 3 {
+			b.whisper(ctx, m.user, "Usage: !remove <steamid> [channel]")
+			return
+		}
+		channel := ""
+		if len(fields) == 3 {
+			channel = normalizeChannelName(fields[2])
+		}
+		b.whisper(ctx, m.user, b.updateChannels(ctx, m.user, fields[1], channel, removeChannel))
+	}
+}
+
+// isReconnectCommand reports whether the whisper is an admin asking the bot to reconnect to Twitch, which
+// drops anything still queued to be sent. Sends that start while it's reconnecting fail like they would
+// for a dropped connection.
+func (b *botConfig) isReconnectCommand(m chatMessage) bool {
+	fields := strings.Fields(m.text)
+	return len(fields) == 1 && strings.ToLower(fields[0]) == "!reconnect" && b.adminUsers[m.user]
+}
+
+// channelUpdate changes the channels for a steamid in a copy of the config, returning the reply to send
+// and whether anything changed
+type channelUpdate func(channels map[string]channelList, steamid, channel string) (string, bool)
+
+// updateChannels applies the update to the config, saves it back to the config file and swaps it in. The
+// running config is only changed if the file was saved, so the two don't drift apart.
+func (b *botConfig) updateChannels(ctx context.Context, user, steamid, channel string, update channelUpdate) string {
+	steamID64, err := normalizeSteamID(steamid)
+	if err != nil {
+		return "Invalid steamid " + steamid
+	}
+	if channel != "" && !twitchChannelName.MatchString(channel) {
+		return "Invalid channel " + channel
+	}
+	if b.configURL != "" {
+		// the change would be lost the next time the config is fetched
+		return "The config is fetched from " + configURLEnvName + ", change it there"
+	}
+	if info, err := os.Stat(b.channelsFile); err == nil && info.IsDir() {
+		// there's no telling which of the files the change belongs in
+		return "The config is split into files in " + b.channelsFile + ", change them there"
+	}
+
+	// hold the lock across reading, saving and swapping in the config so concurrent commands don't undo
+	// each other
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
+
+	// the running config is never modified in place, so update a copy
+	channels := map[string]channelList{}
+	for id, list := range b.playerChannels() {
+		channels[id] = list
+	}
+
+	// the player may already be in the config written in another steamid format
+	for id := range channels {
+		if normalized, err := normalizeSteamID(id); err == nil && normalized == steamID64 {
+			steamid = id
+			break
+		}
+	}
+
+	everyPlayer := everyPlayerChannels(channels)
+	reply, changed := update(channels, steamid, channel)
+	if !changed {
+		return reply
+	}
+	// a newly added player gets the all_players channels too
+	addEveryPlayerChannels(channels, everyPlayer)
+
+	if err := saveChannelsToFile(b.channelsFile, channels); err != nil {
+		slog.Error("Failed to save channels", "path", b.channelsFile, "error", err)
+		return "Couldn't save the config, nothing was changed"
+	}
+
+	b.setChannels(ctx, channels)
+	slog.Info("Updated channels from whisper", "user", user, "steamid", steamid, "channel", channel, "players", len(channels))
+	return reply
+}
+
+// addChannel adds the channel to the steamid's channels
+func addChannel(channels map[string]channelList, steamid, channel string) (string, bool) {
+	for _, c := range channels[steamid] {
+		if c.Name == channel {
+			return fmt.Sprintf("%v is already posted to #%v", steamid, channel), false
+		}
+	}
+
+	list := make(channelList, 0, len(channels[steamid])+1)
+	list = append(list, channels[steamid]...)
+	channels[steamid] = append(list, channelConfig{Name: channel})
+	return fmt.Sprintf("Added %v to #%v", steamid, channel), true
+}
+
+// removeChannel removes the channel from the steamid's channels, or stops tracking the steamid entirely if
+// no channel is given
+func removeChannel(channels map[string]channelList, steamid, channel string) (string, bool) {
+	list, ok := channels[steamid]
+	if !ok {
+		return steamid + " isn't tracked", false
+	}
+
+	if channel == "" {
+		delete(channels, steamid)
+		return "Stopped tracking " + steamid, true
+	}
+
+	// all_players channels are added back to every player, so there's no removing them for one
+	if everyPlayerChannels(channels).has(channel) {
+		return fmt.Sprintf("#%v is posted for every player by %v, change it in the config file", channel, allPlayersKey), false
+	}
+
+	var kept channelList
+	for _, c := range list {
+		if c.Name != channel {
+			kept = append(kept, c)
+		}
+	}
+
+	if len(kept) == len(list) {
+		return fmt.Sprintf("%v isn't posted to #%v", steamid, channel), false
+	}
+
+	if len(kept) == 0 {
+		delete(channels, steamid)
+	} else {
+		channels[steamid] = kept
+	}
+	return fmt.Sprintf("Removed %v from #%v", steamid, channel), true
+}
+
+// whisper sends a whisper to the user
+func (b *botConfig) whisper(ctx context.Context, user, text string) {
+	if err := b.send(ctx, "PRIVMSG #%s :/w %s %s", strings.ToLower(b.userName), user, text); err != nil {
+		slog.Error("Failed to whisper", "user", user, "error", err)
+	}
+}
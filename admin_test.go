@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// whisperLine is a whisper from the user to the bot, the way Twitch sends it
+func whisperLine(user, text string) string {
+	return ":" + user + "!" + user + "@" + user + ".tmi.twitch.tv WHISPER " + testUserName + " :" + text
+}
+
+func TestWhisperedAddAndRemoveUpdateTheConfig(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, players([]string{testSteamID}, testChannel), map[string]string{adminUsersEnvName: "Admin"})
+	connectTestBot(t, b, twitch, testChannel)
+	readInBackground(t, b)
+
+	twitch.write(t, whisperLine("admin", "!add "+otherSteamID+" #Clockwork"))
+	twitch.expect(t, "JOIN #clockwork", "PRIVMSG #logsbot :/w admin Added "+otherSteamID+" to #clockwork")
+
+	saved, err := loadChannelsFromFile(b.channelsFile)
+	if err != nil {
+		t.Fatalf("Failed to load the saved config: %v", err)
+	}
+	want := map[string][]string{testSteamID: {testChannel}, otherSteamID: {otherTestChannel}}
+	for _, channels := range []map[string]channelList{saved, b.playerChannels()} {
+		got := map[string][]string{}
+		for steamid, list := range channels {
+			got[steamid] = list.names()
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Config is %v after adding, want %v", got, want)
+		}
+	}
+
+	twitch.write(t, whisperLine("admin", "!remove "+otherSteamID))
+	twitch.expect(t, "PART #clockwork", "PRIVMSG #logsbot :/w admin Stopped tracking "+otherSteamID)
+	if saved, err = loadChannelsFromFile(b.channelsFile); err != nil {
+		t.Fatalf("Failed to load the saved config: %v", err)
+	}
+	if _, ok := saved[otherSteamID]; ok {
+		t.Errorf("%v is still in the saved config after being removed", otherSteamID)
+	}
+}
+
+func TestWhisperCommandReplies(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, players([]string{testSteamID}, testChannel), map[string]string{adminUsersEnvName: "admin"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	tests := map[string]string{
+		"!add " + testSteamID:                             "Usage: !add <steamid> <channel>",
+		"!add notasteamid lansky":                         "Invalid steamid notasteamid",
+		"!add " + otherSteamID + " not-a-channel":         "Invalid channel not-a-channel",
+		"!add STEAM_0:0:73487439 lansky":                  testSteamID + " is already posted to #lansky",
+		"!remove":                                         "Usage: !remove <steamid> [channel]",
+		"!remove " + otherSteamID:                         otherSteamID + " isn't tracked",
+		"!remove " + testSteamID + " " + otherTestChannel: testSteamID + " isn't posted to #clockwork",
+	}
+	for text, want := range tests {
+		b.handleWhisper(context.Background(), chatMessage{user: "admin", whisper: true, text: text})
+		if got := twitch.flush(t, b); !reflect.DeepEqual(got, []string{"PRIVMSG #logsbot :/w admin " + want}) {
+			t.Errorf("%q replied %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestWhisperedRemoveKeepsAllPlayersChannels(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	channels := map[string]channelList{testSteamID: {{Name: testChannel}, {Name: otherTestChannel, everyPlayer: true}}}
+	b := newTestBot(t, nil, channels, map[string]string{adminUsersEnvName: "admin"})
+	if err := saveChannelsToFile(b.channelsFile, channels); err != nil {
+		t.Fatalf("Failed to save the config: %v", err)
+	}
+	connectTestBot(t, b, twitch, testChannel, otherTestChannel)
+
+	b.handleWhisper(context.Background(), chatMessage{user: "admin", whisper: true, text: "!remove " + testSteamID + " " + otherTestChannel})
+	want := "PRIVMSG #logsbot :/w admin #clockwork is posted for every player by all_players, change it in the config file"
+	if got := twitch.flush(t, b); !reflect.DeepEqual(got, []string{want}) {
+		t.Errorf("Replied %q, want %q", got, want)
+	}
+	if got := b.playerChannels()[testSteamID].names(); !reflect.DeepEqual(got, []string{testChannel, otherTestChannel}) {
+		t.Errorf("Posting to %v after the refused remove, want both channels", got)
+	}
+
+	// the player's own channels can still be removed, and all_players still posts for them
+	b.handleWhisper(context.Background(), chatMessage{user: "admin", whisper: true, text: "!remove " + testSteamID + " " + testChannel})
+	saved, err := loadChannelsFromFile(b.channelsFile)
+	if err != nil {
+		t.Fatalf("Failed to load the saved config: %v", err)
+	}
+	if got := saved[testSteamID].names(); !reflect.DeepEqual(got, []string{otherTestChannel}) {
+		t.Errorf("Saved %v for the player, want just the all_players channel", got)
+	}
+}
+
+func TestWhispersFromOtherUsersAreIgnored(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, players([]string{testSteamID}, testChannel), map[string]string{adminUsersEnvName: "admin"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	b.handleWhisper(context.Background(), chatMessage{user: "viewer", whisper: true, text: "!remove " + testSteamID})
+	if got := twitch.flush(t, b); len(got) != 0 {
+		t.Errorf("Replied %q to a user who isn't an admin", got)
+	}
+	if _, ok := b.playerChannels()[testSteamID]; !ok {
+		t.Errorf("A user who isn't an admin removed %v", testSteamID)
+	}
+}
+
+func TestParseWhisper(t *testing.T) {
+	msg, _ := parseIRCMessage("@badges=;display-name=Admin :admin!admin@admin.tmi.twitch.tv WHISPER logsbot :!add 76561197991735941 clockwork")
+	m, ok := parseWhisper(msg)
+	if !ok {
+		t.Fatal("parseWhisper couldn't parse the whisper")
+	}
+	if m.user != "admin" || m.displayName != "Admin" || !m.whisper || m.channel != "" || m.text != "!add 76561197991735941 clockwork" {
+		t.Errorf("Parsed %+v", m)
+	}
+
+	if _, ok := parseWhisper(ircMessage{command: "PRIVMSG", params: []string{"#lansky"}}); ok {
+		t.Error("parseWhisper parsed a PRIVMSG")
+	}
+}
+
+func TestWhisperedReconnectReestablishesTheConnection(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{adminUsersEnvName: "admin"})
+	b.dial = twitch.dial
+
+	served := make(chan error, 1)
+	go func() {
+		served <- b.Serve(context.Background())
+	}()
+	twitch.nextMatching(t, "JOIN #lansky")
+
+	// only admins can force a reconnect
+	twitch.write(t, whisperLine("viewer", "!reconnect"))
+	twitch.write(t, whisperLine("admin", "!reconnect"))
+	select {
+	case err := <-served:
+		if !errors.Is(err, errReconnectCommanded) {
+			t.Fatalf("Serve returned %v, want %v", err, errReconnectCommanded)
+		}
+	case <-time.After(harnessTimeout):
+		t.Fatal("Serve didn't return after !reconnect")
+	}
+
+	// serving again, as main does after a commanded reconnect, dials a new connection and joins again
+	go func() {
+		served <- b.Serve(context.Background())
+	}()
+	twitch.nextMatching(t, "JOIN #lansky")
+	twitch.mutex.Lock()
+	dials := twitch.dials
+	twitch.mutex.Unlock()
+	if dials != 2 {
+		t.Errorf("Dialed %d times, want a new connection after !reconnect", dials)
+	}
+
+	twitch.write(t, ":tmi.twitch.tv RECONNECT")
+	select {
+	case <-served:
+	case <-time.After(harnessTimeout):
+		t.Fatal("Serve didn't return on the new connection")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff produces exponentially growing delays between reconnection attempts, starting at base and capped
+// at max, with random jitter so many clients don't retry in lockstep
+type backoff struct {
+	base    time.Duration
+	max     time.Duration
+	attempt int
+}
+
+// next returns the delay before the next attempt, somewhere between half and all of the current backoff
+func (b *backoff) next() time.Duration {
+	// double the base for each attempt, stopping at max since doubling past it would eventually overflow
+	d := b.base
+	for i := 0; i < b.attempt && d < b.max; i++ {
+		if d > b.max/2 {
+			d = b.max
+			break
+		}
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	b.attempt++
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// reset starts the backoff over from the base delay
+func (b *backoff) reset() {
+	b.attempt = 0
+}
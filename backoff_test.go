@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowsToMax(t *testing.T) {
+	for _, base := range []time.Duration{time.Second, 10 * time.Second, time.Minute} {
+		b := &backoff{base: base, max: 5 * time.Minute}
+
+		// well past where shifting the base would overflow
+		want := base
+		for attempt := 0; attempt < 100; attempt++ {
+			if d := b.next(); d < want/2 || d > want {
+				t.Fatalf("Attempt %d with base %v waited %v, want between %v and %v", attempt, base, d, want/2, want)
+			}
+			if want *= 2; want > b.max {
+				want = b.max
+			}
+		}
+	}
+}
+
+func TestBackoffNearMaxDuration(t *testing.T) {
+	b := &backoff{base: time.Hour, max: 1<<63 - 1}
+	for attempt := 0; attempt < 100; attempt++ {
+		if d := b.next(); d <= 0 {
+			t.Fatalf("Attempt %d waited %v", attempt, d)
+		}
+	}
+}
+
+func TestBackoffReset(t *testing.T) {
+	b := &backoff{base: time.Second, max: 5 * time.Minute}
+	for i := 0; i < 10; i++ {
+		b.next()
+	}
+
+	b.reset()
+	if d := b.next(); d < b.base/2 || d > b.base {
+		t.Errorf("First attempt after a reset waited %v, want between %v and %v", d, b.base/2, b.base)
+	}
+}
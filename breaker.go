@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold = 5           // failed logs.tf requests in a row before the breaker opens
+	defaultBreakerCooldown  = time.Minute // how long the breaker stays open before trying logs.tf again
+)
+
+// the states of a circuitBreaker
+const (
+	circuitClosed   = "closed"    // requests go through
+	circuitOpen     = "open"      // requests fail right away
+	circuitHalfOpen = "half-open" // a single request is let through to see if logs.tf has recovered
+)
+
+var circuitStates = []string{circuitClosed, circuitOpen, circuitHalfOpen}
+
+// errCircuitOpen is returned instead of asking logs.tf while the circuit breaker is open
+var errCircuitOpen = errors.New("logs.tf looks to be down, not asking it until the circuit breaker's cooldown has passed")
+
+// circuitBreaker stops the bot from sending logs.tf a request per player per poll while it's down. After
+// threshold failures in a row the breaker opens and requests fail without being sent. Once the cooldown has
+// passed it half-opens and lets one request through: the breaker closes again if it succeeds, and opens for
+// another cooldown if it fails.
+type circuitBreaker struct {
+	mutex     *sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	onChange  func(state string)
+
+	state    string
+	failures int       // in a row while closed
+	openedAt time.Time // when the breaker last opened
+	probing  bool      // whether the half-open request is in flight
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, onChange func(state string)) *circuitBreaker {
+	return &circuitBreaker{
+		mutex:     &sync.Mutex{},
+		threshold: threshold,
+		cooldown:  cooldown,
+		onChange:  onChange,
+		state:     circuitClosed,
+	}
+}
+
+// allow returns errCircuitOpen if the request shouldn't be sent, otherwise the request must be followed by
+// a call to done with its result
+func (c *circuitBreaker) allow() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch c.state {
+	case circuitOpen:
+		if time.Since(c.openedAt) < c.cooldown {
+			return errCircuitOpen
+		}
+		c.setState(circuitHalfOpen)
+	case circuitHalfOpen:
+		if c.probing {
+			return errCircuitOpen
+		}
+	default:
+		return nil
+	}
+
+	c.probing = true
+	return nil
+}
+
+// done records the result of a request allow let through. Only errors that suggest logs.tf itself is having
+// trouble count as failures, a request cut short by the context counts as neither.
+func (c *circuitBreaker) done(ctx context.Context, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	probe := c.probing
+	c.probing = false
+
+	var decodeErr *decodeError
+	failed := isTemporary(err) || errors.As(err, &decodeErr)
+	switch {
+	case ctx.Err() != nil:
+	case !failed:
+		c.failures = 0
+		if c.state != circuitClosed {
+			slog.Info("logs.tf has recovered, closing the circuit breaker")
+			c.setState(circuitClosed)
+		}
+	case probe:
+		slog.Warn("logs.tf is still failing, keeping the circuit breaker open", "cooldown", c.cooldown.String(), "error", err)
+		c.open()
+	default:
+		if c.failures++; c.failures >= c.threshold && c.state == circuitClosed {
+			slog.Warn("logs.tf keeps failing, opening the circuit breaker", "failures", c.failures, "cooldown", c.cooldown.String(), "error", err)
+			c.open()
+		}
+	}
+}
+
+func (c *circuitBreaker) open() {
+	c.failures = 0
+	c.openedAt = time.Now()
+	c.setState(circuitOpen)
+}
+
+func (c *circuitBreaker) setState(state string) {
+	if c.state == state {
+		return
+	}
+
+	c.state = state
+	c.onChange(state)
+}
+
+// current returns the breaker's state
+func (c *circuitBreaker) current() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.state
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	var states []string
+	c := newCircuitBreaker(2, 50*time.Millisecond, func(state string) { states = append(states, state) })
+	ctx := context.Background()
+	failure := &httpStatusError{StatusCode: http.StatusBadGateway}
+
+	expect := func(want string) {
+		t.Helper()
+		if got := c.current(); got != want {
+			t.Fatalf("Breaker is %v, want %v", got, want)
+		}
+	}
+
+	// closed, until threshold failures in a row
+	for i := 0; i < 2; i++ {
+		expect(circuitClosed)
+		if err := c.allow(); err != nil {
+			t.Fatalf("allow returned %v while closed", err)
+		}
+		c.done(ctx, failure)
+	}
+	expect(circuitOpen)
+	if err := c.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow returned %v while open, want %v", err, errCircuitOpen)
+	}
+
+	// half-open after the cooldown, letting one request through, which fails and opens it again
+	time.Sleep(60 * time.Millisecond)
+	if err := c.allow(); err != nil {
+		t.Fatalf("allow returned %v after the cooldown, want the probe let through", err)
+	}
+	expect(circuitHalfOpen)
+	if err := c.allow(); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("allow returned %v while the probe is in flight, want %v", err, errCircuitOpen)
+	}
+	c.done(ctx, failure)
+	expect(circuitOpen)
+
+	// a probe that succeeds closes it
+	time.Sleep(60 * time.Millisecond)
+	if err := c.allow(); err != nil {
+		t.Fatalf("allow returned %v after the cooldown, want the probe let through", err)
+	}
+	c.done(ctx, nil)
+	expect(circuitClosed)
+
+	want := []string{circuitOpen, circuitHalfOpen, circuitOpen, circuitHalfOpen, circuitClosed}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("Breaker went through %v, want %v", states, want)
+	}
+}
+
+func TestCircuitBreakerOnlyCountsLogsTFFailures(t *testing.T) {
+	c := newCircuitBreaker(1, time.Hour, func(string) {})
+
+	// logs.tf answering that the player doesn't exist means it's up
+	c.allow()
+	c.done(context.Background(), &invalidSteamIDError{steamid: testSteamID, reason: "Invalid steamid"})
+	// a request cut short by shutdown says nothing about logs.tf
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.allow()
+	c.done(ctx, context.Canceled)
+	if got := c.current(); got != circuitClosed {
+		t.Errorf("Breaker is %v, want %v", got, circuitClosed)
+	}
+
+	c.allow()
+	c.done(context.Background(), &decodeError{err: errors.New("unexpected end of JSON input")})
+	if got := c.current(); got != circuitOpen {
+		t.Errorf("Breaker is %v after a malformed response, want %v", got, circuitOpen)
+	}
+}
+
+func TestCircuitBreakerStopsRequestsToLogsTF(t *testing.T) {
+	// malformed responses count as failures without being retried
+	requests := 0
+	logsTF := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("<html>logs.tf is down</html>"))
+	}))
+	defer logsTF.Close()
+	b := newTestBot(t, nil, players([]string{testSteamID}, testChannel), map[string]string{
+		logsTFBaseURLEnvName:    logsTF.URL,
+		breakerThresholdEnvName: "2",
+		breakerCooldownEnvName:  "1h",
+		metricsEnabledEnvName:   "true",
+	})
+
+	for i := 0; i < 5; i++ {
+		_, err := b.getNewestLogForPlayer(context.Background(), testSteamID)
+		if i >= 2 && !errors.Is(err, errCircuitOpen) {
+			t.Errorf("Lookup %d returned %v, want %v", i+1, err, errCircuitOpen)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("logs.tf got %d requests, want 2 before the breaker opened", requests)
+	}
+
+	w := httptest.NewRecorder()
+	b.metrics.(http.Handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{`logsbot_logstf_circuit_state{state="open"} 1`, `logsbot_logstf_circuit_state{state="closed"} 0`} {
+		if !strings.Contains(w.Body.String(), want+"\n") {
+			t.Errorf("/metrics is missing %v:\n%v", want, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	b.handleStatus(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if !strings.Contains(w.Body.String(), `"logs_tf_circuit":"open"`) {
+		t.Errorf("/status is %v, want the open circuit", w.Body.String())
+	}
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// channelConfig is a Twitch channel that a SteamID's logs are posted to, along with any per-channel options
+type channelConfig struct {
+	Name string `json:"channel"`
+
+	// SpoilerDelay overrides how long to wait before posting a log to this channel, use it to match the
+	// stream's delay
+	SpoilerDelay *duration `json:"spoiler_delay,omitempty"`
+
+	// Template overrides the message posted for a log, see messageData for the fields it can use
+	Template string `json:"template,omitempty"`
+	template *template.Template
+
+	// Prefix and Suffix are added before and after the channel's message, e.g. to mention someone, without
+	// having to write a whole template
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+
+	// DiscordWebhook is a Discord webhook URL that logs posted to this channel are mirrored to
+	DiscordWebhook string `json:"discord_webhook,omitempty"`
+
+	// Formats limits the channel to logs of these game formats (e.g. "6s"), overriding LOGS_BOT_ALLOWED_FORMATS
+	Formats []string `json:"formats,omitempty"`
+
+	// AllowedMaps and DeniedMaps are map name prefixes (e.g. "koth_") that limit which maps the channel is
+	// posted logs for, overriding LOGS_BOT_ALLOWED_MAPS and LOGS_BOT_DENIED_MAPS
+	AllowedMaps []string `json:"allowed_maps,omitempty"`
+	DeniedMaps  []string `json:"denied_maps,omitempty"`
+
+	// PollInterval overrides how often the channel's players are checked for new logs, a player in several
+	// channels is checked at the shortest of their intervals
+	PollInterval *duration `json:"poll_interval,omitempty"`
+
+	// ListPlayers adds the tracked players who were in the log to the channel's message, for channels
+	// tracking a whole team
+	ListPlayers bool `json:"list_players,omitempty"`
+
+	// Outcome limits the channel to logs the player won ("win") or lost ("loss")
+	Outcome string `json:"outcome,omitempty"`
+
+	// MinDuration skips logs of matches shorter than it, like aborted matches and warmups
+	MinDuration *duration `json:"min_duration,omitempty"`
+
+	// Uploaders limits the channel to logs uploaded by these steamids, like a league's official uploader, so
+	// pugs and pubs uploaded by the players themselves are skipped
+	Uploaders []string `json:"uploaders,omitempty"`
+
+	// QuietHours is a daily window during which the channel isn't posted logs
+	QuietHours *quietHours `json:"quiet_hours,omitempty"`
+
+	// everyPlayer is set for channels listed under all_players, which are added to every player's channels
+	everyPlayer bool
+}
+
+func (c *channelConfig) UnmarshalJSON(data []byte) error {
+	// a bare string is just the channel name with default options
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*c = channelConfig{Name: normalizeChannelName(name)}
+		if c.Name == "" {
+			return fmt.Errorf("channel is missing a name: %s", string(data))
+		}
+		return nil
+	}
+
+	type plain channelConfig
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("channel must be a string or an object, got %s", string(data))
+	}
+
+	p.Name = normalizeChannelName(p.Name)
+	if p.Name == "" {
+		return fmt.Errorf("channel is missing a name: %s", string(data))
+	}
+
+	if p.PollInterval != nil && *p.PollInterval <= 0 {
+		return fmt.Errorf("invalid poll_interval for channel %v: must be more than 0", p.Name)
+	}
+
+	if p.Outcome != "" && p.Outcome != outcomeWin && p.Outcome != outcomeLoss {
+		return fmt.Errorf("invalid outcome for channel %v: %q, expected %v or %v", p.Name, p.Outcome, outcomeWin, outcomeLoss)
+	}
+
+	for i, uploader := range p.Uploaders {
+		steamID64, err := normalizeSteamID(uploader)
+		if err != nil {
+			return fmt.Errorf("invalid uploader for channel %v: %v", p.Name, err)
+		}
+		p.Uploaders[i] = steamID64
+	}
+
+	if p.Template != "" {
+		t, err := parseMessageTemplate(p.Template)
+		if err != nil {
+			return fmt.Errorf("invalid template for channel %v: %v", p.Name, err)
+		}
+		p.template = t
+	}
+
+	*c = channelConfig(p)
+	return nil
+}
+
+// normalizeChannelName returns the channel name the way Twitch IRC uses it, lowercase and without the #
+// that's added when sending to the channel
+func normalizeChannelName(name string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+}
+
+// MarshalJSON writes a channel without any options as just its name, the same as it's usually written
+func (c channelConfig) MarshalJSON() ([]byte, error) {
+	if c.SpoilerDelay == nil && c.Template == "" && c.Prefix == "" && c.Suffix == "" && c.DiscordWebhook == "" && len(c.Formats) == 0 &&
+		c.AllowedMaps == nil && c.DeniedMaps == nil && c.Outcome == "" && c.PollInterval == nil &&
+		!c.ListPlayers && c.MinDuration == nil && len(c.Uploaders) == 0 &&
+		c.QuietHours == nil {
+		return json.Marshal(c.Name)
+	}
+
+	type plain channelConfig
+	return json.Marshal(plain(c))
+}
+
+// spoilerDelay returns how long to wait before posting a log to the channel, def is used if the channel
+// doesn't set its own delay
+func (c channelConfig) spoilerDelay(def time.Duration) time.Duration {
+	if c.SpoilerDelay != nil {
+		return time.Duration(*c.SpoilerDelay)
+	}
+
+	return def
+}
+
+// channelList is the set of Twitch channels a SteamID's logs are posted to. In channels.json it can be
+// written as either a single channel or an array of channels.
+type channelList []channelConfig
+
+func (c *channelList) UnmarshalJSON(data []byte) error {
+	var channel channelConfig
+	if err := json.Unmarshal(data, &channel); err == nil {
+		*c = channelList{channel}
+		return nil
+	} else if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '[' {
+		// it isn't an array, so the channel's own error says what's wrong
+		return err
+	}
+
+	var channels []channelConfig
+	if err := json.Unmarshal(data, &channels); err != nil {
+		return err
+	}
+
+	*c = channels
+	return nil
+}
+
+func (c channelList) MarshalJSON() ([]byte, error) {
+	if len(c) == 1 {
+		return json.Marshal(c[0])
+	}
+
+	return json.Marshal([]channelConfig(c))
+}
+
+// has reports whether the channel is in the list
+func (c channelList) has(name string) bool {
+	for _, channel := range c {
+		if channel.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// names returns the channel names in the list
+func (c channelList) names() []string {
+	names := make([]string, 0, len(c))
+	for _, channel := range c {
+		names = append(names, channel.Name)
+	}
+
+	return names
+}
+
+// pollInterval returns how often to check for the player's new logs given the channels they're posted to,
+// def is used if none of the channels set their own interval
+func (c channelList) pollInterval(def time.Duration) time.Duration {
+	interval := time.Duration(0)
+	for _, channel := range c {
+		if channel.PollInterval != nil && (interval == 0 || time.Duration(*channel.PollInterval) < interval) {
+			interval = time.Duration(*channel.PollInterval)
+		}
+	}
+
+	if interval == 0 {
+		return def
+	}
+
+	return interval
+}
+
+// duration is a time.Duration that can be written in JSON as a number of seconds or a Go duration string
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		if seconds < 0 {
+			return fmt.Errorf("duration must not be negative, got %s", string(data))
+		}
+
+		*d = duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a number of seconds or a duration string, got %s", string(data))
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// channelsFileNames are the config files looked for when starting, in order of preference
+var channelsFileNames = []string{channelsFileName, "channels.yaml", "channels.yml"}
+
+// findChannelsFile returns the first config file that exists, or channels.json if none do so the error
+// names the usual file
+func findChannelsFile() string {
+	for _, name := range channelsFileNames {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+
+	return channelsFileName
+}
+
+// loadChannelsFromFile reads the steamid to channels mapping, from YAML if the file has a .yaml or .yml
+// extension and from JSON otherwise. The path can also be a directory of config files.
+func loadChannelsFromFile(path string) (map[string]channelList, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return loadChannelsFromDir(path)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if b, err = yamlToJSON(b); err != nil {
+			return nil, err
+		}
+	}
+
+	return parseChannels(b)
+}
+
+// loadChannelsFromDir reads and merges every .json, .yaml and .yml config file in the directory, e.g. one
+// for each team. A player can only be in one of the files, and a file's all_players channels only get the
+// logs of the players in that file. A channel in several files has to be set up the same way in each, so
+// which file was read last doesn't change how it's posted to.
+func loadChannelsFromDir(dir string) (map[string]channelList, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := map[string]channelList{}
+	fileOf := map[string]string{}             // SteamID64 to the file the player is in
+	setups := map[string][]fileChannelSetup{} // channel to how it's set up for each player, in each file
+	files := 0
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		files++
+		fileChannels, err := loadChannelsFromFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", entry.Name(), err)
+		}
+		for steamid, list := range fileChannels {
+			// the same player can be written in different steamid formats
+			key := steamid
+			if steamID64, err := normalizeSteamID(steamid); err == nil {
+				key = steamID64
+			}
+			if other, ok := fileOf[key]; ok {
+				return nil, fmt.Errorf("steamid %v is in both %v and %v, a player can only be in one config file", steamid, other, entry.Name())
+			}
+			fileOf[key] = entry.Name()
+			channels[steamid] = list
+
+			for _, channel := range list {
+				setup := setupOf(channel)
+				for _, other := range setups[channel.Name] {
+					if other.file != entry.Name() && other.setup != setup {
+						return nil, fmt.Errorf("channel %v is set up differently in %v and %v, its template, spoiler_delay, quiet_hours and all_players have to match",
+							channel.Name, other.file, entry.Name())
+					}
+				}
+				setups[channel.Name] = append(setups[channel.Name], fileChannelSetup{file: entry.Name(), setup: setup})
+			}
+		}
+	}
+
+	if files == 0 {
+		return nil, fmt.Errorf("no .json, .yaml or .yml config files in %v", dir)
+	}
+
+	return channels, nil
+}
+
+// channelSetup is what has to match when a channel is in more than one config file
+type channelSetup struct {
+	template     string
+	spoilerDelay string // "" if the channel doesn't set one
+	quietHours   string // "" if the channel doesn't set any
+	allPlayers   bool
+}
+
+// fileChannelSetup is how a channel was set up for a player in one of the config files
+type fileChannelSetup struct {
+	file  string
+	setup channelSetup
+}
+
+func setupOf(c channelConfig) channelSetup {
+	setup := channelSetup{template: c.Template, allPlayers: c.everyPlayer}
+	if c.SpoilerDelay != nil {
+		setup.spoilerDelay = time.Duration(*c.SpoilerDelay).String()
+	}
+	if c.QuietHours != nil {
+		setup.quietHours = c.QuietHours.Start + "-" + c.QuietHours.End + " " + c.QuietHours.Timezone
+	}
+
+	return setup
+}
+
+// loadChannels reads the steamid to channels mapping from the config file, or from LOGS_BOT_CHANNELS_JSON
+// if the file doesn't exist. The file always wins, so once an admin command has saved the config to the
+// file the inline config is no longer used. With LOGS_BOT_CONFIG_URL set it's fetched from there instead,
+// falling back to the last config fetched. Vanity profile URLs are resolved to SteamID64s.
+func (b *botConfig) loadChannels(ctx context.Context) (map[string]channelList, error) {
+	if b.configURL != "" {
+		return b.loadChannelsFromURL(ctx)
+	}
+
+	channels, err := loadChannelsFromFile(b.channelsFile)
+	if errors.Is(err, fs.ErrNotExist) && b.channelsJSON != "" {
+		if channels, err = parseChannels([]byte(b.channelsJSON)); err != nil {
+			return nil, fmt.Errorf("Invalid value for %v: %v", channelsJSONEnvName, err)
+		}
+		slog.Info("Loaded channels from "+channelsJSONEnvName+" since the config file doesn't exist", "path", b.channelsFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b.resolveVanityURLs(ctx, channels), nil
+}
+
+// channelsKey holds the channel-centric part of the config, which lists the players to post logs for under
+// each channel instead of the channels under each player:
+//
+//	{"channels": {"teamchannel": ["76561198107240606", "76561197991735941"]}}
+//
+// A channel can also be an object with the players under "players" and the same options as anywhere else.
+// Both shapes can be used in the same config.
+const channelsKey = "channels"
+
+// allPlayersKey holds channels that are posted every tracked player's logs, like a community channel that
+// shows all of its players' matches:
+//
+//	{"all_players": "highlights", "76561198107240606": "lansky"}
+//
+// The channels are added to every player's channels, so players can be tracked just for them by giving
+// them an empty list of their own. A log shared by several players is still only posted once.
+const allPlayersKey = "all_players"
+
+// parseChannels parses a config into the steamid to channels mapping the poller uses
+func parseChannels(data []byte) (map[string]channelList, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+
+	channels := map[string]channelList{}
+	for steamid, raw := range top {
+		if steamid == channelsKey || steamid == allPlayersKey {
+			continue
+		}
+
+		var list channelList
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, fmt.Errorf("invalid channels for steamid %v: %v", steamid, err)
+		}
+		channels[steamid] = list
+	}
+
+	if raw, ok := top[allPlayersKey]; ok {
+		var everyPlayer channelList
+		if err := json.Unmarshal(raw, &everyPlayer); err != nil {
+			return nil, fmt.Errorf("invalid channels for %v: %v", allPlayersKey, err)
+		}
+		for i := range everyPlayer {
+			everyPlayer[i].everyPlayer = true
+		}
+		defer addEveryPlayerChannels(channels, everyPlayer)
+	}
+
+	raw, ok := top[channelsKey]
+	if !ok {
+		return channels, nil
+	}
+
+	var byChannel map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &byChannel); err != nil {
+		return nil, fmt.Errorf("%v must be an object of channel names, got %s", channelsKey, string(raw))
+	}
+
+	// go through the channels in order so each player's channels are always in the same order
+	names := make([]string, 0, len(byChannel))
+	for name := range byChannel {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		channel, players, err := parseChannelPlayers(name, byChannel[name])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, steamid := range players {
+			if !channels[steamid].has(channel.Name) {
+				channels[steamid] = append(channels[steamid], channel)
+			}
+		}
+	}
+
+	return channels, nil
+}
+
+// addEveryPlayerChannels adds the all_players channels to every player's channels, a player who already
+// lists one of them keeps their own options for it
+func addEveryPlayerChannels(channels map[string]channelList, everyPlayer channelList) {
+	for steamid, list := range channels {
+		for _, channel := range everyPlayer {
+			if !list.has(channel.Name) {
+				list = append(list, channel)
+			}
+		}
+		channels[steamid] = list
+	}
+}
+
+// everyPlayerChannels returns the all_players channels in the mapping
+func everyPlayerChannels(channels map[string]channelList) channelList {
+	var everyPlayer channelList
+	for _, list := range channels {
+		for _, channel := range list {
+			if channel.everyPlayer && !everyPlayer.has(channel.Name) {
+				everyPlayer = append(everyPlayer, channel)
+			}
+		}
+	}
+
+	return everyPlayer
+}
+
+// parseChannelPlayers parses a channel in the channel-centric config, either a list of steamids or an
+// object with the steamids under "players" along with the channel's options
+func parseChannelPlayers(name string, data []byte) (channelConfig, []string, error) {
+	if players, err := parseSteamIDList(data); err == nil {
+		return channelConfig{Name: normalizeChannelName(name)}, players, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return channelConfig{}, nil, fmt.Errorf("channel %v must be a list of steamids or an object, got %s", name, string(data))
+	}
+
+	players, err := parseSteamIDList(fields["players"])
+	if err != nil || len(players) == 0 {
+		return channelConfig{}, nil, fmt.Errorf("channel %v must list its steamids under \"players\"", name)
+	}
+	delete(fields, "players")
+
+	// the channel is named by its key, so parse the rest as a regular channel with that name
+	fields["channel"], _ = json.Marshal(name)
+	options, err := json.Marshal(fields)
+	if err != nil {
+		return channelConfig{}, nil, err
+	}
+
+	var channel channelConfig
+	if err := json.Unmarshal(options, &channel); err != nil {
+		return channelConfig{}, nil, err
+	}
+
+	return channel, players, nil
+}
+
+// parseSteamIDList parses a list of steamids, SteamID64s can be written as numbers since that's how YAML
+// reads them when they aren't quoted
+func parseSteamIDList(data []byte) ([]string, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	steamids := make([]string, 0, len(raw))
+	for _, r := range raw {
+		var steamid string
+		if err := json.Unmarshal(r, &steamid); err == nil {
+			steamids = append(steamids, steamid)
+			continue
+		}
+
+		var n json.Number
+		if err := json.Unmarshal(r, &n); err != nil {
+			return nil, fmt.Errorf("steamid must be a string or a number, got %s", string(r))
+		}
+		steamids = append(steamids, n.String())
+	}
+
+	return steamids, nil
+}
+
+// saveChannelsToFile writes the steamid to channels mapping back to the config file, in YAML if the file
+// has a .yaml or .yml extension and in JSON otherwise. Comments in a YAML config aren't kept. The
+// all_players channels are written under all_players rather than under each player.
+func saveChannelsToFile(path string, channels map[string]channelList) error {
+	config := map[string]channelList{}
+	for steamid, list := range channels {
+		own := channelList{}
+		for _, channel := range list {
+			if !channel.everyPlayer {
+				own = append(own, channel)
+			}
+		}
+		config[steamid] = own
+	}
+	if everyPlayer := everyPlayerChannels(channels); len(everyPlayer) > 0 {
+		config[allPlayersKey] = everyPlayer
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		if data, err = yaml.Marshal(v); err != nil {
+			return err
+		}
+	default:
+		data = append(data, '\n')
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// yamlToJSON converts a YAML document to JSON, so YAML configs are parsed with exactly the same rules as
+// JSON ones
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(jsonValue(v))
+}
+
+// jsonValue converts a decoded YAML value to one encoding/json can marshal. YAML allows non-string map
+// keys, e.g. SteamID64s written without quotes decode as ints, so keys are converted to strings.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = jsonValue(value)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+		return v
+	default:
+		return v
+	}
+}
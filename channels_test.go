@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadChannelsFromFileAcceptsStringsAndArrays(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), channelsFileName, `{
+		"76561198107240606": "lansky",
+		"76561197991735941": ["clockwork", "teamchannel"]
+	}`)
+
+	channels, err := loadChannelsFromFile(path)
+	if err != nil {
+		t.Fatalf("loadChannelsFromFile failed: %v", err)
+	}
+
+	want := map[string][]string{
+		testSteamID:  {"lansky"},
+		otherSteamID: {"clockwork", "teamchannel"},
+	}
+	got := map[string][]string{}
+	for steamid, list := range channels {
+		got[steamid] = list.names()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Loaded %v, want %v", got, want)
+	}
+}
+
+func TestChannelSpoilerDelay(t *testing.T) {
+	channels, err := parseChannels([]byte(`{
+		"76561198107240606": [
+			"lansky",
+			{"channel": "clockwork", "spoiler_delay": 90},
+			{"channel": "teamchannel", "spoiler_delay": "1m30s"},
+			{"channel": "nodelay", "spoiler_delay": 0}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+
+	want := []time.Duration{defaultSpoilerDelay, 90 * time.Second, 90 * time.Second, 0}
+	for i, channel := range channels[testSteamID] {
+		if got := channel.spoilerDelay(defaultSpoilerDelay); got != want[i] {
+			t.Errorf("%v has a spoiler delay of %v, want %v", channel.Name, got, want[i])
+		}
+	}
+}
+
+func TestChannelSpoilerDelayRejectsInvalidDelays(t *testing.T) {
+	for _, delay := range []string{`-5`, `"-5s"`, `"soon"`, `true`} {
+		if _, err := parseChannels([]byte(`{"76561198107240606": {"channel": "lansky", "spoiler_delay": ` + delay + `}}`)); err == nil {
+			t.Errorf("parseChannels accepted a spoiler_delay of %v", delay)
+		}
+	}
+}
+
+func TestYAMLAndJSONConfigsAreEquivalent(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := writeTestFile(t, dir, "channels.json", `{
+		"76561198107240606": [
+			"lansky",
+			{
+				"channel": "clockwork",
+				"spoiler_delay": "1m30s",
+				"template": "New log! {{.URL}}",
+				"formats": ["6s", "hl"],
+				"list_players": true,
+				"quiet_hours": {"start": "23:00", "end": "08:00"}
+			}
+		],
+		"76561197991735941": "teamchannel"
+	}`)
+	yamlPath := writeTestFile(t, dir, "channels.yml", `
+# SteamID64s can be written without quotes
+76561198107240606:
+  - lansky
+  - channel: clockwork
+    spoiler_delay: 1m30s
+    template: "New log! {{.URL}}"
+    formats: [6s, hl]
+    list_players: true
+    quiet_hours:
+      start: "23:00"
+      end: "08:00"
+"76561197991735941": teamchannel
+`)
+
+	fromJSON, err := loadChannelsFromFile(jsonPath)
+	if err != nil {
+		t.Fatalf("Failed to load the JSON config: %v", err)
+	}
+	fromYAML, err := loadChannelsFromFile(yamlPath)
+	if err != nil {
+		t.Fatalf("Failed to load the YAML config: %v", err)
+	}
+
+	// the parsed templates are separate values, so compare the configs the way they're saved
+	wantJSON, _ := json.Marshal(fromJSON)
+	gotJSON, _ := json.Marshal(fromYAML)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("The YAML config loaded as %s, want %s like the JSON config", gotJSON, wantJSON)
+	}
+	if got := fromYAML[testSteamID][1]; got.template == nil || got.spoilerDelay(0) != 90*time.Second {
+		t.Errorf("The YAML config's options weren't parsed: %+v", got)
+	}
+}
+
+func TestYAMLConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"broken.yaml":        "76561198107240606: [lansky",
+		"bad_template.yaml":  "76561198107240606: {channel: lansky, template: \"{{.URL\"}",
+		"not_a_mapping.yaml": "- lansky",
+	} {
+		if _, err := loadChannelsFromFile(writeTestFile(t, dir, name, contents)); err == nil {
+			t.Errorf("Loading %v succeeded, want an error", name)
+		}
+	}
+}
+
+func TestLoadChannelsFromTheConfiguredPath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "etc", "logs-bot")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create the config directory: %v", err)
+	}
+	path := writeTestFile(t, dir, "teams.json", `{"76561198107240606": "lansky"}`)
+	b := newTestBot(t, nil, nil, map[string]string{configEnvName: path})
+
+	channels, err := b.loadChannels(context.Background())
+	if err != nil {
+		t.Fatalf("loadChannels failed: %v", err)
+	}
+	if got := channels[testSteamID].names(); !reflect.DeepEqual(got, []string{testChannel}) {
+		t.Errorf("Loaded %v for %v, want [%v]", got, testSteamID, testChannel)
+	}
+}
+
+func TestLoadChannelsFromAMissingPath(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{configEnvName: filepath.Join(t.TempDir(), "missing.json")})
+
+	if _, err := b.loadChannels(context.Background()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("loadChannels returned %v, want a not exist error main can report", err)
+	}
+}
+
+func TestConfigPathDefaultsToChannelsJSON(t *testing.T) {
+	chdir(t, t.TempDir())
+	setCredentials(t)
+	t.Setenv(configEnvName, "")
+
+	b, err := newBotConfigFromEnv()
+	if err != nil {
+		t.Fatalf("newBotConfigFromEnv failed: %v", err)
+	}
+	if b.channelsFile != channelsFileName {
+		t.Errorf("Config path is %q, want %q", b.channelsFile, channelsFileName)
+	}
+}
+
+func TestConfigPathFallsBackToYAML(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	writeTestFile(t, dir, "channels.yml", "76561198107240606: lansky")
+
+	if got := findChannelsFile(); got != "channels.yml" {
+		t.Errorf("findChannelsFile = %q, want the YAML config that exists", got)
+	}
+}
+
+// chdir changes the working directory to dir until the test is done
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get the working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change the working directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestChannelCentricConfig(t *testing.T) {
+	const thirdSteamID = "76561197960287930"
+
+	channels, err := parseChannels([]byte(`{
+		"76561198107240606": "lansky",
+		"channels": {
+			"teamchannel": ["76561198107240606", 76561197991735941],
+			"#Lansky": ["76561198107240606"],
+			"clockwork": {"players": ["76561197991735941", "76561197960287930"], "spoiler_delay": "2m"}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+
+	// lansky is listed under both shapes but posted to once
+	want := map[string][]string{
+		testSteamID:  {"lansky", "teamchannel"},
+		otherSteamID: {"clockwork", "teamchannel"},
+		thirdSteamID: {"clockwork"},
+	}
+	got := map[string][]string{}
+	for steamid, list := range channels {
+		got[steamid] = list.names()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parsed %v, want %v", got, want)
+	}
+
+	for _, steamid := range []string{otherSteamID, thirdSteamID} {
+		if delay := channels[steamid][0].spoilerDelay(defaultSpoilerDelay); delay != 2*time.Minute {
+			t.Errorf("%v's clockwork channel has a spoiler delay of %v, want the channel's 2m", steamid, delay)
+		}
+	}
+}
+
+func TestChannelCentricConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		`{"channels": ["lansky"]}`,
+		`{"channels": {"lansky": "76561198107240606"}}`,
+		`{"channels": {"lansky": {"spoiler_delay": "2m"}}}`,
+		`{"channels": {"lansky": {"players": []}}}`,
+		`{"channels": {"lansky": {"players": ["76561198107240606"], "spoiler_delay": "soon"}}}`,
+	} {
+		if _, err := parseChannels([]byte(config)); err == nil {
+			t.Errorf("parseChannels accepted %v", config)
+		}
+	}
+}
+
+func TestNormalizeChannelName(t *testing.T) {
+	for _, name := range []string{"#Channel", "CHANNEL", "channel", " #channel "} {
+		if got := normalizeChannelName(name); got != "channel" {
+			t.Errorf("normalizeChannelName(%q) = %q, want %q", name, got, "channel")
+		}
+	}
+}
+
+func TestChannelNamesAreNormalizedOnLoad(t *testing.T) {
+	channels, err := parseChannels([]byte(`{
+		"76561198107240606": ["#Lansky", {"channel": "CLOCKWORK"}],
+		"channels": {"#TeamChannel": ["76561197991735941"], "otherchannel": {"players": ["76561197991735941"]}}
+	}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+
+	want := map[string][]string{
+		testSteamID:  {"clockwork", "lansky"},
+		otherSteamID: {"otherchannel", "teamchannel"},
+	}
+	got := map[string][]string{}
+	for steamid, list := range channels {
+		names := list.names()
+		sort.Strings(names)
+		got[steamid] = names
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parsed %v, want %v", got, want)
+	}
+
+	if _, err := parseChannels([]byte(`{"76561198107240606": "#"}`)); err == nil {
+		t.Error("parseChannels accepted a channel named just #")
+	}
+}
+
+func TestNormalizedChannelsAreSentToWithOneHash(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	channels, err := parseChannels([]byte(`{"76561198107240606": "#Lansky"}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+	b := newTestBot(t, logsTF, channels, nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	twitch.expect(t, "PRIVMSG #lansky :"+logsTF.URL+"/100 — serveme.tf #100 (cp_process_f12)")
+}
+
+func TestLoadChannelsFromTheEnvironment(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{channelsJSONEnvName: `{"76561198107240606": "lansky"}`})
+
+	// the config file doesn't exist, so the inline config is used
+	channels, err := b.loadChannels(context.Background())
+	if err != nil {
+		t.Fatalf("loadChannels failed: %v", err)
+	}
+	if got, want := channels[testSteamID].names(), []string{testChannel}; len(channels) != 1 || !reflect.DeepEqual(got, want) {
+		t.Errorf("Loaded %v, want %v posted to %v", channels, testSteamID, want)
+	}
+
+	// once the file exists it takes precedence
+	writeTestFile(t, filepath.Dir(b.channelsFile), filepath.Base(b.channelsFile), `{"76561197991735941": "clockwork"}`)
+	if channels, err = b.loadChannels(context.Background()); err != nil {
+		t.Fatalf("loadChannels failed: %v", err)
+	}
+	if got, want := channels[otherSteamID].names(), []string{otherTestChannel}; len(channels) != 1 || !reflect.DeepEqual(got, want) {
+		t.Errorf("Loaded %v, want the file's %v posted to %v", channels, otherSteamID, want)
+	}
+}
+
+func TestLoadChannelsFromTheEnvironmentErrors(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{channelsJSONEnvName: `{"76561198107240606": 5}`})
+	if _, err := b.loadChannels(context.Background()); err == nil || !strings.Contains(err.Error(), channelsJSONEnvName) {
+		t.Errorf("loadChannels returned %v, want an error about %v", err, channelsJSONEnvName)
+	}
+
+	// without either there's no config
+	b = newTestBot(t, nil, nil, map[string]string{channelsJSONEnvName: ""})
+	if _, err := b.loadChannels(context.Background()); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("loadChannels returned %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestAllPlayersChannels(t *testing.T) {
+	const thirdSteamID = "76561197960287930"
+
+	path := writeTestFile(t, t.TempDir(), channelsFileName, `{
+		"all_players": [{"channel": "tf2highlights", "list_players": true}],
+		"76561198107240606": "lansky",
+		"76561197991735941": ["clockwork", {"channel": "tf2highlights", "spoiler_delay": "0s"}],
+		"76561197960287930": []
+	}`)
+	channels, err := loadChannelsFromFile(path)
+	if err != nil {
+		t.Fatalf("loadChannelsFromFile failed: %v", err)
+	}
+
+	want := map[string][]string{
+		testSteamID:  {"lansky", "tf2highlights"},
+		otherSteamID: {"clockwork", "tf2highlights"},
+		thirdSteamID: {"tf2highlights"},
+	}
+	got := map[string][]string{}
+	for steamid, list := range channels {
+		got[steamid] = list.names()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Loaded %v, want %v", got, want)
+	}
+	// a player listing the channel themselves keeps their own options for it
+	if !channels[testSteamID][1].ListPlayers || channels[otherSteamID][1].ListPlayers {
+		t.Errorf("Loaded %+v and %+v, want only the all_players channel to list players", channels[testSteamID][1], channels[otherSteamID][1])
+	}
+
+	// saving writes the channel under all_players again rather than under every player
+	if err := saveChannelsToFile(path, channels); err != nil {
+		t.Fatalf("saveChannelsToFile failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read the saved config: %v", err)
+	}
+	var saved map[string]json.RawMessage
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Failed to parse the saved config %s: %v", data, err)
+	}
+	if strings.Contains(string(saved[testSteamID]), "tf2highlights") || !strings.Contains(string(saved[allPlayersKey]), "tf2highlights") {
+		t.Errorf("Saved %s, want tf2highlights under %v only", data, allPlayersKey)
+	}
+	if reloaded, err := loadChannelsFromFile(path); err != nil || !reflect.DeepEqual(reloaded, channels) {
+		t.Errorf("Reloading the saved config returned %v, %v, want %v", reloaded, err, channels)
+	}
+}
+
+func TestLoadChannelsFromDirMergesTheFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "lansky.json", `{"76561198107240606": ["lansky", {"channel": "teamchannel", "spoiler_delay": "1m"}]}`)
+	writeTestFile(t, dir, "clockwork.yaml", "\"76561197991735941\":\n  - clockwork\n  - channel: teamchannel\n    spoiler_delay: 1m\n")
+	writeTestFile(t, dir, "notes.txt", "not a config")
+	writeTestFile(t, dir, ".hidden.json", "{")
+
+	channels, err := loadChannelsFromFile(dir)
+	if err != nil {
+		t.Fatalf("loadChannelsFromFile failed: %v", err)
+	}
+
+	want := map[string][]string{
+		testSteamID:  {"lansky", "teamchannel"},
+		otherSteamID: {"clockwork", "teamchannel"},
+	}
+	got := map[string][]string{}
+	for steamid, list := range channels {
+		got[steamid] = list.names()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Loaded %v, want %v", got, want)
+	}
+}
+
+func TestLoadChannelsFromDirErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		lansky, team string // the contents of lansky.json and team.json
+	}{
+		{"the same player", `{"76561198107240606": "lansky"}`, `{"[U:1:146974878]": "teamchannel"}`},
+		{"a different template", `{"76561198107240606": "teamchannel"}`,
+			`{"76561197991735941": {"channel": "teamchannel", "template": "{{.URL}}"}}`},
+		{"a different spoiler_delay", `{"76561198107240606": {"channel": "teamchannel", "spoiler_delay": "1m"}}`,
+			`{"76561197991735941": {"channel": "teamchannel", "spoiler_delay": "2m"}}`},
+		{"different quiet_hours", `{"76561198107240606": {"channel": "teamchannel", "quiet_hours": {"start": "23:00", "end": "08:00"}}}`,
+			`{"76561197991735941": {"channel": "teamchannel", "quiet_hours": {"start": "22:00", "end": "08:00"}}}`},
+		{"all_players in one file only", `{"all_players": "teamchannel", "76561198107240606": "lansky"}`,
+			`{"76561197991735941": "teamchannel"}`},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		writeTestFile(t, dir, "lansky.json", test.lansky)
+		writeTestFile(t, dir, "team.json", test.team)
+
+		_, err := loadChannelsFromFile(dir)
+		if err == nil || !strings.Contains(err.Error(), "lansky.json") || !strings.Contains(err.Error(), "team.json") {
+			t.Errorf("Loading files with %v returned %v, want an error naming both files", test.name, err)
+		}
+	}
+
+	if _, err := loadChannelsFromFile(t.TempDir()); err == nil {
+		t.Error("Loading a directory without config files succeeded")
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chatMessage is a PRIVMSG sent to a channel the bot has joined, or a whisper sent to the bot
+type chatMessage struct {
+	user        string
+	displayName string
+	channel     string // without the leading #, empty for whispers
+	whisper     bool
+	text        string
+	badges      map[string]string // badge name to version, e.g. "moderator": "1"
+	tags        map[string]string
+}
+
+// isModerator reports whether the sender is a moderator or the broadcaster of the channel
+func (m chatMessage) isModerator() bool {
+	_, mod := m.badges["moderator"]
+	_, broadcaster := m.badges["broadcaster"]
+	return mod || broadcaster || m.tags["mod"] == "1" || m.user == m.channel
+}
+
+// parsePrivmsg parses a message like ":user!user@user.tmi.twitch.tv PRIVMSG #channel :hello", optionally
+// prefixed with IRCv3 tags, returning false if the message isn't a channel PRIVMSG
+func parsePrivmsg(msg ircMessage) (chatMessage, bool) {
+	target := msg.param(0)
+	if msg.command != "PRIVMSG" || !strings.HasPrefix(target, "#") {
+		return chatMessage{}, false
+	}
+
+	m, ok := parseUserMessage(msg)
+	m.channel = target[1:]
+	return m, ok
+}
+
+// parseWhisper parses a whisper to the bot like ":user!user@user.tmi.twitch.tv WHISPER botname :hello",
+// returning false if the message isn't a WHISPER
+func parseWhisper(msg ircMessage) (chatMessage, bool) {
+	if msg.command != "WHISPER" {
+		return chatMessage{}, false
+	}
+
+	m, ok := parseUserMessage(msg)
+	m.whisper = true
+	return m, ok
+}
+
+// parseUserMessage returns the chat message sent by a user, false if the message isn't from a user
+func parseUserMessage(msg ircMessage) (chatMessage, bool) {
+	user := msg.nick()
+	if user == "" || len(msg.params) == 0 {
+		return chatMessage{}, false
+	}
+
+	m := chatMessage{
+		user:        strings.ToLower(user),
+		displayName: msg.tags["display-name"],
+		text:        msg.trailing,
+		badges:      parseBadges(msg.tags["badges"]),
+		tags:        msg.tags,
+	}
+	if m.displayName == "" {
+		m.displayName = user
+	}
+
+	return m, true
+}
+
+// handleChatMessage runs the chat command in the message, if there is one
+func (b *botConfig) handleChatMessage(ctx context.Context, m chatMessage) {
+	fields := strings.Fields(m.text)
+	if len(fields) == 0 {
+		return
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "!lastlog":
+		if !b.commandAllowed(m.user) {
+			return
+		}
+		b.handleLastLogCommand(ctx, m, fields[1:])
+	case "!tracked":
+		if !b.commandAllowed(m.user) || !m.isModerator() {
+			return
+		}
+		b.handleTrackedCommand(ctx, m)
+	case "!status":
+		if !b.commandAllowed(m.user) || !m.isModerator() {
+			return
+		}
+		b.handleStatusCommand(ctx, m, fields[1:])
+	case "!logs":
+		if !b.commandAllowed(m.user) || !m.isModerator() {
+			return
+		}
+		b.handleLogsCommand(ctx, m, fields[1:])
+	case "!botstatus":
+		if !b.commandAllowed(m.user) || !m.isModerator() {
+			return
+		}
+		b.reply(ctx, m, b.stats.summary())
+	case "!replay":
+		if !b.adminUsers[m.user] {
+			return
+		}
+		b.handleReplayCommand(ctx, m, fields[1:])
+	}
+}
+
+// commandAllowed reports whether the user may run chat commands, everyone can if no allow-list is set
+func (b *botConfig) commandAllowed(user string) bool {
+	if len(b.commandUsers) == 0 {
+		return true
+	}
+
+	return b.commandUsers[user]
+}
+
+// handleLastLogCommand posts the newest log for the given steamid, or for the player tracked in the channel
+// if no steamid is given. The viewer asked for it, so unlike polling it's posted however old it is and
+// whether or not it was posted before.
+func (b *botConfig) handleLastLogCommand(ctx context.Context, m chatMessage, args []string) {
+	var steamid string
+	if len(args) > 0 {
+		steamid = args[0]
+	} else {
+		tracked := b.steamIDsForChannel(m.channel)
+		if len(tracked) != 1 {
+			b.reply(ctx, m, "Usage: !lastlog <steamid>")
+			return
+		}
+		steamid = tracked[0]
+	}
+
+	l, err := b.getNewestLogForPlayer(ctx, steamid)
+	if err != nil {
+		var invalidErr *invalidSteamIDError
+		switch {
+		case errors.Is(err, errNoLogs):
+			b.reply(ctx, m, "No logs found for "+steamid)
+		case errors.As(err, &invalidErr):
+			b.reply(ctx, m, "Invalid steamid "+steamid)
+		default:
+			slog.Error("Failed to get log for command", "steamid", steamid, "channel", m.channel, "error", err)
+			b.reply(ctx, m, "Couldn't get the latest log, try again later")
+		}
+		return
+	}
+
+	message, err := b.renderMessage(l, b.channelConfig(m.channel))
+	if err != nil {
+		slog.Error("Failed to render log for command", "log_id", l.ID, "channel", m.channel, "error", err)
+		return
+	}
+
+	b.reply(ctx, m, message)
+}
+
+// handleReplayCommand posts the log with the given id to the channel the way it would be posted for a
+// tracked player, for trying out the channel's message template. It doesn't change which logs have been
+// seen, so the log is still posted as normal if it turns up for a tracked player.
+func (b *botConfig) handleReplayCommand(ctx context.Context, m chatMessage, args []string) {
+	if len(args) != 1 {
+		b.reply(ctx, m, "Usage: !replay <log id>")
+		return
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id <= 0 {
+		b.reply(ctx, m, "Invalid log id "+args[0])
+		return
+	}
+
+	d, err := b.getLogDetail(ctx, id)
+	if statusErr, ok := err.(*httpStatusError); ok && statusErr.StatusCode == http.StatusNotFound {
+		b.reply(ctx, m, fmt.Sprintf("Log %d doesn't exist", id))
+		return
+	} else if err != nil {
+		slog.Error("Failed to get log for command", "log_id", id, "channel", m.channel, "error", err)
+		b.reply(ctx, m, "Couldn't get the log, try again later")
+		return
+	}
+
+	l := d.info
+	l.score, l.names = d.score, d.names
+	b.addLeagueMatch(ctx, &l)
+
+	message, err := b.renderMessage(&l, b.channelConfig(m.channel))
+	if err != nil {
+		slog.Error("Failed to render log for command", "log_id", id, "channel", m.channel, "error", err)
+		return
+	}
+
+	slog.Info("Replaying log", "log_id", id, "channel", m.channel, "user", m.user)
+	b.reply(ctx, m, message)
+}
+
+// handleTrackedCommand lists the players whose logs are posted to the channel, or just how many there are
+// if steamids are hidden
+func (b *botConfig) handleTrackedCommand(ctx context.Context, m chatMessage) {
+	tracked := b.steamIDsForChannel(m.channel)
+	if len(tracked) == 0 {
+		b.reply(ctx, m, "No players are tracked in this channel")
+		return
+	}
+
+	players := "players"
+	if len(tracked) == 1 {
+		players = "player"
+	}
+
+	if b.hideSteamIDs {
+		b.reply(ctx, m, fmt.Sprintf("Tracking %d %s", len(tracked), players))
+		return
+	}
+
+	sort.Strings(tracked)
+	b.reply(ctx, m, fmt.Sprintf("Tracking %d %s: %s", len(tracked), players, strings.Join(tracked, ", ")))
+}
+
+// handleStatusCommand says how checking the player's logs is going, for the given steamid or the channel's
+// tracked player if no steamid is given
+func (b *botConfig) handleStatusCommand(ctx context.Context, m chatMessage, args []string) {
+	var steamid string
+	if len(args) > 0 {
+		steamid = args[0]
+	} else {
+		tracked := b.steamIDsForChannel(m.channel)
+		if len(tracked) != 1 {
+			b.reply(ctx, m, "Usage: !status <steamid>")
+			return
+		}
+		steamid = tracked[0]
+	}
+
+	tracked := b.trackedSteamID(steamid)
+	if _, ok := b.playerChannels()[tracked]; !ok {
+		b.reply(ctx, m, steamid+" isn't tracked")
+		return
+	}
+
+	s, ok := b.statuses.get(tracked)
+	if !ok {
+		b.reply(ctx, m, steamid+" hasn't been checked yet")
+		return
+	}
+
+	var parts []string
+	if s.LastSuccess.IsZero() {
+		parts = append(parts, "no successful check yet")
+	} else {
+		parts = append(parts, "last checked "+formatAgo(s.LastSuccess))
+	}
+	if s.LastError != "" {
+		parts = append(parts, "last error "+formatAgo(s.LastErrorAt)+": "+s.LastError)
+	}
+
+	b.reply(ctx, m, steamid+": "+strings.Join(parts, ", "))
+}
+
+// trackedSteamID returns the steamid as it's written in the config, which may be in a different format than
+// the one given, or the steamid unchanged if it isn't tracked
+func (b *botConfig) trackedSteamID(steamid string) string {
+	steamID64, err := normalizeSteamID(steamid)
+	if err != nil {
+		return steamid
+	}
+
+	for id := range b.playerChannels() {
+		if normalized, err := normalizeSteamID(id); err == nil && normalized == steamID64 {
+			return id
+		}
+	}
+
+	return steamid
+}
+
+// formatAgo describes how long ago t was, e.g. "3m10s ago"
+func formatAgo(t time.Time) string {
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+// handleLogsCommand turns log posts in the channel on or off, or says whether they're on
+func (b *botConfig) handleLogsCommand(ctx context.Context, m chatMessage, args []string) {
+	if len(args) != 1 {
+		if b.paused.isPaused(m.channel) {
+			b.reply(ctx, m, "Log posts are off in this channel, use !logs on to turn them back on")
+		} else {
+			b.reply(ctx, m, "Log posts are on in this channel, use !logs off to turn them off")
+		}
+		return
+	}
+
+	var paused bool
+	switch strings.ToLower(args[0]) {
+	case "on":
+		paused = false
+	case "off":
+		paused = true
+	default:
+		b.reply(ctx, m, "Usage: !logs on|off")
+		return
+	}
+
+	if err := b.paused.set(m.channel, paused); err != nil {
+		slog.Error("Failed to save paused channels", "path", b.paused.path, "error", err)
+		b.reply(ctx, m, "Couldn't save the change, try again later")
+		return
+	}
+
+	slog.Info("Changed log posts for channel", "channel", m.channel, "user", m.user, "paused", paused)
+	if paused {
+		b.reply(ctx, m, "Log posts are now off in this channel")
+	} else {
+		b.reply(ctx, m, "Log posts are now on in this channel")
+	}
+}
+
+// reply posts the text to the channel the message came from
+func (b *botConfig) reply(ctx context.Context, m chatMessage, text string) {
+	if err := b.send(ctx, "PRIVMSG #%s :%s", m.channel, truncateMessage(text, b.maxMessageLength, "")); err != nil {
+		slog.Error("Failed to reply to command", "channel", m.channel, "error", err)
+	}
+}
+
+// steamIDsForChannel returns the steamids whose logs are posted to the channel
+func (b *botConfig) steamIDsForChannel(channel string) []string {
+	var steamids []string
+	for steamid, channels := range b.playerChannels() {
+		for _, c := range channels {
+			if c.Name == channel {
+				steamids = append(steamids, steamid)
+				break
+			}
+		}
+	}
+
+	return steamids
+}
+
+// channelConfig returns the config for the channel, or the defaults if it isn't configured
+func (b *botConfig) channelConfig(channel string) channelConfig {
+	for _, channels := range b.playerChannels() {
+		for _, c := range channels {
+			if c.Name == channel {
+				return c
+			}
+		}
+	}
+
+	return channelConfig{Name: channel}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// chatLine is a PRIVMSG from the user to the channel, the way Twitch sends it
+func chatLine(user, channel, text string) string {
+	return ":" + user + "!" + user + "@" + user + ".tmi.twitch.tv PRIVMSG #" + channel + " :" + text
+}
+
+func TestLastLogCommandPostsTheChannelsPlayersNewestLog(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	// the log is older than anything polling would post, and was already seen
+	logsTF.setLogs(testSteamID, testLog(100, 48*time.Hour))
+	b.steamIDToLastLog[testSteamID] = lastLog{Time: time.Now(), ID: 100}
+	readInBackground(t, b)
+
+	twitch.write(t, chatLine("viewer", testChannel, "!lastlog"))
+	if got, want := twitch.nextMatching(t, "PRIVMSG "), "PRIVMSG #lansky :"+logsTF.URL+"/100 — serveme.tf #100 (cp_process_f12)"; got != want {
+		t.Errorf("Replied %q, want %q", got, want)
+	}
+}
+
+func TestLastLogCommandWithASteamID(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(otherSteamID, testLog(101, time.Hour))
+	logsTF.errors["76561197960287930"] = "Invalid steamid"
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	tests := map[string]string{
+		"!lastlog " + otherSteamID:           "PRIVMSG #lansky :" + logsTF.URL + "/101 — serveme.tf #101 (cp_process_f12)",
+		"!lastlog " + testSteamID:            "PRIVMSG #lansky :No logs found for " + testSteamID,
+		"!lastlog 76561197960287930":         "PRIVMSG #lansky :Invalid steamid 76561197960287930",
+		"!lastlog notasteamid":               "PRIVMSG #lansky :Invalid steamid notasteamid",
+		"!LastLog " + otherSteamID + " more": "PRIVMSG #lansky :" + logsTF.URL + "/101 — serveme.tf #101 (cp_process_f12)",
+	}
+	for text, want := range tests {
+		b.handleChatMessage(context.Background(), chatMessage{user: "viewer", channel: testChannel, text: text})
+		if got := twitch.flush(t, b); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("%q replied %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestLastLogCommandNeedsASteamIDInSharedChannels(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, newFakeLogsTF(t), players([]string{testSteamID, otherSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	b.handleChatMessage(context.Background(), chatMessage{user: "viewer", channel: testChannel, text: "!lastlog"})
+	if got, want := twitch.flush(t, b), []string{"PRIVMSG #lansky :Usage: !lastlog <steamid>"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Replied %q, want %q", got, want)
+	}
+}
+
+func TestCommandsAreLimitedToTheAllowedUsers(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Hour))
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{commandUsersEnvName: "Lansky,clockwork"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	b.handleChatMessage(context.Background(), chatMessage{user: "viewer", channel: testChannel, text: "!lastlog"})
+	if got := twitch.flush(t, b); len(got) != 0 {
+		t.Errorf("Replied %q to a user who isn't allowed to run commands", got)
+	}
+
+	b.handleChatMessage(context.Background(), chatMessage{user: "lansky", channel: testChannel, text: "!lastlog"})
+	if got := privmsgs(twitch.flush(t, b)); len(got) != 1 {
+		t.Errorf("Replied %q to an allowed user, want the log", got)
+	}
+}
+
+func TestTrackedCommandListsTheChannelsPlayers(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	channels := players([]string{testSteamID, otherSteamID}, testChannel)
+	channels["76561197960287930"] = channelList{{Name: otherTestChannel}}
+	b := newTestBot(t, nil, channels, nil)
+	connectTestBot(t, b, twitch, testChannel)
+	readInBackground(t, b)
+
+	twitch.write(t, "@badges=moderator/1;display-name=Mod :mod!mod@mod.tmi.twitch.tv PRIVMSG #lansky :!tracked")
+	want := "PRIVMSG #lansky :Tracking 2 players: " + otherSteamID + ", " + testSteamID
+	if got := twitch.nextMatching(t, "PRIVMSG "); got != want {
+		t.Errorf("Replied %q, want %q", got, want)
+	}
+}
+
+func TestTrackedCommandIsForModerators(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, players([]string{testSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	tests := []struct {
+		m    chatMessage
+		want []string
+	}{
+		{chatMessage{user: "viewer", channel: testChannel, text: "!tracked", badges: map[string]string{"subscriber": "12"}}, nil},
+		{chatMessage{user: "mod", channel: testChannel, text: "!tracked", badges: map[string]string{"moderator": "1"}}, []string{"PRIVMSG #lansky :Tracking 1 player: " + testSteamID}},
+		{chatMessage{user: testChannel, channel: testChannel, text: "!tracked"}, []string{"PRIVMSG #lansky :Tracking 1 player: " + testSteamID}},
+		{chatMessage{user: "mod", channel: "empty", text: "!tracked", tags: map[string]string{"mod": "1"}}, []string{"PRIVMSG #empty :No players are tracked in this channel"}},
+	}
+	for _, test := range tests {
+		b.handleChatMessage(context.Background(), test.m)
+		if got := twitch.flush(t, b); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("!tracked from %v in #%v replied %q, want %q", test.m.user, test.m.channel, got, test.want)
+		}
+	}
+}
+
+func TestTrackedCommandCanHideSteamIDs(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, players([]string{testSteamID, otherSteamID}, testChannel), map[string]string{hideSteamIDsEnvName: "true"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	b.handleChatMessage(context.Background(), chatMessage{user: testChannel, channel: testChannel, text: "!tracked"})
+	if got, want := twitch.flush(t, b), []string{"PRIVMSG #lansky :Tracking 2 players"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Replied %q, want %q", got, want)
+	}
+}
+
+func TestLastLogCommandSkipsTheStaleCheckPollingEnforces(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{staleLogThresholdEnvName: "1h"})
+	connectTestBot(t, b, twitch, testChannel)
+	logsTF.setLogs(testSteamID, testLog(100, 2*time.Hour))
+
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 0 {
+		t.Fatalf("Polling posted %q, want the log older than %v skipped", messages, b.staleLogThreshold)
+	}
+
+	b.handleChatMessage(context.Background(), chatMessage{user: "viewer", channel: testChannel, text: "!lastlog"})
+	want := []string{"PRIVMSG #lansky :" + logsTF.URL + "/100 — serveme.tf #100 (cp_process_f12)"}
+	if got := twitch.flush(t, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("!lastlog replied %q, want the stale log %q", got, want)
+	}
+}
+
+func TestReplayCommandPostsTheLog(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setDetail(123, `{"info": {"title": "RGL: lansky vs clockwork", "map": "cp_process_f12", "date": 1680374520},
+		"teams": {"Red": {"score": 5}, "Blue": {"score": 3}}, "length": 1800}`)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{adminUsersEnvName: "admin"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	tests := map[string]string{
+		"!replay 123":      "PRIVMSG #lansky :" + logsTF.URL + "/123 — RGL: lansky vs clockwork (cp_process_f12) (RED 5 - 3 BLU)",
+		"!replay 124":      "PRIVMSG #lansky :Log 124 doesn't exist",
+		"!replay abc":      "PRIVMSG #lansky :Invalid log id abc",
+		"!replay -1":       "PRIVMSG #lansky :Invalid log id -1",
+		"!replay":          "PRIVMSG #lansky :Usage: !replay <log id>",
+		"!replay 123 more": "PRIVMSG #lansky :Usage: !replay <log id>",
+	}
+	for text, want := range tests {
+		b.handleChatMessage(context.Background(), chatMessage{user: "admin", channel: testChannel, text: text})
+		if got := twitch.flush(t, b); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("%q replied %q, want %q", text, got, want)
+		}
+	}
+
+	if _, ok := b.steamIDToLastLog[testSteamID]; ok {
+		t.Errorf("Replaying set the player's last seen log to %v", b.steamIDToLastLog[testSteamID])
+	}
+}
+
+func TestReplayCommandIsForAdmins(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setDetail(123, `{"info": {"title": "RGL: lansky vs clockwork", "map": "cp_process_f12"}}`)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{adminUsersEnvName: "admin"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	b.handleChatMessage(context.Background(), chatMessage{user: testChannel, channel: testChannel, text: "!replay 123"})
+	if got := twitch.flush(t, b); len(got) != 0 {
+		t.Errorf("Replied %q to the broadcaster, who isn't an admin", got)
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// newBotConfigFromEnv builds the bot's configuration from the environment, using defaults for anything
+// that isn't set and rejecting values that don't make sense
+func newBotConfigFromEnv() (*botConfig, error) {
+	userName, err := readSecret(userNameEnvName)
+	if err != nil {
+		return nil, err
+	}
+	oauthKey, err := readSecret(oauthKeyEnvName)
+	if err != nil {
+		return nil, err
+	}
+	steamAPIKey, err := readSecret(steamAPIKeyEnvName)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &botConfig{
+		userName:    userName,
+		oauthKey:    oauthKey,
+		steamAPIKey: steamAPIKey,
+		writeMutex:  &sync.Mutex{},
+		mutex:       &sync.Mutex{},
+		posted:      newPostedLogs(),
+		posts:       &sync.WaitGroup{},
+		details:     newDetailCache(),
+		vanityNames: newVanityCache(),
+		logPlayers:  newLogPlayers(),
+		statuses:    newPlayerStatuses(),
+		stats:       newSessionStats(),
+		pollErrors:  newPollErrorLog(),
+		invalidIDs:  newInvalidSteamIDs(),
+		joined:      newJoinedChannels(),
+		configMutex: &sync.Mutex{},
+	}
+
+	if b.userName == "" || b.oauthKey == "" {
+		return nil, fmt.Errorf("Environment variables %v and %v not set, set them or %v%v and %v%v.", userNameEnvName, oauthKeyEnvName,
+			userNameEnvName, secretFileSuffix, oauthKeyEnvName, secretFileSuffix)
+	}
+
+	var p envParser
+	b.staleLogThreshold = p.duration(staleLogThresholdEnvName, defaultStaleLogThreshold)
+	b.spoilerDelay = p.nonNegativeDuration(spoilerDelayEnvName, defaultSpoilerDelay)
+	b.logRefreshTime = p.duration(logRefreshTimeEnvName, defaultLogRefreshTime)
+	b.pollWorkers = p.int(pollWorkersEnvName, defaultPollWorkers)
+	b.searchLimit = p.int(searchLimitEnvName, defaultSearchLimit)
+	b.postSlots = make(chan struct{}, p.int(maxPostsEnvName, defaultMaxPosts))
+	b.strictConfig = p.bool(strictConfigEnvName, false)
+	b.dryRun = p.bool(dryRunEnvName, false)
+	b.seedOnStartup = p.bool(seedOnStartupEnvName, false)
+	b.startupDelay = p.nonNegativeDuration(startupDelayEnvName, 0)
+	gameFormats := p.string(gameFormatsEnvName, defaultGameFormats)
+	b.allowedFormats = p.set(allowedFormatsEnvName)
+	b.allowedMaps = p.list(allowedMapsEnvName)
+	b.deniedMaps = p.list(deniedMapsEnvName)
+	postInterval := p.nonNegativeDuration(postIntervalEnvName, 0)
+	b.maxMessageLength = p.int(maxMessageLengthEnvName, defaultMaxMessageLength)
+	postPolicy := p.string(postPolicyEnvName, postPolicyQueue)
+	timezone := p.string(timezoneEnvName, "UTC")
+	b.timeFormat = p.string(timeFormatEnvName, defaultTimeFormat)
+	playerCooldown := p.nonNegativeDuration(playerCooldownEnvName, 0)
+
+	b.httpTimeout = p.duration(httpTimeoutEnvName, defaultHTTPTimeout)
+	logsTFBaseURL := p.string(logsTFBaseURLEnvName, defaultLogsTFBaseURL)
+	logsTFMirrors := p.string(logsTFMirrorsEnvName, "")
+	b.logLinkFormat = p.string(logLinkFormatEnvName, "")
+	leagueAPIURL := p.string(leagueAPIURLEnvName, "")
+	b.webhookURL = p.string(webhookURLEnvName, "")
+	steamAPIURL := p.string(steamAPIURLEnvName, defaultSteamAPIURL)
+	b.userAgent = p.string(userAgentEnvName, defaultUserAgent())
+	logCacheTTL := p.nonNegativeDuration(logCacheTTLEnvName, defaultLogCacheTTL)
+	breakerThreshold := p.int(breakerThresholdEnvName, defaultBreakerThreshold)
+	breakerCooldown := p.duration(breakerCooldownEnvName, defaultBreakerCooldown)
+	b.rateLimitBurst = p.int(rateLimitBurstEnvName, defaultRateLimitBurst)
+	b.rateLimitPeriod = p.duration(rateLimitPeriodEnvName, defaultRateLimitPeriod)
+
+	useTLS := p.bool(useTLSEnvName, true)
+	tlsSkipVerify := p.bool(tlsSkipVerifyEnvName, false)
+
+	b.reconnectBase = p.duration(reconnectBaseEnvName, defaultReconnectBase)
+	b.reconnectMax = p.duration(reconnectMaxEnvName, defaultReconnectMax)
+	b.reconnectReset = p.duration(reconnectResetEnvName, defaultReconnectReset)
+	b.readTimeout = p.duration(readTimeoutEnvName, defaultReadTimeout)
+	b.writeTimeout = p.duration(writeTimeoutEnvName, defaultWriteTimeout)
+
+	b.channelsJSON = p.string(channelsJSONEnvName, "")
+	b.configURL = p.string(configURLEnvName, "")
+	b.configRefresh = p.duration(configRefreshEnvName, defaultConfigRefresh)
+	b.configCache = p.string(configCacheEnvName, defaultConfigCacheFileName)
+	b.channelsFile = p.string(configEnvName, "")
+	if b.channelsFile == "" {
+		b.channelsFile = findChannelsFile()
+	}
+	b.stateFileName = p.string(stateFileEnvName, defaultStateFileName)
+	b.pausedChannelsFile = p.string(pausedChannelsEnvName, defaultPausedChannelsFileName)
+	b.postedLogsFile = p.string(postedLogsEnvName, defaultPostedLogsFileName)
+	b.dedupWindow = p.nonNegativeDuration(dedupWindowEnvName, 0)
+	messageTemplate := p.string(messageTemplateEnvName, defaultMessageTemplate)
+	b.commandUsers = p.set(commandUsersEnvName)
+	b.hideSteamIDs = p.bool(hideSteamIDsEnvName, false)
+	b.adminUsers = p.set(adminUsersEnvName)
+	b.httpAddr = p.string(httpAddrEnvName, defaultHTTPAddr)
+	metricsEnabled := p.bool(metricsEnabledEnvName, true)
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	u, err := url.Parse(logsTFBaseURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" {
+		return nil, fmt.Errorf("Invalid value for %v: %q, expected an http or https URL like %v", logsTFBaseURLEnvName, logsTFBaseURL, defaultLogsTFBaseURL)
+	}
+	b.logsTFBaseURL = strings.TrimSuffix(logsTFBaseURL, "/")
+
+	// links always point at the base URL, the mirrors are only polled
+	mirrors := []string{b.logsTFBaseURL}
+	for _, mirror := range strings.Split(logsTFMirrors, ",") {
+		if mirror = strings.TrimSpace(mirror); mirror == "" {
+			continue
+		}
+		u, err := url.Parse(mirror)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" {
+			return nil, fmt.Errorf("Invalid value for %v: %q, expected comma separated http or https URLs", logsTFMirrorsEnvName, mirror)
+		}
+		mirrors = append(mirrors, strings.TrimSuffix(mirror, "/"))
+	}
+	b.mirrors = newMirrorPool(mirrors)
+
+	if b.logLinkFormat != "" && !strings.Contains(b.logLinkFormat, logLinkIDPlaceholder) {
+		return nil, fmt.Errorf("Invalid value for %v: %q, expected a link with %v where the log's id goes, like https://logs.example.com/%v",
+			logLinkFormatEnvName, b.logLinkFormat, logLinkIDPlaceholder, logLinkIDPlaceholder)
+	}
+
+	if b.configURL != "" {
+		u, err := url.Parse(b.configURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("Invalid value for %v: %q, expected an http or https URL", configURLEnvName, b.configURL)
+		}
+	}
+
+	if b.webhookURL != "" {
+		u, err := url.Parse(b.webhookURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("Invalid value for %v: %q, expected an http or https URL", webhookURLEnvName, b.webhookURL)
+		}
+		b.webhookEvents = make(chan webhookEvent, webhookQueueSize)
+	}
+
+	if leagueAPIURL != "" {
+		u, err := url.Parse(leagueAPIURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" {
+			return nil, fmt.Errorf("Invalid value for %v: %q, expected an http or https URL", leagueAPIURLEnvName, leagueAPIURL)
+		}
+		b.leagueAPIURL = strings.TrimSuffix(leagueAPIURL, "/")
+	}
+
+	u, err = url.Parse(steamAPIURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" {
+		return nil, fmt.Errorf("Invalid value for %v: %q, expected an http or https URL like %v", steamAPIURLEnvName, steamAPIURL, defaultSteamAPIURL)
+	}
+	b.steamAPIURL = strings.TrimSuffix(steamAPIURL, "/")
+
+	if b.timeLocation, err = time.LoadLocation(timezone); err != nil {
+		return nil, fmt.Errorf("Invalid value for %v: %q, expected a timezone like Europe/Berlin or America/New_York: %v", timezoneEnvName, timezone, err)
+	}
+
+	t, err := parseMessageTemplate(messageTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid value for %v: %v", messageTemplateEnvName, err)
+	}
+	b.messageTemplate = t
+
+	if postPolicy != postPolicyQueue && postPolicy != postPolicyDrop {
+		return nil, fmt.Errorf("Invalid value for %v: %q, expected %v or %v", postPolicyEnvName, postPolicy, postPolicyQueue, postPolicyDrop)
+	}
+	b.pacer = newChannelPacer(postInterval, postPolicy)
+	b.cooldowns = newPlayerCooldowns(playerCooldown)
+
+	if b.gameFormats, err = parseGameFormats(gameFormats); err != nil {
+		return nil, fmt.Errorf("Invalid value for %v: %v", gameFormatsEnvName, err)
+	}
+
+	if b.reconnectMax < b.reconnectBase {
+		return nil, fmt.Errorf("%v must not be less than %v", reconnectMaxEnvName, reconnectBaseEnvName)
+	}
+
+	b.ircAddr = twitchIRCHostPort
+	if useTLS {
+		b.tlsConfig = &tls.Config{InsecureSkipVerify: tlsSkipVerify}
+		b.ircAddr = twitchIRCTLSHostPort
+	}
+	b.ircAddr = p.string(ircAddrEnvName, b.ircAddr)
+
+	b.metrics = noopMetrics{}
+	if metricsEnabled {
+		b.metrics = newPromMetrics()
+	}
+
+	b.dial = b.dialIRC
+	b.limiter = newRateLimiter(b.rateLimitBurst, b.rateLimitPeriod)
+	b.httpClient = &http.Client{Timeout: b.httpTimeout}
+	b.logCache = newLogCache(logCacheTTL)
+	b.breaker = newCircuitBreaker(breakerThreshold, breakerCooldown, b.metrics.setCircuitState)
+	return b, nil
+}
+
+// logConfig logs the effective configuration, leaving out the credentials
+func (b *botConfig) logConfig() {
+	slog.Info("Config",
+		"irc_addr", b.ircAddr,
+		"tls", b.tlsConfig != nil,
+		"user", b.userName,
+		"poll_interval", b.logRefreshTime.String(),
+		"poll_workers", b.pollWorkers,
+		"search_limit", b.searchLimit,
+		"max_concurrent_posts", cap(b.postSlots),
+		"spoiler_delay", b.spoilerDelay.String(),
+		"stale_threshold", b.staleLogThreshold.String(),
+		"logs_base_url", b.logsTFBaseURL,
+		"logs_mirrors", len(b.mirrors.mirrors)-1,
+		"log_link_format", b.logLinkFormat,
+		"http_timeout", b.httpTimeout.String(),
+		"league_api_url", b.leagueAPIURL,
+		"webhook_url_set", b.webhookURL != "",
+		"steam_api_url", b.steamAPIURL,
+		"steam_api_key_set", b.steamAPIKey != "",
+		"user_agent", b.userAgent,
+		"cache_ttl", b.logCache.ttl.String(),
+		"circuit_breaker_threshold", b.breaker.threshold,
+		"circuit_breaker_cooldown", b.breaker.cooldown.String(),
+		"rate_limit_burst", b.rateLimitBurst,
+		"rate_limit_period", b.rateLimitPeriod.String(),
+		"reconnect_base", b.reconnectBase.String(),
+		"reconnect_max", b.reconnectMax.String(),
+		"reconnect_reset", b.reconnectReset.String(),
+		"read_timeout", b.readTimeout.String(),
+		"write_timeout", b.writeTimeout.String(),
+		"channels_file", b.channelsFile,
+		"config_url_set", b.configURL != "",
+		"config_refresh", b.configRefresh.String(),
+		"config_cache_file", b.configCache,
+		"state_file", b.stateFileName,
+		"paused_channels_file", b.pausedChannelsFile,
+		"posted_logs_file", b.postedLogsFile,
+		"dedup_window", b.dedupWindow.String(),
+		"http_addr", b.httpAddr,
+		"metrics", b.metrics != noopMetrics{},
+		"channel_post_interval", b.pacer.interval.String(),
+		"timezone", b.timeLocation.String(),
+		"player_cooldown", b.cooldowns.cooldown.String(),
+		"dry_run", b.dryRun,
+		"seed_on_startup", b.seedOnStartup,
+		"startup_delay", b.startupDelay.String())
+}
+
+// secretFileSuffix is added to the name of a variable holding a secret to give it as a file instead
+const secretFileSuffix = "_FILE"
+
+// readSecret returns the environment variable's value, or the contents of the file named by the variable
+// with secretFileSuffix (e.g. LOGS_BOT_OAUTH_KEY_FILE) if that's set, so secrets can be mounted as files
+// rather than showing up in the environment
+func readSecret(name string) (string, error) {
+	path := os.Getenv(name + secretFileSuffix)
+	if path == "" {
+		return os.Getenv(name), nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read %v: %v", name+secretFileSuffix, err)
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// envParser reads typed values from environment variables, falling back to a default for unset variables.
+// The first invalid value is remembered in err so a batch of variables can be parsed before checking.
+type envParser struct {
+	err error
+}
+
+func (p *envParser) fail(name, v, expected string) {
+	if p.err == nil {
+		p.err = fmt.Errorf("Invalid value for %v: %q, expected %v", name, v, expected)
+	}
+}
+
+func (p *envParser) string(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return def
+}
+
+// list parses a comma separated list of lowercased values
+func (p *envParser) list(name string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// set parses a comma separated list into a set of lowercased values
+func (p *envParser) set(name string) map[string]bool {
+	values := map[string]bool{}
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			values[v] = true
+		}
+	}
+
+	return values
+}
+
+// duration parses a positive Go duration (e.g. "10s")
+func (p *envParser) duration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		p.fail(name, v, "a positive duration")
+		return def
+	}
+
+	return d
+}
+
+// nonNegativeDuration parses a Go duration that may be zero
+func (p *envParser) nonNegativeDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		p.fail(name, v, "a non-negative duration")
+		return def
+	}
+
+	return d
+}
+
+// int parses a positive integer
+func (p *envParser) int(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		p.fail(name, v, "a positive integer")
+		return def
+	}
+
+	return n
+}
+
+// bool parses a boolean (e.g. "true", "0")
+func (p *envParser) bool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.fail(name, v, "a boolean")
+		return def
+	}
+
+	return b
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setCredentials sets the only variables the bot can't start without
+func setCredentials(t *testing.T) {
+	t.Helper()
+
+	t.Setenv(userNameEnvName, testUserName)
+	t.Setenv(oauthKeyEnvName, "oauth:secret")
+}
+
+func TestNewBotConfigFromEnvDefaults(t *testing.T) {
+	setCredentials(t)
+
+	b, err := newBotConfigFromEnv()
+	if err != nil {
+		t.Fatalf("newBotConfigFromEnv failed: %v", err)
+	}
+
+	durations := map[string][2]time.Duration{
+		staleLogThresholdEnvName: {b.staleLogThreshold, defaultStaleLogThreshold},
+		spoilerDelayEnvName:      {b.spoilerDelay, defaultSpoilerDelay},
+		logRefreshTimeEnvName:    {b.logRefreshTime, defaultLogRefreshTime},
+		httpTimeoutEnvName:       {b.httpTimeout, defaultHTTPTimeout},
+		reconnectBaseEnvName:     {b.reconnectBase, defaultReconnectBase},
+		reconnectMaxEnvName:      {b.reconnectMax, defaultReconnectMax},
+		reconnectResetEnvName:    {b.reconnectReset, defaultReconnectReset},
+	}
+	for name, d := range durations {
+		if d[0] != d[1] {
+			t.Errorf("%v defaulted to %v, want %v", name, d[0], d[1])
+		}
+	}
+	if b.ircAddr != twitchIRCTLSHostPort || b.tlsConfig == nil {
+		t.Errorf("Connecting to %v with TLS: %v, want %v with TLS", b.ircAddr, b.tlsConfig != nil, twitchIRCTLSHostPort)
+	}
+}
+
+func TestNewBotConfigFromEnvParses(t *testing.T) {
+	setCredentials(t)
+	t.Setenv(staleLogThresholdEnvName, "5m")
+	t.Setenv(spoilerDelayEnvName, "0s")
+	t.Setenv(logRefreshTimeEnvName, "30s")
+	t.Setenv(reconnectBaseEnvName, "2s")
+	t.Setenv(reconnectMaxEnvName, "1m")
+	t.Setenv(useTLSEnvName, "false")
+
+	b, err := newBotConfigFromEnv()
+	if err != nil {
+		t.Fatalf("newBotConfigFromEnv failed: %v", err)
+	}
+
+	if b.staleLogThreshold != 5*time.Minute || b.spoilerDelay != 0 || b.logRefreshTime != 30*time.Second ||
+		b.reconnectBase != 2*time.Second || b.reconnectMax != time.Minute {
+		t.Errorf("Parsed stale threshold %v, spoiler delay %v, poll interval %v, reconnect base %v and max %v",
+			b.staleLogThreshold, b.spoilerDelay, b.logRefreshTime, b.reconnectBase, b.reconnectMax)
+	}
+	if b.ircAddr != twitchIRCHostPort || b.tlsConfig != nil {
+		t.Errorf("Connecting to %v with TLS: %v, want %v without TLS", b.ircAddr, b.tlsConfig != nil, twitchIRCHostPort)
+	}
+}
+
+func TestNewBotConfigFromEnvRejectsInvalidValues(t *testing.T) {
+	tests := []struct {
+		name, value string
+		env         map[string]string
+		wantErr     string // what the error has to mention
+	}{
+		{name: staleLogThresholdEnvName, value: "0s"},
+		{name: logRefreshTimeEnvName, value: "-10s"},
+		{name: logRefreshTimeEnvName, value: "10"},
+		{name: spoilerDelayEnvName, value: "-1s"},
+		{name: pollWorkersEnvName, value: "0"},
+		{name: rateLimitBurstEnvName, value: "many"},
+		{name: useTLSEnvName, value: "sometimes"},
+		{name: reconnectMaxEnvName, value: "1s", env: map[string]string{reconnectBaseEnvName: "2s"}},
+		{name: userNameEnvName, value: "", wantErr: oauthKeyEnvName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"="+tt.value, func(t *testing.T) {
+			setCredentials(t)
+			t.Setenv(tt.name, tt.value)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			wantErr := tt.wantErr
+			if wantErr == "" {
+				wantErr = tt.name
+			}
+			if _, err := newBotConfigFromEnv(); err == nil || !strings.Contains(err.Error(), wantErr) {
+				t.Errorf("newBotConfigFromEnv returned %v, want an error about %v", err, wantErr)
+			}
+		})
+	}
+}
+
+func TestCredentialsFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := writeTestFile(t, dir, "oauth_key", "  oauth:fromfile\n")
+	userFile := writeTestFile(t, dir, "username", "filebot\n")
+
+	tests := []struct {
+		name               string
+		env                map[string]string
+		userName, oauthKey string
+	}{
+		{
+			name:     "env",
+			userName: testUserName,
+			oauthKey: "oauth:secret",
+		},
+		{
+			name: "files",
+			env: map[string]string{
+				userNameEnvName: "", oauthKeyEnvName: "",
+				userNameEnvName + secretFileSuffix: userFile, oauthKeyEnvName + secretFileSuffix: keyFile,
+			},
+			userName: "filebot",
+			oauthKey: "oauth:fromfile",
+		},
+		{
+			name:     "file over env",
+			env:      map[string]string{oauthKeyEnvName + secretFileSuffix: keyFile},
+			userName: testUserName,
+			oauthKey: "oauth:fromfile",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setCredentials(t)
+			t.Setenv(userNameEnvName+secretFileSuffix, "")
+			t.Setenv(oauthKeyEnvName+secretFileSuffix, "")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			b, err := newBotConfigFromEnv()
+			if err != nil {
+				t.Fatalf("newBotConfigFromEnv failed: %v", err)
+			}
+			if b.userName != tt.userName || b.oauthKey != tt.oauthKey {
+				t.Errorf("Credentials are %q and %q, want %q and %q", b.userName, b.oauthKey, tt.userName, tt.oauthKey)
+			}
+		})
+	}
+}
+
+func TestCredentialsFileErrors(t *testing.T) {
+	setCredentials(t)
+	t.Setenv(oauthKeyEnvName+secretFileSuffix, filepath.Join(t.TempDir(), "missing"))
+
+	if _, err := newBotConfigFromEnv(); err == nil || !strings.Contains(err.Error(), oauthKeyEnvName+secretFileSuffix) {
+		t.Errorf("newBotConfigFromEnv returned %v, want an error about %v", err, oauthKeyEnvName+secretFileSuffix)
+	}
+
+	// an empty file is the same as not setting the variable
+	t.Setenv(oauthKeyEnvName+secretFileSuffix, writeTestFile(t, t.TempDir(), "oauth_key", "\n"))
+	if _, err := newBotConfigFromEnv(); err == nil || !strings.Contains(err.Error(), oauthKeyEnvName) {
+		t.Errorf("newBotConfigFromEnv returned %v, want an error about %v", err, oauthKeyEnvName)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// playerCooldowns limits how often each player's logs are posted, so a player playing several matches back to
+// back doesn't flood their channels. Logs found during a player's cooldown are still marked as seen, they just
+// aren't posted.
+type playerCooldowns struct {
+	mutex     *sync.Mutex
+	cooldown  time.Duration // zero to post every log
+	lastPosts map[string]time.Time
+}
+
+func newPlayerCooldowns(cooldown time.Duration) *playerCooldowns {
+	return &playerCooldowns{mutex: &sync.Mutex{}, cooldown: cooldown, lastPosts: map[string]time.Time{}}
+}
+
+// ready reports whether a log can be posted for the player now, it doesn't start their cooldown
+func (c *playerCooldowns) ready(steamid string) bool {
+	if c.cooldown == 0 {
+		return true
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	last, ok := c.lastPosts[steamid]
+	return !ok || time.Since(last) >= c.cooldown
+}
+
+// record starts the player's cooldown, called once a log has been posted for them so that logs that were
+// filtered out or failed to send don't count
+func (c *playerCooldowns) record(steamid string) {
+	if c.cooldown == 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastPosts[steamid] = time.Now()
+}
+
+// retain drops the cooldowns of players that aren't in the config
+func (c *playerCooldowns) retain(steamIDToTwitchChannel map[string]channelList) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for steamid := range c.lastPosts {
+		if _, ok := steamIDToTwitchChannel[steamid]; !ok {
+			delete(c.lastPosts, steamid)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogsWithinAPlayersCooldownArePostedOnce(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	older, newer := testLog(101, 2*time.Minute), testLog(102, time.Minute)
+	logsTF.setLogs(testSteamID, newer, older)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{
+		playerCooldownEnvName:  "300ms",
+		messageTemplateEnvName: "{{.ID}}",
+	})
+	connectTestBot(t, b, twitch, testChannel)
+
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	if got, want := privmsgs(twitch.flush(t, b)), []string{"PRIVMSG #lansky :101"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q, want %q", got, want)
+	}
+	// the skipped log is still seen, so it isn't posted once the cooldown is up
+	if last := b.steamIDToLastLog[testSteamID]; last.ID != 102 {
+		t.Errorf("Last seen log is %v, want 102", last.ID)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	logsTF.setLogs(testSteamID, testLog(103, 0), newer, older)
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	if got, want := privmsgs(twitch.flush(t, b)), []string{"PRIVMSG #lansky :103"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q after the cooldown, want %q", got, want)
+	}
+}
+
+func TestFilteredLogsDontStartTheCooldown(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setDetail(100, `{"teams": {"Red": {"score": 1}, "Blue": {"score": 0}}, "length": 300}`)
+	logsTF.setDetail(101, `{"teams": {"Red": {"score": 5}, "Blue": {"score": 3}}, "length": 1800}`)
+	twitch := newFakeTwitch(t)
+	channels, err := parseChannels([]byte(`{"76561198107240606": {"channel": "lansky", "min_duration": "10m"}}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+	b := newTestBot(t, logsTF, channels, map[string]string{
+		playerCooldownEnvName:  "1h",
+		messageTemplateEnvName: "{{.ID}}",
+	})
+	connectTestBot(t, b, twitch, testChannel)
+
+	// 100 is too short for the channel, so 101 is still posted
+	logsTF.setLogs(testSteamID, testLog(101, time.Minute), testLog(100, 2*time.Minute))
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	if got, want := privmsgs(twitch.flush(t, b)), []string{"PRIVMSG #lansky :101"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q, want %q", got, want)
+	}
+	if b.cooldowns.ready(testSteamID) {
+		t.Error("The player's cooldown didn't start after 101 was posted")
+	}
+}
+
+func TestFailedSendsDontStartTheCooldown(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{playerCooldownEnvName: "1h"})
+	connectTestBot(t, b, twitch, testChannel)
+	b.writer.stop()
+
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	if !b.cooldowns.ready(testSteamID) {
+		t.Error("The player's cooldown started though the log failed to send")
+	}
+}
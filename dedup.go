@@ -0,0 +1,78 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+const postedLogsPerChannel = 100 // how many recently posted log ids to remember for each channel
+
+// postedLogs remembers which logs were recently posted to each channel, so a log shared by several tracked
+// players isn't posted to the same channel more than once
+type postedLogs struct {
+	mutex     sync.Mutex
+	byChannel map[string]*lru
+}
+
+func newPostedLogs() *postedLogs {
+	return &postedLogs{byChannel: map[string]*lru{}}
+}
+
+// claim records the log as posted to the channel, returning false if it already was
+func (p *postedLogs) claim(channel string, id int) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ids, ok := p.byChannel[channel]
+	if !ok {
+		ids = newLRU(postedLogsPerChannel)
+		p.byChannel[channel] = ids
+	}
+
+	return ids.add(id)
+}
+
+// release forgets that the log was posted to the channel, used when the send failed
+func (p *postedLogs) release(channel string, id int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if ids, ok := p.byChannel[channel]; ok {
+		ids.remove(id)
+	}
+}
+
+// lru is a bounded set of ids that evicts the least recently added id when full
+type lru struct {
+	size     int
+	order    *list.List // front is the most recent
+	elements map[int]*list.Element
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), elements: map[int]*list.Element{}}
+}
+
+// add adds the id, returning false if it was already present
+func (l *lru) add(id int) bool {
+	if e, ok := l.elements[id]; ok {
+		l.order.MoveToFront(e)
+		return false
+	}
+
+	l.elements[id] = l.order.PushFront(id)
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.elements, oldest.Value.(int))
+	}
+
+	return true
+}
+
+func (l *lru) remove(id int) {
+	if e, ok := l.elements[id]; ok {
+		l.order.Remove(e)
+		delete(l.elements, id)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSharedLogIsPostedToAChannelOnce(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID, otherSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.setLogs(otherSteamID, testLog(100, time.Minute))
+	for _, steamid := range []string{testSteamID, otherSteamID} {
+		if err := b.checkLogsForPlayer(context.Background(), steamid, b.playerChannels()[steamid]); err != nil {
+			t.Fatalf("checkLogsForPlayer failed: %v", err)
+		}
+	}
+	b.posts.Wait()
+
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 1 {
+		t.Errorf("Posted %q, want the log posted to #%v once", messages, testChannel)
+	}
+}
+
+func TestPostedLogsAreRememberedPerChannel(t *testing.T) {
+	p := newPostedLogs()
+	if !p.claim(testChannel, 100) {
+		t.Fatal("A new log couldn't be claimed")
+	}
+	if p.claim(testChannel, 100) {
+		t.Error("A log was claimed twice for the same channel")
+	}
+	if !p.claim(otherTestChannel, 100) {
+		t.Error("A log posted to one channel couldn't be claimed for another")
+	}
+
+	p.release(testChannel, 100)
+	if !p.claim(testChannel, 100) {
+		t.Error("A released log couldn't be claimed again")
+	}
+}
+
+func TestPostedLogsForgetTheOldestIDs(t *testing.T) {
+	p := newPostedLogs()
+	for id := 0; id <= postedLogsPerChannel; id++ {
+		p.claim(testChannel, id)
+	}
+
+	if !p.claim(testChannel, 0) {
+		t.Errorf("The oldest log is still remembered after %d more were posted", postedLogsPerChannel)
+	}
+	if p.claim(testChannel, postedLogsPerChannel) {
+		t.Error("The newest log was forgotten")
+	}
+}
+
+func TestAllPlayersChannelGetsEveryLogOnce(t *testing.T) {
+	const thirdSteamID = "76561197960287930"
+
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	channels, err := parseChannels([]byte(`{
+		"all_players": "tf2highlights",
+		"76561198107240606": "lansky",
+		"76561197991735941": "clockwork",
+		"76561197960287930": []
+	}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+	b := newTestBot(t, logsTF, channels, map[string]string{messageTemplateEnvName: "{{.ID}}"})
+	connectTestBot(t, b, twitch, testChannel, otherTestChannel, "tf2highlights")
+
+	// lansky and clockwork played 100 together, and the third player is only tracked for tf2highlights
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.setLogs(otherSteamID, testLog(100, time.Minute))
+	logsTF.setLogs(thirdSteamID, testLog(101, time.Minute))
+	for _, steamid := range []string{testSteamID, otherSteamID, thirdSteamID} {
+		if err := b.checkLogsForPlayer(context.Background(), steamid, b.playerChannels()[steamid]); err != nil {
+			t.Fatalf("checkLogsForPlayer failed: %v", err)
+		}
+	}
+	b.posts.Wait()
+
+	want := []string{
+		"PRIVMSG #clockwork :100", "PRIVMSG #lansky :100",
+		"PRIVMSG #tf2highlights :100", "PRIVMSG #tf2highlights :101",
+	}
+	got := privmsgs(twitch.flush(t, b))
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// discordWebhookEnvPrefix followed by the uppercased channel name sets a channel's Discord webhook, e.g.
+// LOGS_BOT_DISCORD_WEBHOOK_LANSKY
+const discordWebhookEnvPrefix = "LOGS_BOT_DISCORD_WEBHOOK_"
+
+type discordEmbed struct {
+	Title       string `json:"title,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Description string `json:"description,omitempty"`
+	Timestamp   string `json:"timestamp,omitempty"`
+}
+
+type discordPayload struct {
+	Content string         `json:"content"`
+	Embeds  []discordEmbed `json:"embeds,omitempty"`
+}
+
+// discordWebhook returns the Discord webhook URL that logs posted to the channel are mirrored to, or an
+// empty string if there isn't one
+func (c channelConfig) discordWebhook() string {
+	if c.DiscordWebhook != "" {
+		return c.DiscordWebhook
+	}
+
+	return os.Getenv(discordWebhookEnvPrefix + strings.ToUpper(c.Name))
+}
+
+// mirrorToDiscord posts the log to the channel's Discord webhook in the background, if it has one. Discord
+// failures are logged and otherwise ignored so they never affect the Twitch post. The post counts towards
+// b.posts so -once waits for it before exiting.
+func (b *botConfig) mirrorToDiscord(l *logResponse, channel channelConfig, message string) {
+	webhook := channel.discordWebhook()
+	if webhook == "" {
+		return
+	}
+
+	b.posts.Add(1)
+	go func() {
+		defer b.posts.Done()
+		if err := b.postToDiscord(webhook, l, message); err != nil {
+			slog.Warn("Failed to post log to Discord", "log_id", l.ID, "channel", channel.Name, "error", err)
+			return
+		}
+
+		slog.Info("Posted log to Discord", "log_id", l.ID, "channel", channel.Name)
+	}()
+}
+
+func (b *botConfig) postToDiscord(webhook string, l *logResponse, message string) error {
+	embed := discordEmbed{
+		Title:       l.Title,
+		URL:         b.logURL(l.ID),
+		Description: l.Map,
+		Timestamp:   logTime(l).UTC().Format(time.RFC3339),
+	}
+	if embed.Title == "" {
+		embed.Title = embed.URL
+	}
+
+	body, err := json.Marshal(discordPayload{Content: message, Embeds: []discordEmbed{embed}})
+	if err != nil {
+		return err
+	}
+
+	res, err := b.httpClient.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the webhook's url has its token in it, so leave it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Discord responded with %v", res.Status)
+	}
+
+	return nil
+}
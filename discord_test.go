@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDiscord is a Discord webhook that keeps the payloads posted to it, responding with status
+type fakeDiscord struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	status   int
+	payloads []map[string]interface{}
+}
+
+func newFakeDiscord(t *testing.T, status int) *fakeDiscord {
+	f := &fakeDiscord{status: status}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]interface{}
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || json.Unmarshal(body, &payload) != nil {
+			t.Errorf("Discord got a %v %v request with body %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"), body)
+		}
+
+		f.mutex.Lock()
+		f.payloads = append(f.payloads, payload)
+		f.mutex.Unlock()
+		w.WriteHeader(f.status)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeDiscord) posted() []map[string]interface{} {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return append([]map[string]interface{}(nil), f.payloads...)
+}
+
+func TestLogsAreMirroredToDiscord(t *testing.T) {
+	discord := newFakeDiscord(t, http.StatusNoContent)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: "https://logs.tf"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	l := testLog(100, time.Minute)
+	l.Date = time.Date(2023, 4, 1, 18, 42, 0, 0, time.UTC).Unix()
+	if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: testChannel, DiscordWebhook: discord.URL}); err != nil {
+		t.Fatalf("sendLogToChannel failed: %v", err)
+	}
+	waitFor(t, "the log to be mirrored to Discord", func() bool { return len(discord.posted()) > 0 })
+
+	want := map[string]interface{}{
+		"content": "https://logs.tf/100 — serveme.tf #100 (cp_process_f12)",
+		"embeds": []interface{}{map[string]interface{}{
+			"title":       "serveme.tf #100",
+			"url":         "https://logs.tf/100",
+			"description": "cp_process_f12",
+			"timestamp":   "2023-04-01T18:42:00Z",
+		}},
+	}
+	if got := discord.posted()[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Discord got %v, want %v", got, want)
+	}
+}
+
+func TestDiscordWebhookFromTheEnvironment(t *testing.T) {
+	discord := newFakeDiscord(t, http.StatusNoContent)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{discordWebhookEnvPrefix + "LANSKY": discord.URL})
+	connectTestBot(t, b, twitch, testChannel)
+
+	l := testLog(100, time.Minute)
+	if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: testChannel}); err != nil {
+		t.Fatalf("sendLogToChannel failed: %v", err)
+	}
+	waitFor(t, "the log to be mirrored to Discord", func() bool { return len(discord.posted()) > 0 })
+}
+
+func TestDiscordFailuresDontAffectTwitch(t *testing.T) {
+	discord := newFakeDiscord(t, http.StatusInternalServerError)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	l := testLog(100, time.Minute)
+	if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: testChannel, DiscordWebhook: discord.URL}); err != nil {
+		t.Fatalf("sendLogToChannel failed because of Discord: %v", err)
+	}
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 1 {
+		t.Errorf("Posted %q, want the log posted to Twitch", messages)
+	}
+}
+
+func TestFailedTwitchSendsArentMirrored(t *testing.T) {
+	discord := newFakeDiscord(t, http.StatusNoContent)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch, testChannel)
+	b.writer.stop()
+
+	l := testLog(100, time.Minute)
+	if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: testChannel, DiscordWebhook: discord.URL}); err == nil {
+		t.Fatal("sendLogToChannel succeeded with the connection gone")
+	}
+
+	// mirroring happens in the background, so give it a chance to
+	time.Sleep(100 * time.Millisecond)
+	if posted := discord.posted(); len(posted) != 0 {
+		t.Errorf("Discord got %v for a log that wasn't posted to Twitch", posted)
+	}
+}
+
+func TestDiscordErrorsLeaveOutTheWebhook(t *testing.T) {
+	logs := captureLogs(t)
+	discord := httptest.NewServer(http.NotFoundHandler())
+	discord.Close()
+	webhook := discord.URL + "/api/webhooks/123/token"
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	l := testLog(100, time.Minute)
+	if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: testChannel, DiscordWebhook: webhook}); err != nil {
+		t.Fatalf("sendLogToChannel failed: %v", err)
+	}
+	waitFor(t, "the Discord failure to be logged", func() bool { return strings.Contains(logs.String(), "Failed to post log to Discord") })
+
+	if strings.Contains(logs.String(), "/api/webhooks/123/token") {
+		t.Errorf("Logged the webhook:\n%v", logs)
+	}
+}
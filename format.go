@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultGameFormats maps player counts to game formats when LOGS_BOT_GAME_FORMATS isn't set, the ranges
+// leave room for a sub or two joining during the match
+const defaultGameFormats = "ultiduo=4,4s=8-9,6s=12-14,hl=18-21"
+
+// gameFormat is a game format (e.g. 6s) and the range of player counts a log of that format has
+type gameFormat struct {
+	name     string
+	min, max int
+}
+
+// parseGameFormats parses a comma separated list of format=count or format=min-max, e.g. "6s=12-14,hl=18"
+func parseGameFormats(s string) ([]gameFormat, error) {
+	var formats []gameFormat
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, counts, ok := strings.Cut(entry, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expected format=players, got %q", entry)
+		}
+
+		f := gameFormat{name: name}
+		minCount, maxCount, isRange := strings.Cut(counts, "-")
+		var err error
+		if f.min, err = strconv.Atoi(strings.TrimSpace(minCount)); err != nil || f.min <= 0 {
+			return nil, fmt.Errorf("invalid player count for %v: %q", name, counts)
+		}
+		f.max = f.min
+		if isRange {
+			if f.max, err = strconv.Atoi(strings.TrimSpace(maxCount)); err != nil || f.max < f.min {
+				return nil, fmt.Errorf("invalid player count for %v: %q", name, counts)
+			}
+		}
+
+		formats = append(formats, f)
+	}
+
+	return formats, nil
+}
+
+// gameFormatForPlayers returns the format of a log with the given number of players, or "" if it doesn't
+// match any format
+func (b *botConfig) gameFormatForPlayers(players int) string {
+	for _, f := range b.gameFormats {
+		if players >= f.min && players <= f.max {
+			return f.name
+		}
+	}
+
+	return ""
+}
+
+// channelsAllowingFormat returns the channels that want logs of the format, channels that don't list
+// their own formats use LOGS_BOT_ALLOWED_FORMATS and an empty list allows every format
+func (b *botConfig) channelsAllowingFormat(channels channelList, format string) channelList {
+	var allowed channelList
+	for _, channel := range channels {
+		formats := b.allowedFormats
+		if len(channel.Formats) > 0 {
+			formats = map[string]bool{}
+			for _, f := range channel.Formats {
+				formats[strings.ToLower(f)] = true
+			}
+		}
+
+		if len(formats) == 0 || formats[format] {
+			allowed = append(allowed, channel)
+		}
+	}
+
+	return allowed
+}
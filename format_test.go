@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseGameFormats(t *testing.T) {
+	formats, err := parseGameFormats(" ultiduo=4, 6S=12-14 ,,hl=18-21")
+	if err != nil {
+		t.Fatalf("parseGameFormats failed: %v", err)
+	}
+
+	want := []gameFormat{{"ultiduo", 4, 4}, {"6s", 12, 14}, {"hl", 18, 21}}
+	if !reflect.DeepEqual(formats, want) {
+		t.Errorf("Parsed %+v, want %+v", formats, want)
+	}
+
+	for _, s := range []string{"6s", "=12", "6s=", "6s=0", "6s=twelve", "6s=14-12", "6s=12-"} {
+		if _, err := parseGameFormats(s); err == nil {
+			t.Errorf("parseGameFormats accepted %q", s)
+		}
+	}
+}
+
+func TestGameFormatForPlayers(t *testing.T) {
+	b := newTestBot(t, nil, nil, nil)
+
+	tests := map[int]string{4: "ultiduo", 8: "4s", 9: "4s", 12: "6s", 14: "6s", 18: "hl", 21: "hl", 10: "", 0: "", 24: ""}
+	for players, want := range tests {
+		if got := b.gameFormatForPlayers(players); got != want {
+			t.Errorf("A log with %d players is %q, want %q", players, got, want)
+		}
+	}
+}
+
+func TestOnlyAllowedFormatsArePosted(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	channels, err := parseChannels([]byte(`{"76561198107240606": ["lansky", {"channel": "clockwork", "formats": ["HL", "4s"]}]}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+	b := newTestBot(t, logsTF, channels, map[string]string{
+		allowedFormatsEnvName:  "6s",
+		gameFormatsEnvName:     "4s=8,6s=12-13,hl=18",
+		messageTemplateEnvName: "{{.ID}}",
+	})
+	connectTestBot(t, b, twitch, testChannel, otherTestChannel)
+
+	sixes, highlander, fours, unknown := testLog(101, 4*time.Minute), testLog(102, 3*time.Minute), testLog(103, 2*time.Minute), testLog(104, time.Minute)
+	highlander.Players, fours.Players, unknown.Players = 18, 8, 14
+	logsTF.setLogs(testSteamID, unknown, fours, highlander, sixes)
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	posted := privmsgs(twitch.flush(t, b))
+	sort.Strings(posted)
+	want := []string{"PRIVMSG #clockwork :102", "PRIVMSG #clockwork :103", "PRIVMSG #lansky :101"}
+	if !reflect.DeepEqual(posted, want) {
+		t.Errorf("Posted %q, want %q", posted, want)
+	}
+}
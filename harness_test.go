@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// harnessTimeout is how long a test waits for the bot to do something before failing
+const harnessTimeout = 5 * time.Second
+
+// the players and channel most tests use
+const (
+	testSteamID      = "76561198107240606"
+	otherSteamID     = "76561197991735941"
+	testChannel      = "lansky"
+	otherTestChannel = "clockwork"
+	testUserName     = "logsbot"
+)
+
+func TestMain(m *testing.M) {
+	// the bot logs a lot, which only gets in the way of the test output
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// fakeLogsTF is an in-memory logs.tf answering searches and log details with what the test set
+type fakeLogsTF struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	logs     map[string][]logResponse // SteamID64 to logs, newest first
+	details  map[int]string           // log id to its /json/<id> response, 404 if missing
+	errors   map[string]string        // SteamID64 to the error logs.tf responds to searching for them with
+	failures int                      // how many of the next requests fail with a 500
+	paths    []string                 // every request's path and query, in order
+}
+
+func newFakeLogsTF(t *testing.T) *fakeLogsTF {
+	f := &fakeLogsTF{logs: map[string][]logResponse{}, details: map[int]string{}, errors: map[string]string{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeLogsTF) serve(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.paths = append(f.paths, r.URL.RequestURI())
+	if f.failures > 0 {
+		f.failures--
+		http.Error(w, "logs.tf is having a bad day", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Path == "/json_search" {
+		player := r.URL.Query().Get("player")
+		if reason, ok := f.errors[player]; ok {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": reason})
+			return
+		}
+
+		logs := f.logs[player]
+		if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit < len(logs) {
+			logs = logs[:limit]
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "results": len(logs), "logs": logs})
+		return
+	}
+
+	if id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/json/")); err == nil {
+		if detail, ok := f.details[id]; ok {
+			io.WriteString(w, detail)
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+// setLogs sets the player's logs, newest first
+func (f *fakeLogsTF) setLogs(steamid string, logs ...logResponse) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.logs[steamid] = logs
+}
+
+// setDetail sets the log's /json/<id> response
+func (f *fakeLogsTF) setDetail(id int, detail string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.details[id] = detail
+}
+
+// fail makes the next n requests fail with a 500
+func (f *fakeLogsTF) fail(n int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.failures = n
+}
+
+// requests returns the path and query of every request so far
+func (f *fakeLogsTF) requests() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return append([]string(nil), f.paths...)
+}
+
+// testLog returns a log uploaded age ago
+func testLog(id int, age time.Duration) logResponse {
+	return logResponse{
+		ID:      id,
+		Date:    time.Now().Add(-age).Unix(),
+		Title:   "serveme.tf #" + strconv.Itoa(id),
+		Map:     "cp_process_f12",
+		Players: 12,
+	}
+}
+
+// fakeTwitch is the server end of the in-memory connections the bot dials instead of Twitch. Every line the
+// bot writes, on any of its connections, ends up on lines.
+type fakeTwitch struct {
+	mutex  sync.Mutex
+	server net.Conn // the server end of the latest connection
+	dials  int
+	lines  chan string
+}
+
+func newFakeTwitch(t *testing.T) *fakeTwitch {
+	f := &fakeTwitch{lines: make(chan string, 1000)}
+	t.Cleanup(func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		if f.server != nil {
+			f.server.Close()
+		}
+	})
+	return f
+}
+
+// dial is used as botConfig.dial
+func (f *fakeTwitch) dial(ctx context.Context) (ircConn, error) {
+	client, server := net.Pipe()
+
+	f.mutex.Lock()
+	f.server = server
+	f.dials++
+	f.mutex.Unlock()
+
+	go func() {
+		s := bufio.NewScanner(server)
+		for s.Scan() {
+			f.lines <- strings.TrimSuffix(s.Text(), "\r")
+		}
+	}()
+
+	return client, nil
+}
+
+// write sends the line to the bot on the latest connection
+func (f *fakeTwitch) write(t *testing.T, line string) {
+	t.Helper()
+
+	f.mutex.Lock()
+	server := f.server
+	f.mutex.Unlock()
+
+	server.SetWriteDeadline(time.Now().Add(harnessTimeout))
+	if _, err := io.WriteString(server, line+"\r\n"); err != nil {
+		t.Fatalf("Failed to write %q to the bot: %v", line, err)
+	}
+}
+
+// next returns the next line the bot writes
+func (f *fakeTwitch) next(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case line := <-f.lines:
+		return line
+	case <-time.After(harnessTimeout):
+		t.Fatal("Timed out waiting for the bot to write a line")
+		return ""
+	}
+}
+
+// expect fails the test unless the next lines the bot writes are want, in order
+func (f *fakeTwitch) expect(t *testing.T, want ...string) {
+	t.Helper()
+
+	for _, w := range want {
+		if got := f.next(t); got != w {
+			t.Fatalf("Bot wrote %q, want %q", got, w)
+		}
+	}
+}
+
+// nextMatching skips lines until one starting with prefix, and returns it
+func (f *fakeTwitch) nextMatching(t *testing.T, prefix string) string {
+	t.Helper()
+
+	for {
+		if line := f.next(t); strings.HasPrefix(line, prefix) {
+			return line
+		}
+	}
+}
+
+// flush returns every line the bot queued before now. A marker is queued behind them, so lines still on
+// their way through the writer are included, and the lines after the marker are left for later.
+func (f *fakeTwitch) flush(t *testing.T, b *botConfig) []string {
+	t.Helper()
+
+	const marker = "PING :flush"
+	if err := b.send(context.Background(), marker); err != nil {
+		t.Fatalf("Failed to queue the flush marker: %v", err)
+	}
+
+	var lines []string
+	for {
+		line := f.next(t)
+		if line == marker {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+// privmsgs returns the lines that are messages to channels
+func privmsgs(lines []string) []string {
+	var messages []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "PRIVMSG #") {
+			messages = append(messages, line)
+		}
+	}
+
+	return messages
+}
+
+// newTestBot builds a bot the way main does from the environment, talking to the fake logs.tf and posting
+// logs for the players in channels. Everything it saves goes in a temporary directory. env sets anything
+// else, on top of defaults that keep the tests quick: no spoiler delay, no cache and no rate limit to speak
+// of.
+func newTestBot(t *testing.T, logsTF *fakeLogsTF, channels map[string]channelList, env map[string]string) *botConfig {
+	t.Helper()
+
+	dir := t.TempDir()
+	defaults := map[string]string{
+		userNameEnvName:          testUserName,
+		oauthKeyEnvName:          "oauth:secret",
+		spoilerDelayEnvName:      "0s",
+		metricsEnabledEnvName:    "false",
+		logCacheTTLEnvName:       "0s",
+		rateLimitBurstEnvName:    "1000",
+		configEnvName:            filepath.Join(dir, channelsFileName),
+		stateFileEnvName:         filepath.Join(dir, defaultStateFileName),
+		pausedChannelsEnvName:    filepath.Join(dir, defaultPausedChannelsFileName),
+		postedLogsEnvName:        filepath.Join(dir, defaultPostedLogsFileName),
+		configCacheEnvName:       filepath.Join(dir, defaultConfigCacheFileName),
+		httpAddrEnvName:          "127.0.0.1:0",
+		reconnectBaseEnvName:     "10ms",
+		reconnectResetEnvName:    "1h",
+		staleLogThresholdEnvName: "1h",
+	}
+	if logsTF != nil {
+		defaults[logsTFBaseURLEnvName] = logsTF.URL
+	}
+	for name, value := range defaults {
+		if _, ok := env[name]; !ok {
+			t.Setenv(name, value)
+		}
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	b, err := newBotConfigFromEnv()
+	if err != nil {
+		t.Fatalf("Failed to build the bot's config: %v", err)
+	}
+
+	// what main does once the config is loaded
+	if channels == nil {
+		channels = map[string]channelList{}
+	}
+	b.steamIDToTwitchChannel = channels
+	b.steamIDToLastLog = loadState(b.stateFileName)
+	b.paused = loadPausedChannels(b.pausedChannelsFile)
+	b.postedIDs = loadPostedLogIDs(b.postedLogsFile, b.dedupWindow)
+	b.postingFrom = time.Now().Add(b.startupDelay)
+	return b
+}
+
+// connectTestBot connects the bot to the fake Twitch, skipping the login lines, and confirms its joins to
+// the channels
+func connectTestBot(t *testing.T, b *botConfig, twitch *fakeTwitch, channels ...string) {
+	t.Helper()
+
+	dialTestBot(t, b, twitch)
+	twitch.nextMatching(t, "NICK ")
+	for _, channel := range channels {
+		b.joined.confirm(channel)
+	}
+}
+
+// dialTestBot connects the bot to the fake Twitch, closing the connection when the test is done
+func dialTestBot(t *testing.T, b *botConfig, twitch *fakeTwitch) {
+	t.Helper()
+
+	b.dial = twitch.dial
+	if err := b.connect(context.Background()); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	writer, conn := b.writer, b.conn
+	t.Cleanup(func() {
+		writer.stop()
+		conn.Close()
+	})
+}
+
+// readInBackground runs readMessages until the test is done, returning what it returned
+func readInBackground(t *testing.T, b *botConfig) <-chan error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		done <- b.readMessages(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		b.conn.Close()
+		<-finished
+	})
+
+	return done
+}
+
+// players maps each steamid to the channels, with default options
+func players(steamids []string, channels ...string) map[string]channelList {
+	m := map[string]channelList{}
+	for _, steamid := range steamids {
+		for _, channel := range channels {
+			m[steamid] = append(m[steamid], channelConfig{Name: channel})
+		}
+	}
+
+	return m
+}
+
+// waitFor polls until cond is true, failing the test if it isn't in time
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(harnessTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %v", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// writeTestFile writes the contents to name in dir and returns its path
+func writeTestFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("Failed to write %v: %v", name, err)
+	}
+
+	return path
+}
+
+// logBuffer collects what the bot logs, safe to read while the bot is still logging
+type logBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.buf.Write(p)
+}
+
+func (l *logBuffer) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.buf.String()
+}
+
+// captureLogs sends everything logged at debug and up to the returned buffer until the test is done
+func captureLogs(t *testing.T) *logBuffer {
+	t.Helper()
+
+	logs := &logBuffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return logs
+}
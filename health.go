@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthState tracks whether the bot is connected to Twitch and when it last polled logs.tf successfully
+type healthState struct {
+	mutex       sync.Mutex
+	connected   bool
+	connectedAt time.Time
+	lastPoll    time.Time
+}
+
+func (h *healthState) setConnected(connected bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.connected = connected
+	if connected {
+		h.connectedAt = time.Now()
+	}
+}
+
+func (h *healthState) recordPollSuccess() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastPoll = time.Now()
+}
+
+// check returns an error describing why the bot is unhealthy, or nil if it's connected and a poll has
+// succeeded within maxPollAge (or it connected too recently to have polled). A zero maxPollAge skips the
+// poll check.
+func (h *healthState) check(maxPollAge time.Duration) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.connected {
+		return fmt.Errorf("not connected to Twitch IRC")
+	}
+
+	last := h.lastPoll
+	if last.Before(h.connectedAt) {
+		last = h.connectedAt
+	}
+
+	if age := time.Since(last); maxPollAge > 0 && age > maxPollAge {
+		return fmt.Errorf("no successful logs.tf poll in %v", age.Round(time.Second))
+	}
+
+	return nil
+}
+
+// handleHealthz responds with 200 when the bot is healthy and 503 otherwise
+func (b *botConfig) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	// allow a few poll cycles of the least often checked player to fail before reporting unhealthy, there's
+	// nothing to poll without players
+	var maxPollAge time.Duration
+	for _, channels := range b.playerChannels() {
+		if age := 3*channels.pollInterval(b.logRefreshTime) + b.httpTimeout; age > maxPollAge {
+			maxPollAge = age
+		}
+	}
+
+	if err := b.health.check(maxPollAge); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// healthzStatus returns the status code /healthz responds with
+func healthzStatus(b *botConfig) int {
+	w := httptest.NewRecorder()
+	b.handleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	return w.Code
+}
+
+func TestHealthzFollowsConnectionAndPolls(t *testing.T) {
+	b := newTestBot(t, nil, players([]string{testSteamID}, testChannel), nil)
+
+	if status := healthzStatus(b); status != http.StatusServiceUnavailable {
+		t.Errorf("Before connecting /healthz responded %v, want %v", status, http.StatusServiceUnavailable)
+	}
+
+	b.health.setConnected(true)
+	if status := healthzStatus(b); status != http.StatusOK {
+		t.Errorf("Right after connecting /healthz responded %v, want %v", status, http.StatusOK)
+	}
+
+	// no poll has succeeded for longer than a few poll cycles
+	b.health.mutex.Lock()
+	b.health.connectedAt = time.Now().Add(-time.Hour)
+	b.health.mutex.Unlock()
+	if status := healthzStatus(b); status != http.StatusServiceUnavailable {
+		t.Errorf("Without a recent poll /healthz responded %v, want %v", status, http.StatusServiceUnavailable)
+	}
+
+	b.health.recordPollSuccess()
+	if status := healthzStatus(b); status != http.StatusOK {
+		t.Errorf("After a poll /healthz responded %v, want %v", status, http.StatusOK)
+	}
+
+	b.health.setConnected(false)
+	if status := healthzStatus(b); status != http.StatusServiceUnavailable {
+		t.Errorf("After disconnecting /healthz responded %v, want %v", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHealthzWithoutPlayersIgnoresPolls(t *testing.T) {
+	b := newTestBot(t, nil, nil, nil)
+	b.health.setConnected(true)
+	b.health.mutex.Lock()
+	b.health.connectedAt = time.Now().Add(-time.Hour)
+	b.health.mutex.Unlock()
+
+	if status := healthzStatus(b); status != http.StatusOK {
+		t.Errorf("/healthz responded %v with nothing to poll, want %v", status, http.StatusOK)
+	}
+}
+
+func TestHealthzAllowsForThePlayersPollInterval(t *testing.T) {
+	interval := duration(time.Minute)
+	channels := map[string]channelList{
+		testSteamID:  {{Name: testChannel, PollInterval: &interval}},
+		otherSteamID: {{Name: otherTestChannel, PollInterval: &interval}},
+	}
+	b := newTestBot(t, nil, channels, map[string]string{logRefreshTimeEnvName: "10s", httpTimeoutEnvName: "10s"})
+	b.health.setConnected(true)
+
+	// longer ago than a few of the default interval, but not of the players' own
+	b.health.mutex.Lock()
+	b.health.connectedAt = time.Now().Add(-time.Hour)
+	b.health.lastPoll = time.Now().Add(-90 * time.Second)
+	b.health.mutex.Unlock()
+	if status := healthzStatus(b); status != http.StatusOK {
+		t.Errorf("/healthz responded %v within the players' poll cycles, want %v", status, http.StatusOK)
+	}
+
+	b.health.mutex.Lock()
+	b.health.lastPoll = time.Now().Add(-4 * time.Minute)
+	b.health.mutex.Unlock()
+	if status := healthzStatus(b); status != http.StatusServiceUnavailable {
+		t.Errorf("/healthz responded %v after several missed poll cycles, want %v", status, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import "strings"
+
+// twitchCapabilities are the IRCv3 capabilities requested from Twitch: tags adds badges and display names
+// to messages, commands adds Twitch specific commands, and membership adds JOIN/PART for other users
+const twitchCapabilities = "twitch.tv/tags twitch.tv/commands twitch.tv/membership"
+
+var tagValueUnescaper = strings.NewReplacer(`\:`, ";", `\s`, " ", `\\`, `\`, `\r`, "\r", `\n`, "\n")
+
+// splitTags splits the IRCv3 tags off the front of a line like "@badges=moderator/1;display-name=Bob :bob!...",
+// returning the parsed tags (nil if there weren't any) and the rest of the line
+func splitTags(line string) (map[string]string, string) {
+	if !strings.HasPrefix(line, "@") {
+		return nil, line
+	}
+
+	raw, rest, _ := strings.Cut(line[1:], " ")
+	tags := map[string]string{}
+	for _, tag := range strings.Split(raw, ";") {
+		key, value, _ := strings.Cut(tag, "=")
+		if key != "" {
+			tags[key] = tagValueUnescaper.Replace(value)
+		}
+	}
+
+	return tags, rest
+}
+
+// ircMessage is a line received from the IRC server, split into its parts. For
+// "@badges=moderator/1 :bob!bob@bob.tmi.twitch.tv PRIVMSG #channel :hello there" the prefix is
+// "bob!bob@bob.tmi.twitch.tv", the command "PRIVMSG", the params ["#channel"] and the trailing
+// "hello there".
+type ircMessage struct {
+	tags     map[string]string // nil if the line had none
+	prefix   string            // without the leading ':', empty if the line had none
+	command  string            // uppercased, e.g. "PRIVMSG" or "366"
+	params   []string          // the parameters before the trailing one
+	trailing string            // the last parameter, which may contain spaces
+}
+
+// parseIRCMessage parses a line from the IRC server, returning false if it doesn't have a command
+func parseIRCMessage(line string) (ircMessage, bool) {
+	var m ircMessage
+	m.tags, line = splitTags(line)
+
+	if strings.HasPrefix(line, ":") {
+		var ok bool
+		if m.prefix, line, ok = strings.Cut(line[1:], " "); !ok {
+			return ircMessage{}, false
+		}
+	}
+
+	line, m.trailing, _ = strings.Cut(line, " :")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ircMessage{}, false
+	}
+
+	m.command = strings.ToUpper(fields[0])
+	m.params = fields[1:]
+	return m, true
+}
+
+// param returns the i'th parameter before the trailing one, or "" if there aren't that many
+func (m ircMessage) param(i int) string {
+	if i >= len(m.params) {
+		return ""
+	}
+
+	return m.params[i]
+}
+
+// nick returns the nick from the message's prefix, e.g. "bob" for "bob!bob@bob.tmi.twitch.tv"
+func (m ircMessage) nick() string {
+	nick, _, _ := strings.Cut(m.prefix, "!")
+	return nick
+}
+
+// parseBadges parses a badges tag like "broadcaster/1,subscriber/12" into a map of badge to version
+func parseBadges(tag string) map[string]string {
+	badges := map[string]string{}
+	for _, badge := range strings.Split(tag, ",") {
+		name, version, _ := strings.Cut(badge, "/")
+		if name != "" {
+			badges[name] = version
+		}
+	}
+
+	return badges
+}
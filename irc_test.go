@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTaggedPrivmsg(t *testing.T) {
+	line := `@badge-info=subscriber/8;badges=moderator/1,subscriber/6;display-name=Clock\sWork;mod=1 :clockwork!clockwork@clockwork.tmi.twitch.tv PRIVMSG #lansky :!lastlog 76561198107240606`
+	msg, ok := parseIRCMessage(line)
+	if !ok {
+		t.Fatalf("parseIRCMessage couldn't parse %q", line)
+	}
+
+	m, ok := parsePrivmsg(msg)
+	if !ok {
+		t.Fatalf("parsePrivmsg couldn't parse %q", line)
+	}
+
+	if m.user != "clockwork" || m.displayName != "Clock Work" || m.channel != "lansky" || m.text != "!lastlog 76561198107240606" {
+		t.Errorf("Parsed user %q, display name %q, channel %q and text %q", m.user, m.displayName, m.channel, m.text)
+	}
+	if want := map[string]string{"moderator": "1", "subscriber": "6"}; !reflect.DeepEqual(m.badges, want) {
+		t.Errorf("Parsed badges %v, want %v", m.badges, want)
+	}
+	if !m.isModerator() {
+		t.Error("A moderator isn't a moderator")
+	}
+}
+
+func TestParseUntaggedPrivmsg(t *testing.T) {
+	msg, _ := parseIRCMessage(":Viewer!viewer@viewer.tmi.twitch.tv PRIVMSG #lansky :hello there")
+	m, ok := parsePrivmsg(msg)
+	if !ok {
+		t.Fatal("parsePrivmsg couldn't parse an untagged message")
+	}
+
+	if m.user != "viewer" || m.displayName != "Viewer" || len(m.badges) != 0 || m.isModerator() {
+		t.Errorf("Parsed user %q, display name %q and badges %v, want a viewer without badges", m.user, m.displayName, m.badges)
+	}
+}
+
+func TestParseIRCMessage(t *testing.T) {
+	tests := map[string]ircMessage{
+		"PING :tmi.twitch.tv": {command: "PING", params: []string{}, trailing: "tmi.twitch.tv"},
+		":tmi.twitch.tv CAP * ACK :twitch.tv/tags twitch.tv/commands twitch.tv/membership": {
+			prefix: "tmi.twitch.tv", command: "CAP", params: []string{"*", "ACK"}, trailing: twitchCapabilities,
+		},
+		":logsbot.tmi.twitch.tv 366 logsbot #lansky :End of /NAMES list": {
+			prefix: "logsbot.tmi.twitch.tv", command: "366", params: []string{"logsbot", "#lansky"}, trailing: "End of /NAMES list",
+		},
+		":viewer!viewer@viewer.tmi.twitch.tv PRIVMSG #lansky :PING me when the log is up": {
+			prefix: "viewer!viewer@viewer.tmi.twitch.tv", command: "PRIVMSG", params: []string{"#lansky"}, trailing: "PING me when the log is up",
+		},
+		`@badges=moderator/1;display-name=Clock\sWork :clockwork!clockwork@clockwork.tmi.twitch.tv PRIVMSG #lansky :RECONNECT`: {
+			tags:   map[string]string{"badges": "moderator/1", "display-name": "Clock Work"},
+			prefix: "clockwork!clockwork@clockwork.tmi.twitch.tv", command: "PRIVMSG", params: []string{"#lansky"}, trailing: "RECONNECT",
+		},
+		"@msg-id=msg_ratelimit :tmi.twitch.tv NOTICE #lansky :Your message was not sent": {
+			tags: map[string]string{"msg-id": "msg_ratelimit"}, prefix: "tmi.twitch.tv", command: "NOTICE", params: []string{"#lansky"},
+			trailing: "Your message was not sent",
+		},
+		`@key=a\:b\\c;empty= :tmi.twitch.tv reconnect`: {
+			tags: map[string]string{"key": `a;b\c`, "empty": ""}, prefix: "tmi.twitch.tv", command: "RECONNECT", params: []string{},
+		},
+	}
+	for line, want := range tests {
+		got, ok := parseIRCMessage(line)
+		if !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("parseIRCMessage(%q) = %+v, %v, want %+v", line, got, ok, want)
+		}
+	}
+
+	for _, line := range []string{"", ":tmi.twitch.tv", "@badges=moderator/1 "} {
+		if _, ok := parseIRCMessage(line); ok {
+			t.Errorf("parseIRCMessage(%q) parsed a line without a command", line)
+		}
+	}
+}
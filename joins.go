@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// joinConfirmTimeout is how long a log waits for Twitch to confirm the bot has joined its channel before
+// it's given up on
+const joinConfirmTimeout = 30 * time.Second
+
+var errNotJoined = errors.New("channel join wasn't confirmed")
+
+// joinedChannels tracks which channels Twitch has confirmed the bot is in, messages sent to a channel
+// before the join is confirmed are dropped
+type joinedChannels struct {
+	mutex  *sync.Mutex
+	joined map[string]chan struct{} // closed once the channel's join is confirmed
+}
+
+func newJoinedChannels() *joinedChannels {
+	return &joinedChannels{mutex: &sync.Mutex{}, joined: map[string]chan struct{}{}}
+}
+
+func (j *joinedChannels) channel(name string) chan struct{} {
+	c, ok := j.joined[name]
+	if !ok {
+		c = make(chan struct{})
+		j.joined[name] = c
+	}
+
+	return c
+}
+
+// confirm marks the channel as joined, waking anything waiting for it
+func (j *joinedChannels) confirm(name string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	c := j.channel(name)
+	select {
+	case <-c:
+	default:
+		close(c)
+	}
+}
+
+// part marks the channel as no longer joined
+func (j *joinedChannels) part(name string) {
+	j.mutex.Lock()
+	delete(j.joined, name)
+	j.mutex.Unlock()
+}
+
+// reset forgets every join, for when the connection is replaced
+func (j *joinedChannels) reset() {
+	j.mutex.Lock()
+	j.joined = map[string]chan struct{}{}
+	j.mutex.Unlock()
+}
+
+// names returns the channels whose joins have been confirmed, sorted
+func (j *joinedChannels) names() []string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	var names []string
+	for name, c := range j.joined {
+		select {
+		case <-c:
+			names = append(names, name)
+		default:
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// wait waits up to timeout for the channel's join to be confirmed, returning false if it wasn't
+func (j *joinedChannels) wait(ctx context.Context, name string, timeout time.Duration) bool {
+	j.mutex.Lock()
+	c := j.channel(name)
+	j.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return false
+	}
+}
+
+// parseMembership parses Twitch confirming a JOIN or PART, e.g. ":nick!nick@nick.tmi.twitch.tv JOIN #channel".
+// The end of the NAMES list (366) that follows our own join is also taken as confirming it. It returns the
+// nick, the channel, and whether it was a join.
+func parseMembership(msg ircMessage) (nick, channel string, joined, ok bool) {
+	switch msg.command {
+	case "JOIN", "PART":
+		if msg.prefix == "" || len(msg.params) == 0 {
+			return "", "", false, false
+		}
+		return strings.ToLower(msg.nick()), strings.TrimPrefix(msg.param(0), "#"), msg.command == "JOIN", true
+	case "366":
+		if len(msg.params) < 2 {
+			return "", "", false, false
+		}
+		return strings.ToLower(msg.param(0)), strings.TrimPrefix(msg.param(1), "#"), true, true
+	}
+
+	return "", "", false, false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSendWaitsForTheJoinToBeConfirmed(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch)
+	readInBackground(t, b)
+
+	l := testLog(100, time.Minute)
+	sent := make(chan error, 1)
+	go func() {
+		sent <- b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: testChannel})
+	}()
+
+	// another user joining isn't our join
+	twitch.write(t, ":viewer!viewer@viewer.tmi.twitch.tv JOIN #lansky")
+	select {
+	case err := <-sent:
+		t.Fatalf("sendLogToChannel returned %v before the join was confirmed", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if lines := twitch.flush(t, b); len(lines) != 0 {
+		t.Fatalf("Wrote %q before the join was confirmed", lines)
+	}
+
+	twitch.write(t, ":logsbot.tmi.twitch.tv 353 logsbot = #lansky :logsbot")
+	twitch.write(t, ":logsbot.tmi.twitch.tv 366 logsbot #lansky :End of /NAMES list")
+	if err := <-sent; err != nil {
+		t.Fatalf("sendLogToChannel failed: %v", err)
+	}
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 1 {
+		t.Errorf("Posted %q once the join was confirmed, want the log", messages)
+	}
+}
+
+func TestSendIsSkippedWithoutAJoin(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	l := testLog(100, time.Minute)
+	if err := b.sendLogToChannel(ctx, testSteamID, &l, channelConfig{Name: testChannel}); err == nil {
+		t.Fatal("sendLogToChannel succeeded without the join being confirmed")
+	}
+	if lines := twitch.flush(t, b); len(lines) != 0 {
+		t.Errorf("Wrote %q without the join being confirmed", lines)
+	}
+
+	// the log wasn't posted, so it can be again once the channel is joined
+	if !b.posted.claim(testChannel, l.ID) {
+		t.Error("The log is still claimed for the channel after it was skipped")
+	}
+}
+
+func TestJoinedChannels(t *testing.T) {
+	j := newJoinedChannels()
+	if j.wait(context.Background(), testChannel, 10*time.Millisecond) {
+		t.Fatal("wait returned true for a channel that wasn't joined")
+	}
+
+	j.confirm(testChannel)
+	j.confirm(testChannel)
+	j.confirm(otherTestChannel)
+	if !j.wait(context.Background(), testChannel, 10*time.Millisecond) {
+		t.Error("wait returned false for a joined channel")
+	}
+	if got, want := j.names(), []string{otherTestChannel, testChannel}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Joined %v, want %v", got, want)
+	}
+
+	j.part(testChannel)
+	if got, want := j.names(), []string{otherTestChannel}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Joined %v after parting, want %v", got, want)
+	}
+
+	j.reset()
+	if got := j.names(); len(got) != 0 {
+		t.Errorf("Joined %v after a reset, want none", got)
+	}
+}
+
+func TestParseMembership(t *testing.T) {
+	tests := map[string]struct {
+		nick, channel string
+		joined, ok    bool
+	}{
+		":LogsBot!logsbot@logsbot.tmi.twitch.tv JOIN #lansky":            {"logsbot", "lansky", true, true},
+		":logsbot!logsbot@logsbot.tmi.twitch.tv PART #lansky":            {"logsbot", "lansky", false, true},
+		":logsbot.tmi.twitch.tv 366 logsbot #lansky :End of /NAMES list": {"logsbot", "lansky", true, true},
+		":logsbot.tmi.twitch.tv 353 logsbot = #lansky :logsbot":          {"", "", false, false},
+		"JOIN #lansky":                       {"", "", false, false},
+		":logsbot.tmi.twitch.tv 366 logsbot": {"", "", false, false},
+	}
+	for line, want := range tests {
+		msg, _ := parseIRCMessage(line)
+		nick, channel, joined, ok := parseMembership(msg)
+		if nick != want.nick || channel != want.channel || joined != want.joined || ok != want.ok {
+			t.Errorf("parseMembership(%q) = %q, %q, %v, %v, want %+v", line, nick, channel, joined, ok, want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// leagueLookupTimeout is the longest a league lookup can hold up posting a log
+const leagueLookupTimeout = 3 * time.Second
+
+// leagueMatch is the official league match a log was played for
+type leagueMatch struct {
+	League string `json:"league"` // e.g. "ETF2L"
+	Match  string `json:"match"`  // e.g. "Season 45 Premiership: froyotech vs Ascent"
+}
+
+// getLeagueMatch asks the league API which official match the log was played for. The API is looked up as
+// LOGS_BOT_LEAGUE_API_URL/<log id> and responds with a leagueMatch, or 404 if the log isn't from one. It
+// returns nil if there's no match.
+func (b *botConfig) getLeagueMatch(ctx context.Context, id int) (*leagueMatch, error) {
+	body, err := b.get(ctx, b.leagueAPIURL+"/"+strconv.Itoa(id))
+	if statusErr, ok := err.(*httpStatusError); ok && statusErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m leagueMatch
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, &decodeError{err: err, body: bodySnippet(body)}
+	}
+	if m.League == "" && m.Match == "" {
+		return nil, nil
+	}
+
+	return &m, nil
+}
+
+// addLeagueMatch looks up the official match the log was played for to include in the message, if a league
+// API is configured. The lookup is best effort, the log is posted without it if the API is slow or down.
+func (b *botConfig) addLeagueMatch(ctx context.Context, l *logResponse) {
+	if b.leagueAPIURL == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, leagueLookupTimeout)
+	defer cancel()
+
+	m, err := b.getLeagueMatch(ctx, l.ID)
+	if err != nil {
+		slog.Debug("Posting log without its league match", "log_id", l.ID, "error", err)
+		return
+	}
+
+	l.match = m
+}
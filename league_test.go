@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPostedLogsHaveTheirLeagueMatch(t *testing.T) {
+	league := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/100":
+			w.Write([]byte(`{"league": "ETF2L", "match": "Season 45 Premiership: froyotech vs Ascent"}`))
+		case "/101":
+			http.NotFound(w, r)
+		default:
+			http.Error(w, "the league API is down", http.StatusInternalServerError)
+		}
+	}))
+	defer league.Close()
+
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(102, time.Minute), testLog(101, 2*time.Minute), testLog(100, 3*time.Minute))
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{leagueAPIURLEnvName: league.URL + "/"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	// 101 isn't from an official match, and the failed lookup for 102 doesn't keep it from being posted
+	want := []string{
+		"PRIVMSG #lansky :" + logsTF.URL + "/100 — serveme.tf #100 (cp_process_f12) — ETF2L: Season 45 Premiership: froyotech vs Ascent",
+		"PRIVMSG #lansky :" + logsTF.URL + "/101 — serveme.tf #101 (cp_process_f12)",
+		"PRIVMSG #lansky :" + logsTF.URL + "/102 — serveme.tf #102 (cp_process_f12)",
+	}
+	if got := privmsgs(twitch.flush(t, b)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q, want %q", got, want)
+	}
+}
+
+func TestLeagueMatchIsOnlyLookedUpWhenConfigured(t *testing.T) {
+	b := newTestBot(t, newFakeLogsTF(t), nil, nil)
+
+	l := testLog(100, time.Minute)
+	b.addLeagueMatch(context.Background(), &l)
+	if l.match != nil {
+		t.Errorf("Found league match %+v without %v set", l.match, leagueAPIURLEnvName)
+	}
+}
+
+func TestLeagueAPIURLMustBeAnHTTPURL(t *testing.T) {
+	setCredentials(t)
+	for _, value := range []string{"ftp://league.example.com", "league.example.com", "https://league.example.com/?q=1"} {
+		t.Setenv(leagueAPIURLEnvName, value)
+		if _, err := newBotConfigFromEnv(); err == nil {
+			t.Errorf("newBotConfigFromEnv accepted %v=%q", leagueAPIURLEnvName, value)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultLogCacheTTL is how long a player's recent logs are reused for, it's kept below the default poll
+// interval so polling still sees every new log as soon as it would without the cache
+const defaultLogCacheTTL = 5 * time.Second
+
+// logCache remembers each player's recent logs for a short time, so lookups that happen close together
+// (startup checks, chat commands and polls) share one logs.tf request
+type logCache struct {
+	mutex   *sync.Mutex
+	ttl     time.Duration // zero disables the cache
+	entries map[string]cachedLog
+}
+
+type cachedLog struct {
+	logs    []logResponse
+	fetched time.Time
+}
+
+func newLogCache(ttl time.Duration) *logCache {
+	return &logCache{mutex: &sync.Mutex{}, ttl: ttl, entries: map[string]cachedLog{}}
+}
+
+// get returns the cached logs for the steamid if they were fetched within the ttl
+func (c *logCache) get(steamid string) ([]logResponse, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[steamid]
+	if !ok || time.Since(entry.fetched) >= c.ttl {
+		return nil, false
+	}
+
+	// callers can change the logs they're given, e.g. adding their details, so don't hand out the cached ones
+	return append([]logResponse(nil), entry.logs...), true
+}
+
+// put caches the logs for the steamid, dropping any entries that have expired
+func (c *logCache) put(steamid string, logs []logResponse) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for id, entry := range c.entries {
+		if now.Sub(entry.fetched) >= c.ttl {
+			delete(c.entries, id)
+		}
+	}
+
+	c.entries[steamid] = cachedLog{logs: append([]logResponse(nil), logs...), fetched: now}
+}
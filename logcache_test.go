@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCachedLookupsSkipLogsTF(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	b := newTestBot(t, logsTF, nil, map[string]string{logCacheTTLEnvName: "200ms"})
+
+	for i := 0; i < 3; i++ {
+		if l, err := b.getNewestLogForPlayer(context.Background(), testSteamID); err != nil || l.ID != 100 {
+			t.Fatalf("getNewestLogForPlayer returned %v, %v, want log 100", l, err)
+		}
+	}
+	if n := len(logsTF.requests()); n != 1 {
+		t.Errorf("logs.tf got %d requests within the TTL, want 1", n)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if _, err := b.getNewestLogForPlayer(context.Background(), testSteamID); err != nil {
+		t.Fatalf("getNewestLogForPlayer failed: %v", err)
+	}
+	if n := len(logsTF.requests()); n != 2 {
+		t.Errorf("logs.tf got %d requests, want the expired entry fetched again", n)
+	}
+}
+
+func TestLogCacheIsPerPlayer(t *testing.T) {
+	c := newLogCache(time.Minute)
+	c.put(testSteamID, []logResponse{testLog(100, time.Minute)})
+
+	if _, ok := c.get(otherSteamID); ok {
+		t.Error("Got another player's cached logs")
+	}
+
+	logs, ok := c.get(testSteamID)
+	if !ok || len(logs) != 1 || logs[0].ID != 100 {
+		t.Fatalf("get returned %v, %v, want log 100", logs, ok)
+	}
+
+	// changing what was handed out doesn't change the cache
+	logs[0].ID = 101
+	if logs, _ := c.get(testSteamID); logs[0].ID != 100 {
+		t.Errorf("The cached log was changed to %d", logs[0].ID)
+	}
+}
+
+func TestZeroTTLDisablesTheCache(t *testing.T) {
+	c := newLogCache(0)
+	c.put(testSteamID, []logResponse{testLog(100, time.Minute)})
+
+	if logs, ok := c.get(testSteamID); ok {
+		t.Errorf("get returned %v with the cache disabled", logs)
+	}
+}
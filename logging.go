@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// setupLogging installs the default structured logger, using the level from LOGS_BOT_LOG_LEVEL (debug, info,
+// warn or error) and JSON output if LOGS_BOT_LOG_FORMAT is json
+func setupLogging() error {
+	handler, err := newLogHandler(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// newLogHandler returns the handler for the level and format in the environment, writing to w
+func newLogHandler(w io.Writer) (slog.Handler, error) {
+	var level slog.Level
+	switch v := strings.ToLower(os.Getenv(logLevelEnvName)); v {
+	case "debug":
+		level = slog.LevelDebug
+	case "", "info":
+		level = slog.LevelInfo
+	case "warn", "warning":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		return nil, fmt.Errorf("Invalid value for %v: %q, expected debug, info, warn or error", logLevelEnvName, v)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch v := strings.ToLower(os.Getenv(logFormatEnvName)); v {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("Invalid value for %v: %q, expected text or json", logFormatEnvName, v)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestJSONLogsHaveTheFieldsAtTheLevel(t *testing.T) {
+	t.Setenv(logLevelEnvName, "warn")
+	t.Setenv(logFormatEnvName, "json")
+
+	var buf bytes.Buffer
+	handler, err := newLogHandler(&buf)
+	if err != nil {
+		t.Fatalf("newLogHandler failed: %v", err)
+	}
+	logger := slog.New(handler)
+
+	logger.Info("Sent log", "steamid", testSteamID, "channel", testChannel, "log_id", 100)
+	logger.Warn("Failed to send log", "steamid", testSteamID, "channel", testChannel, "log_id", 100)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Logged %q, want only the warning", lines)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Logged %q, which isn't JSON: %v", lines[0], err)
+	}
+	want := map[string]interface{}{"level": "WARN", "msg": "Failed to send log", "steamid": testSteamID, "channel": testChannel, "log_id": float64(100)}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("Logged %v=%v, want %v", key, entry[key], value)
+		}
+	}
+}
+
+func TestTextLogsAtDebug(t *testing.T) {
+	t.Setenv(logLevelEnvName, "DEBUG")
+	t.Setenv(logFormatEnvName, "")
+
+	var buf bytes.Buffer
+	handler, err := newLogHandler(&buf)
+	if err != nil {
+		t.Fatalf("newLogHandler failed: %v", err)
+	}
+	slog.New(handler).Debug("Joining channel", "channel", testChannel)
+
+	if got := buf.String(); !strings.Contains(got, "level=DEBUG") || !strings.Contains(got, "channel="+testChannel) {
+		t.Errorf("Logged %q, want a debug line with the channel", got)
+	}
+}
+
+func TestNewLogHandlerRejectsInvalidSettings(t *testing.T) {
+	for name, value := range map[string]string{logLevelEnvName: "loud", logFormatEnvName: "xml"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := newLogHandler(&bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("newLogHandler returned %v, want an error naming %v", err, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const logPlayersSize = 100 // how many recent logs to remember the tracked players of
+
+// logPlayers remembers which tracked players a recent log was found for, so a channel tracking several
+// players from the same match can list all of them in its one message for the log. It also tracks when each
+// player's latest check started, so the message can wait for the rest of the poll cycle to find the log.
+type logPlayers struct {
+	mutex     *sync.Mutex
+	players   map[int]map[string]bool // log id to steamids
+	found     map[int]time.Time       // log id to when it was first found
+	checkedAt map[string]time.Time    // steamid to when their latest finished check started
+	changed   chan struct{}           // closed and replaced whenever a check finishes
+}
+
+func newLogPlayers() *logPlayers {
+	return &logPlayers{
+		mutex:     &sync.Mutex{},
+		players:   map[int]map[string]bool{},
+		found:     map[int]time.Time{},
+		checkedAt: map[string]time.Time{},
+		changed:   make(chan struct{}),
+	}
+}
+
+// add records that the log was found for the player
+func (p *logPlayers) add(id int, steamid string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.players[id]; !ok {
+		// older logs are rarely posted again, so starting over when full is good enough
+		if len(p.players) >= logPlayersSize {
+			p.players = map[int]map[string]bool{}
+			p.found = map[int]time.Time{}
+		}
+		p.players[id] = map[string]bool{}
+		p.found[id] = time.Now()
+	}
+	p.players[id][steamid] = true
+}
+
+// get returns the players the log was found for
+func (p *logPlayers) get(id int) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	steamids := make([]string, 0, len(p.players[id]))
+	for steamid := range p.players[id] {
+		steamids = append(steamids, steamid)
+	}
+
+	return steamids
+}
+
+// checked records that a check of the player that started at started has finished
+func (p *logPlayers) checked(steamid string, started time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.checkedAt[steamid] = started
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// retain forgets the checks of players that aren't in the config
+func (p *logPlayers) retain(steamIDToTwitchChannel map[string]channelList) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for steamid := range p.checkedAt {
+		if _, ok := steamIDToTwitchChannel[steamid]; !ok {
+			delete(p.checkedAt, steamid)
+		}
+	}
+}
+
+// waitForChecks waits until each of the players has either been found in the log or had a check start after
+// the log was first found, giving up once cycle has passed since then in case a player's checks are held up
+func (p *logPlayers) waitForChecks(ctx context.Context, id int, steamids []string, cycle time.Duration) {
+	p.mutex.Lock()
+	found, ok := p.found[id]
+	p.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	timer := time.NewTimer(time.Until(found.Add(cycle)))
+	defer timer.Stop()
+	for {
+		p.mutex.Lock()
+		waiting := false
+		for _, steamid := range steamids {
+			if !p.players[id][steamid] && p.checkedAt[steamid].Before(found) {
+				waiting = true
+				break
+			}
+		}
+		changed := p.changed
+		p.mutex.Unlock()
+
+		if !waiting {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-changed:
+		}
+	}
+}
+
+// waitForPlayersInLog waits until the channel's other players have been checked since the log was found,
+// so players whose check comes later in the poll cycle than the one who found it are listed as well
+func (b *botConfig) waitForPlayersInLog(ctx context.Context, l *logResponse, channel string) {
+	var steamids []string
+	var longest time.Duration
+	for steamid, channels := range b.playerChannels() {
+		if !channels.has(channel) {
+			continue
+		}
+		steamids = append(steamids, steamid)
+		if interval := channels.pollInterval(b.logRefreshTime); interval > longest {
+			longest = interval
+		}
+	}
+
+	b.logPlayers.waitForChecks(ctx, l.ID, steamids, b.pollCycle(longest))
+}
+
+// trackedPlayersInLog lists the players posted to the channel that the log was found for so far, by their
+// name in the log if it's known, sorted
+func (b *botConfig) trackedPlayersInLog(l *logResponse, channel string) string {
+	players := b.playerChannels()
+
+	var names []string
+	for _, steamid := range b.logPlayers.get(l.ID) {
+		if !players[steamid].has(channel) {
+			continue
+		}
+
+		name := steamid
+		if steamID64, err := normalizeSteamID(steamid); err == nil && l.names[steamID64] != "" {
+			name = l.names[steamID64]
+		}
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+
+	return strings.Join(names, ", ")
+}
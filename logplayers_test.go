@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// namedDetail names both tracked players in log 100
+const namedDetail = `{
+	"teams": {"Red": {"score": 5}, "Blue": {"score": 3}},
+	"players": {"[U:1:146974878]": {"team": "Red"}, "[U:1:31470213]": {"team": "Blue"}},
+	"names": {"[U:1:146974878]": "lansky", "[U:1:31470213]": "b4nny"}
+}`
+
+func TestSharedLogIsPostedOnceListingEveryPlayer(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setDetail(100, namedDetail)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.setLogs(otherSteamID, testLog(100, time.Minute))
+	twitch := newFakeTwitch(t)
+	channels := map[string]channelList{
+		testSteamID:  {{Name: testChannel, ListPlayers: true}},
+		otherSteamID: {{Name: testChannel, ListPlayers: true}},
+	}
+	b := newTestBot(t, logsTF, channels, map[string]string{pollWorkersEnvName: "1", messageTemplateEnvName: "{{.ID}} with {{.TrackedPlayers}}"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	b.pollOnce(context.Background())
+
+	if got, want := privmsgs(twitch.flush(t, b)), []string{"PRIVMSG #lansky :100 with b4nny, lansky"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q, want %q", got, want)
+	}
+}
+
+func TestSharedLogListsPlayersCheckedLaterInTheCycle(t *testing.T) {
+	// the first checks are spread 200ms apart, longer than either spoiler delay
+	for _, delay := range []string{"0s", "50ms"} {
+		t.Run(delay, func(t *testing.T) {
+			logsTF := newFakeLogsTF(t)
+			logsTF.setDetail(100, namedDetail)
+			logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+			logsTF.setLogs(otherSteamID, testLog(100, time.Minute))
+			twitch := newFakeTwitch(t)
+			channels := map[string]channelList{
+				testSteamID:  {{Name: testChannel, ListPlayers: true}},
+				otherSteamID: {{Name: testChannel, ListPlayers: true}},
+			}
+			b := newTestBot(t, logsTF, channels, map[string]string{
+				logRefreshTimeEnvName:  "400ms",
+				spoilerDelayEnvName:    delay,
+				messageTemplateEnvName: "{{.ID}} with {{.TrackedPlayers}}",
+			})
+			connectTestBot(t, b, twitch, testChannel)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				b.pollLogs(ctx)
+			}()
+			got := twitch.nextMatching(t, "PRIVMSG ")
+			cancel()
+			<-stopped
+			b.posts.Wait()
+
+			if want := "PRIVMSG #lansky :100 with b4nny, lansky"; got != want {
+				t.Errorf("Posted %q, want %q", got, want)
+			}
+			if lines := privmsgs(twitch.flush(t, b)); len(lines) != 0 {
+				t.Errorf("Also posted %q, want the log posted once", lines)
+			}
+		})
+	}
+}
+
+func TestSharedLogIsPostedWhenAPlayerIsNeverChecked(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setDetail(100, namedDetail)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	twitch := newFakeTwitch(t)
+	channels := map[string]channelList{
+		testSteamID:  {{Name: testChannel, ListPlayers: true}},
+		otherSteamID: {{Name: testChannel, ListPlayers: true}},
+	}
+	b := newTestBot(t, logsTF, channels, map[string]string{
+		logRefreshTimeEnvName:  "200ms",
+		httpTimeoutEnvName:     "100ms",
+		messageTemplateEnvName: "{{.ID}} with {{.TrackedPlayers}}",
+	})
+	connectTestBot(t, b, twitch, testChannel)
+
+	start := time.Now()
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	// the other player's check never comes, so the log goes out once the cycle is up
+	if got, want := privmsgs(twitch.flush(t, b)), []string{"PRIVMSG #lansky :100 with lansky"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q, want %q", got, want)
+	}
+	if waited := time.Since(start); waited < 200*time.Millisecond || waited > harnessTimeout {
+		t.Errorf("Posted after %v, want after the 200ms poll cycle", waited)
+	}
+}
+
+func TestChannelsNotListingPlayersDontWait(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID, otherSteamID}, testChannel), map[string]string{logRefreshTimeEnvName: "1h"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	if got := privmsgs(twitch.flush(t, b)); len(got) != 1 {
+		t.Errorf("Posted %q, want the log posted right away", got)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	logsTFMaxAttempts   = 3                // how many times to try a logs.tf request that fails transiently
+	logsTFRetryDelay    = 1 * time.Second  // how long to wait before the first retry, doubling for each retry
+	logsTFMaxRetryDelay = 30 * time.Second // the longest to wait before retrying, even if logs.tf asks for longer
+	maxBodySnippet      = 200              // how much of an unexpected response body to include in errors
+)
+
+type logResponse struct {
+	Date    int64  `json:"date"`
+	ID      int    `json:"id"`
+	Title   string `json:"title"`
+	Map     string `json:"map"`
+	Players int    `json:"players"`
+	Views   int    `json:"views"`
+
+	score *logScore         // from the log's details, nil until it's been fetched
+	match *leagueMatch      // from the league API, nil if it isn't an official match or there's no API
+	names map[string]string // SteamID64 to name in the log, from the log's details
+}
+
+// errNoLogs is returned when logs.tf has no logs for the player (zero results), which is expected for new
+// players and isn't treated as a failed poll
+var errNoLogs = errors.New("no logs found for player")
+
+// invalidSteamIDError is returned when the SteamID can't be searched for, either because it isn't a valid
+// SteamID or logs.tf rejected it
+type invalidSteamIDError struct {
+	steamid string
+	reason  string
+}
+
+func (e *invalidSteamIDError) Error() string {
+	return fmt.Sprintf("invalid steamid=%v: %v", e.steamid, e.reason)
+}
+
+// decodeError is returned when logs.tf responds with something that isn't the JSON we expect, like an
+// HTML maintenance page or a truncated response
+type decodeError struct {
+	err  error
+	body string // the start of the response, at most maxBodySnippet bytes
+}
+
+func (e *decodeError) Error() string {
+	return fmt.Sprintf("Failed to decode logs.tf response: %v, body: %q", e.err, e.body)
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+// bodySnippet returns the start of the body for including in an error
+func bodySnippet(body []byte) string {
+	if len(body) > maxBodySnippet {
+		return string(body[:maxBodySnippet]) + "..."
+	}
+
+	return string(body)
+}
+
+// httpStatusError is returned when logs.tf responds with a non-200 status
+type httpStatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // from the Retry-After header, zero if it wasn't set
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("logs.tf responded with %v %v", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// temporary reports whether the request is worth retrying: logs.tf is rate limiting us or having problems
+func (e *httpStatusError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// getNewestLogForPlayer looks up the player's newest log, cancelling the context aborts the request
+func (b *botConfig) getNewestLogForPlayer(ctx context.Context, steamid string) (*logResponse, error) {
+	logs, err := b.getRecentLogsForPlayer(ctx, steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logs[0], nil
+}
+
+// getRecentLogsForPlayer looks up the player's searchLimit newest logs, newest first, cancelling the context
+// aborts the request. Logs fetched within the cache ttl are reused rather than asking logs.tf again, and
+// logs.tf isn't asked at all while the circuit breaker is open.
+func (b *botConfig) getRecentLogsForPlayer(ctx context.Context, steamid string) ([]logResponse, error) {
+	steamID64, err := normalizeSteamID(steamid)
+	if err != nil {
+		return nil, &invalidSteamIDError{steamid: steamid, reason: err.Error()}
+	}
+
+	if logs, ok := b.logCache.get(steamID64); ok {
+		return logs, nil
+	}
+
+	if err := b.breaker.allow(); err != nil {
+		return nil, err
+	}
+	logs, err := b.searchRecentLogs(ctx, steamid, steamID64)
+	b.breaker.done(ctx, err)
+	if err != nil {
+		return nil, err
+	}
+
+	b.logCache.put(steamID64, logs)
+	return logs, nil
+}
+
+// logSearch is a search of logs.tf's logs, newest first. Empty fields don't filter the search.
+type logSearch struct {
+	Players  []string // SteamID64s that were all in the log
+	Title    string   // part of the log's title
+	Map      string   // the log's map
+	Uploader string   // SteamID64 of who uploaded the log
+	Limit    int      // how many logs to return
+	Offset   int      // how many of the newest logs to skip
+}
+
+// path returns the search's path on logs.tf, query included
+func (s logSearch) path() string {
+	q := url.Values{}
+	if len(s.Players) > 0 {
+		q.Set("player", strings.Join(s.Players, ","))
+	}
+	if s.Title != "" {
+		q.Set("title", s.Title)
+	}
+	if s.Map != "" {
+		q.Set("map", s.Map)
+	}
+	if s.Uploader != "" {
+		q.Set("uploader", s.Uploader)
+	}
+	if s.Limit > 0 {
+		q.Set("limit", strconv.Itoa(s.Limit))
+	}
+	if s.Offset > 0 {
+		q.Set("offset", strconv.Itoa(s.Offset))
+	}
+
+	return "/json_search?" + q.Encode()
+}
+
+// searchRecentLogs asks logs.tf for the player's searchLimit newest logs
+func (b *botConfig) searchRecentLogs(ctx context.Context, steamid, steamID64 string) ([]logResponse, error) {
+	body, err := b.getFromMirrors(ctx, logSearch{Players: []string{steamID64}, Limit: b.searchLimit}.path())
+	if err != nil {
+		return nil, err
+	}
+
+	type queryResponse struct {
+		Logs    []logResponse `json:"logs"`
+		Results int           `json:"results"`
+		Success bool          `json:"success"`
+		Error   string        `json:"error"`
+	}
+
+	var q queryResponse
+	err = json.Unmarshal(body, &q)
+
+	if err != nil {
+		return nil, &decodeError{err: err, body: bodySnippet(body)}
+	}
+
+	if q.Success == false {
+		if q.Error != "" {
+			return nil, &invalidSteamIDError{steamid: steamid, reason: "logs.tf responded " + q.Error}
+		}
+		return nil, fmt.Errorf("Failed to get log for steamid=%v, response: %q", steamid, bodySnippet(body))
+	}
+
+	if q.Results == 0 || len(q.Logs) == 0 {
+		return nil, errNoLogs
+	}
+
+	return q.Logs, nil
+}
+
+// isTemporary reports whether a failed request is worth retrying: logs.tf is rate limiting us or having
+// problems, or the request timed out or the connection dropped
+func isTemporary(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.temporary()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// getWithRetries fetches the url, retrying with backoff when logs.tf rate limits us (honoring Retry-After),
+// responds with a server error or the request fails on the network. Other errors are returned right away,
+// as is the last error if the context's deadline would pass before the next attempt.
+func (b *botConfig) getWithRetries(ctx context.Context, url string) ([]byte, error) {
+	delay := logsTFRetryDelay
+	for attempt := 1; ; attempt++ {
+		body, err := b.get(ctx, url)
+		if err == nil || ctx.Err() != nil || !isTemporary(err) || attempt == logsTFMaxAttempts {
+			return body, err
+		}
+
+		wait := delay
+		if statusErr, ok := err.(*httpStatusError); ok && statusErr.RetryAfter > 0 {
+			wait = statusErr.RetryAfter
+		}
+		if wait > logsTFMaxRetryDelay {
+			wait = logsTFMaxRetryDelay
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return body, err
+		}
+
+		slog.Warn("Retrying logs.tf request", "delay", wait, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// get fetches the url and returns the body, or an *httpStatusError if the status isn't 200
+func (b *botConfig) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", b.userAgent)
+
+	res, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{StatusCode: res.StatusCode, RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"))}
+	}
+
+	return body, nil
+}
+
+// parseRetryAfter parses a Retry-After header given as either seconds or an HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
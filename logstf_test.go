@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGetTimesOut(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(harnessTimeout):
+		}
+	}))
+	defer slow.Close()
+
+	b := newTestBot(t, nil, nil, map[string]string{httpTimeoutEnvName: "50ms"})
+	start := time.Now()
+	_, err := b.get(context.Background(), slow.URL+"/json_search")
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("get returned %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("get took %v to time out, want about %v", elapsed, b.httpTimeout)
+	}
+}
+
+// trackedBody is a response body that records whether it was closed
+type trackedBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackedBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+// bodyTransport answers every request with the status and body, keeping the bodies it handed out
+type bodyTransport struct {
+	status int
+	body   string
+	bodies []*trackedBody
+}
+
+func (t *bodyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	body := &trackedBody{Reader: strings.NewReader(t.body)}
+	t.bodies = append(t.bodies, body)
+	return &http.Response{StatusCode: t.status, Header: http.Header{}, Body: body, Request: r}, nil
+}
+
+func TestGetNewestLogForPlayerClosesBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{"found", http.StatusOK, `{"success": true, "results": 1, "logs": [{"id": 100, "date": 1}]}`, false},
+		{"malformed", http.StatusOK, "<html>logs.tf is down for maintenance</html>", true},
+		{"not found", http.StatusNotFound, "not found", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBot(t, nil, nil, nil)
+			transport := &bodyTransport{status: tt.status, body: tt.body}
+			b.httpClient.Transport = transport
+
+			_, err := b.getNewestLogForPlayer(context.Background(), testSteamID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getNewestLogForPlayer returned %v, want an error: %v", err, tt.wantErr)
+			}
+			if len(transport.bodies) == 0 {
+				t.Fatal("No request was made")
+			}
+			for _, body := range transport.bodies {
+				if !body.closed {
+					t.Error("A response body wasn't closed")
+				}
+			}
+		})
+	}
+}
+
+// sequenceServer answers each request with the next of the statuses, and 200 with the body once they run
+// out, counting the requests
+func sequenceServer(t *testing.T, body string, header http.Header, statuses ...int) (*httptest.Server, *int) {
+	t.Helper()
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= len(statuses) {
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(statuses[requests-1])
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &requests
+}
+
+const oneLogSearch = `{"success": true, "results": 1, "logs": [{"id": 100, "date": 1}]}`
+
+func TestGetRetriesTooManyRequests(t *testing.T) {
+	srv, requests := sequenceServer(t, oneLogSearch, http.Header{"Retry-After": {"1"}}, http.StatusTooManyRequests)
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: srv.URL})
+
+	start := time.Now()
+	l, err := b.getNewestLogForPlayer(context.Background(), testSteamID)
+	if err != nil || l.ID != 100 {
+		t.Fatalf("getNewestLogForPlayer returned %v, %v, want log 100", l, err)
+	}
+	if *requests != 2 {
+		t.Errorf("logs.tf got %d requests, want 2", *requests)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Retried after %v, want the Retry-After of 1s honored", elapsed)
+	}
+}
+
+func TestGetRetriesServerErrors(t *testing.T) {
+	srv, requests := sequenceServer(t, oneLogSearch, nil, http.StatusBadGateway)
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: srv.URL})
+
+	if l, err := b.getNewestLogForPlayer(context.Background(), testSteamID); err != nil || l.ID != 100 {
+		t.Fatalf("getNewestLogForPlayer returned %v, %v, want log 100", l, err)
+	}
+	if *requests != 2 {
+		t.Errorf("logs.tf got %d requests, want 2", *requests)
+	}
+}
+
+func TestGetDoesNotRetryClientErrors(t *testing.T) {
+	srv, requests := sequenceServer(t, oneLogSearch, nil, http.StatusBadRequest)
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: srv.URL})
+
+	var statusErr *httpStatusError
+	if _, err := b.getNewestLogForPlayer(context.Background(), testSteamID); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("getNewestLogForPlayer returned %v, want the 400", err)
+	}
+	if *requests != 1 {
+		t.Errorf("logs.tf got %d requests, want 1", *requests)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":     0,
+		"5":    5 * time.Second,
+		"0":    0,
+		"-3":   0,
+		"soon": 0,
+		time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat): 0,
+	}
+	for v, want := range tests {
+		if got := parseRetryAfter(v); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", v, got, want)
+		}
+	}
+
+	if got := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); got <= 50*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter of a date a minute from now = %v, want about a minute", got)
+	}
+}
+
+func TestLogsTFDefaultsToHTTPS(t *testing.T) {
+	setCredentials(t)
+	b, err := newBotConfigFromEnv()
+	if err != nil {
+		t.Fatalf("newBotConfigFromEnv failed: %v", err)
+	}
+
+	if got, want := b.logURL(123), "https://logs.tf/123"; got != want {
+		t.Errorf("Log link is %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(b.logsTFBaseURL, "https://") {
+		t.Errorf("logs.tf API is at %q, want https", b.logsTFBaseURL)
+	}
+}
+
+func TestLogsTFUsesTheConfiguredScheme(t *testing.T) {
+	for _, scheme := range []string{"http", "https"} {
+		t.Run(scheme, func(t *testing.T) {
+			var requested string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requested = r.URL.Path
+				if r.TLS != nil {
+					requested = "https " + requested
+				}
+				w.Write([]byte(oneLogSearch))
+			})
+			srv := httptest.NewServer(handler)
+			if scheme == "https" {
+				srv.Close()
+				srv = httptest.NewTLSServer(handler)
+			}
+			t.Cleanup(srv.Close)
+
+			b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: srv.URL})
+			b.httpClient.Transport = srv.Client().Transport
+
+			if _, err := b.getNewestLogForPlayer(context.Background(), testSteamID); err != nil {
+				t.Fatalf("getNewestLogForPlayer failed: %v", err)
+			}
+			if want := strings.TrimPrefix(scheme+" /json_search", "http "); requested != want {
+				t.Errorf("logs.tf got %q, want %q", requested, want)
+			}
+			if got := b.logURL(100); got != srv.URL+"/100" || !strings.HasPrefix(got, scheme+"://") {
+				t.Errorf("Log link is %q, want %v/100", got, srv.URL)
+			}
+		})
+	}
+}
+
+func TestLogsTFBaseURL(t *testing.T) {
+	var requested string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		w.Write([]byte(oneLogSearch))
+	}))
+	defer srv.Close()
+
+	// a trailing slash doesn't end up doubled in the API call or the link
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: srv.URL + "/mirror/"})
+	l, err := b.getNewestLogForPlayer(context.Background(), testSteamID)
+	if err != nil {
+		t.Fatalf("getNewestLogForPlayer failed: %v", err)
+	}
+	if requested != "/mirror/json_search" {
+		t.Errorf("logs.tf got a request for %q, want /mirror/json_search", requested)
+	}
+
+	if got, want := mustRender(t, b, l), srv.URL+"/mirror/100"; got != want {
+		t.Errorf("Posted %q, want %q", got, want)
+	}
+}
+
+func TestLogsTFBaseURLMustBeAnHTTPURL(t *testing.T) {
+	for _, value := range []string{"logs.tf", "ftp://logs.tf", "https://", "https://logs.tf/?key=1", "://logs.tf"} {
+		setCredentials(t)
+		t.Setenv(logsTFBaseURLEnvName, value)
+		if _, err := newBotConfigFromEnv(); err == nil || !strings.Contains(err.Error(), logsTFBaseURLEnvName) {
+			t.Errorf("newBotConfigFromEnv with a base URL of %q returned %v, want an error naming %v", value, err, logsTFBaseURLEnvName)
+		}
+	}
+}
+
+func TestGetNewestLogForPlayerStopsWhenCancelled(t *testing.T) {
+	started := make(chan struct{}, 1)
+	blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(harnessTimeout):
+		}
+	}))
+	defer blocking.Close()
+
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: blocking.URL})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	start := time.Now()
+	if _, err := b.getNewestLogForPlayer(ctx, testSteamID); !errors.Is(err, context.Canceled) {
+		t.Fatalf("getNewestLogForPlayer returned %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("getNewestLogForPlayer took %v to stop after being cancelled", elapsed)
+	}
+}
+
+func TestMalformedResponsesAreDecodeErrors(t *testing.T) {
+	maintenance := "<html><body>" + strings.Repeat("logs.tf is down for maintenance. ", 20) + "</body></html>"
+	tests := map[string]string{
+		"html":      maintenance,
+		"truncated": `{"success": true, "results": 1, "logs": [{"id": 100, "da`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, _ := sequenceServer(t, body, nil)
+			b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: srv.URL})
+
+			_, err := b.getNewestLogForPlayer(context.Background(), testSteamID)
+			var decodeErr *decodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("getNewestLogForPlayer returned %v, want a decode error", err)
+			}
+			var statusErr *httpStatusError
+			if errors.As(err, &statusErr) {
+				t.Errorf("getNewestLogForPlayer returned %v, a decode error shouldn't look like an HTTP error", err)
+			}
+
+			want := body
+			if len(body) > maxBodySnippet {
+				want = body[:maxBodySnippet] + "..."
+			}
+			if decodeErr.body != want {
+				t.Errorf("Error has the body %q, want %q", decodeErr.body, want)
+			}
+		})
+	}
+}
+
+func TestPollingCarriesOnPastMalformedResponses(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(otherSteamID, testLog(100, time.Minute))
+	malformed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("player") == testSteamID {
+			w.Write([]byte("<html>maintenance</html>"))
+			return
+		}
+		logsTF.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer malformed.Close()
+
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, players([]string{testSteamID, otherSteamID}, testChannel), map[string]string{
+		logsTFBaseURLEnvName: malformed.URL,
+		pollWorkersEnvName:   "1",
+	})
+	connectTestBot(t, b, twitch, testChannel)
+
+	b.pollOnce(context.Background())
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 1 || !strings.Contains(messages[0], "/100 ") {
+		t.Errorf("Posted %q, want the other player's log posted despite the malformed response", messages)
+	}
+}
+
+func TestPlayersWithoutLogsAreNotAnError(t *testing.T) {
+	logs := captureLogs(t)
+	logsTF := newFakeLogsTF(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{metricsEnabledEnvName: "true"})
+
+	if _, err := b.getNewestLogForPlayer(context.Background(), testSteamID); !errors.Is(err, errNoLogs) {
+		t.Errorf("getNewestLogForPlayer returned %v, want %v", err, errNoLogs)
+	}
+
+	b.pollOnce(context.Background())
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Errorf("checkLogsForPlayer returned %v, want no error for a player without logs", err)
+	}
+
+	if strings.Contains(logs.String(), "level=ERROR") || strings.Contains(logs.String(), "level=WARN") {
+		t.Errorf("Logged %q, want nothing above debug for a player without logs", logs)
+	}
+	w := httptest.NewRecorder()
+	b.metrics.(http.Handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "logsbot_poll_errors_total 0\n") {
+		t.Errorf("/metrics has %q, want no poll errors", w.Body.String())
+	}
+	b.health.mutex.Lock()
+	defer b.health.mutex.Unlock()
+	if b.health.lastPoll.IsZero() {
+		t.Error("No successful poll recorded, want the polls counted as successful")
+	}
+}
+
+func TestLogsTFRequestsSendTheUserAgent(t *testing.T) {
+	tests := map[string]string{
+		"":                                      defaultUserAgent(),
+		"mybot/1.0 (+mailto:admin@example.com)": "mybot/1.0 (+mailto:admin@example.com)",
+	}
+	for value, want := range tests {
+		var got string
+		logsTF := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header.Get("User-Agent")
+			w.Write([]byte(oneLogSearch))
+		}))
+
+		b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: logsTF.URL, userAgentEnvName: value})
+		if _, err := b.getNewestLogForPlayer(context.Background(), testSteamID); err != nil {
+			t.Fatalf("getNewestLogForPlayer failed: %v", err)
+		}
+		logsTF.Close()
+
+		if got != want {
+			t.Errorf("With %v=%q, sent User-Agent %q, want %q", userAgentEnvName, value, got, want)
+		}
+	}
+
+	if want := "logs-bot/" + version + " "; !strings.HasPrefix(defaultUserAgent(), want) {
+		t.Errorf("Default User-Agent is %q, want it to start with %q", defaultUserAgent(), want)
+	}
+}
+
+// timeoutError is a net.Error for a request that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTemporary(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: &httpStatusError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{err: &httpStatusError{StatusCode: http.StatusBadGateway}, want: true},
+		{err: fmt.Errorf("get: %w", timeoutError{}), want: true},
+		{err: fmt.Errorf("read: %w", syscall.ECONNRESET), want: true},
+		{err: io.ErrUnexpectedEOF, want: true},
+		{err: &httpStatusError{StatusCode: http.StatusNotFound}, want: false},
+		{err: &invalidSteamIDError{steamid: testSteamID, reason: "Invalid steamid"}, want: false},
+		{err: errNoLogs, want: false},
+		{err: &decodeError{err: errors.New("unexpected end of JSON input")}, want: false},
+	}
+	for _, tt := range tests {
+		if got := isTemporary(tt.err); got != tt.want {
+			t.Errorf("isTemporary(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCheckLogsForPlayerRecoversFromATransientFailure(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.fail(1)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	// one check retries the 500 rather than leaving the log for the next cycle
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	twitch.expect(t, "PRIVMSG #lansky :"+logsTF.URL+"/100 — serveme.tf #100 (cp_process_f12)")
+	if n := len(logsTF.requests()); n < 2 {
+		t.Errorf("logs.tf got %d requests, want the failed one retried", n)
+	}
+}
+
+func TestCheckLogsForPlayerRetriesWithinThePollInterval(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.fail(1)
+	interval := duration(500 * time.Millisecond)
+	b := newTestBot(t, logsTF, map[string]channelList{testSteamID: {{Name: testChannel, PollInterval: &interval}}}, nil)
+
+	// the first retry would come after the 500ms interval, so the check gives up and the next one tries again
+	start := time.Now()
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err == nil {
+		t.Fatal("checkLogsForPlayer succeeded, want the 500 from logs.tf")
+	}
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("The check took %v, want it within the 500ms poll interval", elapsed)
+	}
+	if n := len(logsTF.requests()); n != 1 {
+		t.Errorf("logs.tf got %d requests, want 1", n)
+	}
+}
+
+func TestCheckLogsForPlayerDoesNotRetryInvalidSteamIDs(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.errors[testSteamID] = "Invalid steamid"
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), nil)
+
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err == nil {
+		t.Fatal("checkLogsForPlayer succeeded, want logs.tf's error")
+	}
+	if n := len(logsTF.requests()); n != 1 {
+		t.Errorf("logs.tf got %d requests, want 1", n)
+	}
+}
+
+func TestLogSearchPath(t *testing.T) {
+	tests := []struct {
+		search logSearch
+		want   string
+	}{
+		{logSearch{}, "/json_search?"},
+		{logSearch{Players: []string{testSteamID}, Limit: 1}, "/json_search?limit=1&player=" + testSteamID},
+		{logSearch{Players: []string{testSteamID, otherSteamID}}, "/json_search?player=" + testSteamID + "%2C" + otherSteamID},
+		{logSearch{Title: "RGL: lansky vs clockwork & co", Map: "cp_process_f12"}, "/json_search?map=cp_process_f12&title=RGL%3A+lansky+vs+clockwork+%26+co"},
+		{logSearch{Title: "100% pug #3?", Uploader: testSteamID}, "/json_search?title=100%25+pug+%233%3F&uploader=" + testSteamID},
+		{logSearch{Players: []string{testSteamID}, Limit: 5, Offset: 10}, "/json_search?limit=5&offset=10&player=" + testSteamID},
+	}
+	for _, test := range tests {
+		if got := test.search.path(); got != test.want {
+			t.Errorf("%+v.path() = %q, want %q", test.search, got, test.want)
+		}
+	}
+}
+
+func TestNewestLogSearchIsForThePlayer(t *testing.T) {
+	var query url.Values
+	logsTF := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(`{"success": true, "results": 0, "logs": []}`))
+	}))
+	defer logsTF.Close()
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: logsTF.URL, searchLimitEnvName: "3"})
+
+	// the search is for the SteamID64 whichever format the steamid is given in
+	b.getNewestLogForPlayer(context.Background(), "[U:1:146974878]")
+	if want := (url.Values{"player": {testSteamID}, "limit": {"3"}}); !reflect.DeepEqual(query, want) {
+		t.Errorf("logs.tf was searched with %v, want %v", query, want)
+	}
+}
@@ -2,229 +2,968 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"io/fs"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/textproto"
 	"os"
-	"strconv"
+	"os/signal"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
 	"time"
 )
 
 const (
-	staleLogThresholdInSeconds  = 60 // how old a log must be in seconds to be considered stale
-	spoilerDelayInSeconds       = 15 // how long to delay sending logs to prevent spoilers (stream delay)
-	logRefreshTimeInSeconds     = 10 // how long to wait between checking for log updates
-	twitchIRCRetryTimeInSeconds = 30 // how long to wait between failed connection attempts
-
-	twitchIRCHostPort = "irc.chat.twitch.tv:6667"
-
-	channelsFileName = "channels.json"
-
-	userNameEnvName = "LOGS_BOT_USERNAME"
-	oauthKeyEnvName = "LOGS_BOT_OAUTH_KEY"
+	twitchJoinBatchSize         = 20 // how many channels can be joined per batch (Twitch join rate limit)
+	twitchJoinBatchDelaySeconds = 10 // how long to wait between join batches
+	stateSaveIntervalInSeconds  = 60 // how long to wait between saving the last seen log timestamps
+
+	defaultStaleLogThreshold = 60 * time.Second // how old a log must be to be considered stale
+	defaultSpoilerDelay      = 15 * time.Second // how long to delay sending logs to prevent spoilers (stream delay)
+	defaultLogRefreshTime    = 10 * time.Second // how long to wait between checking for log updates
+	defaultHTTPTimeout       = 10 * time.Second // how long to wait for a logs.tf response before giving up
+	defaultRateLimitBurst    = 20               // how many IRC messages can be sent per rate limit period
+	defaultRateLimitPeriod   = 30 * time.Second // Twitch allows 20 messages per 30 seconds for normal bots
+	quitTimeout              = 5 * time.Second  // how long to wait to send the PARTs and QUIT when shutting down
+	defaultReadTimeout       = 6 * time.Minute  // how long to go without hearing from Twitch before reconnecting
+	defaultWriteTimeout      = 10 * time.Second // how long a line can take to write before the connection is considered dead
+	defaultReconnectBase     = 1 * time.Second  // how long to wait after the first failed connection attempt
+	defaultReconnectMax      = 5 * time.Minute  // the longest to wait between failed connection attempts
+	defaultReconnectReset    = 1 * time.Minute  // how long a connection must stay up to reset the backoff
+	defaultPollWorkers       = 4                // how many players can be polled from logs.tf at once
+	defaultSearchLimit       = 5                // how many of a player's newest logs are checked each poll
+	defaultMaxPosts          = 20               // how many logs can be waiting out their spoiler delay or being sent at once
+
+	twitchIRCHostPort    = "irc.chat.twitch.tv:6667"
+	twitchIRCTLSHostPort = "irc.chat.twitch.tv:6697"
+
+	channelsFileName     = "channels.json"
+	defaultStateFileName = "state.json"
+	defaultHTTPAddr      = ":8080"
+	defaultLogsTFBaseURL = "https://logs.tf"
+
+	userNameEnvName          = "LOGS_BOT_USERNAME"
+	oauthKeyEnvName          = "LOGS_BOT_OAUTH_KEY"
+	staleLogThresholdEnvName = "LOGS_BOT_STALE_LOG_THRESHOLD"
+	spoilerDelayEnvName      = "LOGS_BOT_SPOILER_DELAY"
+	logRefreshTimeEnvName    = "LOGS_BOT_POLL_INTERVAL"
+	httpTimeoutEnvName       = "LOGS_BOT_HTTP_TIMEOUT"
+	stateFileEnvName         = "LOGS_BOT_STATE_FILE"
+	pausedChannelsEnvName    = "LOGS_BOT_PAUSED_CHANNELS_FILE"
+	postedLogsEnvName        = "LOGS_BOT_POSTED_LOGS_FILE"
+	dedupWindowEnvName       = "LOGS_BOT_DEDUP_WINDOW"
+	rateLimitBurstEnvName    = "LOGS_BOT_RATE_LIMIT_BURST"
+	rateLimitPeriodEnvName   = "LOGS_BOT_RATE_LIMIT_PERIOD"
+	useTLSEnvName            = "LOGS_BOT_USE_TLS"
+	tlsSkipVerifyEnvName     = "LOGS_BOT_TLS_SKIP_VERIFY"
+	ircAddrEnvName           = "LOGS_BOT_IRC_ADDR"
+	httpAddrEnvName          = "LOGS_BOT_HTTP_ADDR"
+	metricsEnabledEnvName    = "LOGS_BOT_METRICS_ENABLED"
+	messageTemplateEnvName   = "LOGS_BOT_MESSAGE_TEMPLATE"
+	logsTFBaseURLEnvName     = "LOGS_BOT_LOGS_BASE_URL"
+	logsTFMirrorsEnvName     = "LOGS_BOT_LOGS_MIRRORS"
+	pollWorkersEnvName       = "LOGS_BOT_POLL_WORKERS"
+	maxPostsEnvName          = "LOGS_BOT_MAX_CONCURRENT_POSTS"
+	searchLimitEnvName       = "LOGS_BOT_SEARCH_LIMIT"
+	strictConfigEnvName      = "LOGS_BOT_STRICT_CONFIG"
+	logLevelEnvName          = "LOGS_BOT_LOG_LEVEL"
+	logFormatEnvName         = "LOGS_BOT_LOG_FORMAT"
+	commandUsersEnvName      = "LOGS_BOT_COMMAND_USERS"
+	dryRunEnvName            = "LOGS_BOT_DRY_RUN"
+	seedOnStartupEnvName     = "LOGS_BOT_SEED_ON_STARTUP"
+	startupDelayEnvName      = "LOGS_BOT_STARTUP_DELAY"
+	configEnvName            = "LOGS_BOT_CONFIG"
+	channelsJSONEnvName      = "LOGS_BOT_CHANNELS_JSON"
+	configURLEnvName         = "LOGS_BOT_CONFIG_URL"
+	configRefreshEnvName     = "LOGS_BOT_CONFIG_REFRESH"
+	configCacheEnvName       = "LOGS_BOT_CONFIG_CACHE_FILE"
+	webhookURLEnvName        = "LOGS_BOT_WEBHOOK_URL"
+	leagueAPIURLEnvName      = "LOGS_BOT_LEAGUE_API_URL"
+	userAgentEnvName         = "LOGS_BOT_USER_AGENT"
+	logLinkFormatEnvName     = "LOGS_BOT_LOG_LINK_FORMAT"
+	steamAPIKeyEnvName       = "LOGS_BOT_STEAM_API_KEY"
+	steamAPIURLEnvName       = "LOGS_BOT_STEAM_API_URL"
+	logCacheTTLEnvName       = "LOGS_BOT_CACHE_TTL"
+	breakerThresholdEnvName  = "LOGS_BOT_CIRCUIT_BREAKER_THRESHOLD"
+	breakerCooldownEnvName   = "LOGS_BOT_CIRCUIT_BREAKER_COOLDOWN"
+	hideSteamIDsEnvName      = "LOGS_BOT_HIDE_STEAMIDS"
+	adminUsersEnvName        = "LOGS_BOT_ADMIN_USERS"
+	readTimeoutEnvName       = "LOGS_BOT_READ_TIMEOUT"
+	writeTimeoutEnvName      = "LOGS_BOT_WRITE_TIMEOUT"
+	postIntervalEnvName      = "LOGS_BOT_CHANNEL_POST_INTERVAL"
+	maxMessageLengthEnvName  = "LOGS_BOT_MAX_MESSAGE_LENGTH"
+	timezoneEnvName          = "LOGS_BOT_TIMEZONE"
+	timeFormatEnvName        = "LOGS_BOT_TIME_FORMAT"
+	postPolicyEnvName        = "LOGS_BOT_CHANNEL_POST_POLICY"
+	playerCooldownEnvName    = "LOGS_BOT_PLAYER_COOLDOWN"
+	gameFormatsEnvName       = "LOGS_BOT_GAME_FORMATS"
+	allowedFormatsEnvName    = "LOGS_BOT_ALLOWED_FORMATS"
+	allowedMapsEnvName       = "LOGS_BOT_ALLOWED_MAPS"
+	deniedMapsEnvName        = "LOGS_BOT_DENIED_MAPS"
+	reconnectBaseEnvName     = "LOGS_BOT_RECONNECT_BASE"
+	reconnectMaxEnvName      = "LOGS_BOT_RECONNECT_MAX"
+	reconnectResetEnvName    = "LOGS_BOT_RECONNECT_RESET"
 )
 
-type logResponse struct {
-	Date  int64  `json:"date"`
-	ID    int    `json:"id"`
-	Title string `json:"title"`
-}
+var (
+	errAuthFailed         = errors.New("Twitch IRC authentication failed")
+	errReconnectRequested = errors.New("Twitch IRC server requested a reconnect")
+	errReconnectCommanded = errors.New("an admin requested a reconnect")
+)
 
 type botConfig struct {
-	conn                   net.Conn
-	steamIDToTwitchChannel map[string]string
-
-	mutex             *sync.Mutex
-	steamIDToLastTime map[string]time.Time
+	staleLogThreshold time.Duration
+	spoilerDelay      time.Duration // the default for channels that don't set their own
+	messageTemplate   *template.Template
+	logRefreshTime    time.Duration
+	pollWorkers       int
+	searchLimit       int           // how many of a player's newest logs are looked up, so logs between polls aren't missed
+	postSlots         chan struct{} // one for each post waiting out its spoiler delay or being sent
+	strictConfig      bool          // refuse to start if the config has invalid steamids
+	dryRun            bool          // log posts instead of sending them
+	seedOnStartup     bool          // treat each player's newest log at startup as already posted
+	startupDelay      time.Duration // how long after starting new logs are marked as seen without being posted
+	postingFrom       time.Time     // when the startup delay is over
+	gameFormats       []gameFormat
+	allowedFormats    map[string]bool // formats posted to channels that don't set their own, all if empty
+	allowedMaps       []string        // map prefixes posted to channels that don't set their own, all if empty
+	deniedMaps        []string        // map prefixes never posted to channels that don't set their own
+	pacer             *channelPacer
+	cooldowns         *playerCooldowns
+	maxMessageLength  int            // in bytes, longer messages are shortened
+	timeLocation      *time.Location // what logs' times are written in
+	timeFormat        string
+
+	reconnectBase  time.Duration
+	reconnectMax   time.Duration
+	reconnectReset time.Duration
+	readTimeout    time.Duration // how long the connection can be silent before it's considered dead
+	writeTimeout   time.Duration // how long a write can block before the connection is considered dead
+
+	ircAddr   string
+	tlsConfig *tls.Config // nil when connecting over plaintext
+
+	// all writes to conn go through send, which queues them on the current connection's writer
+	dial       func(ctx context.Context) (ircConn, error)
+	conn       ircConn
+	writer     *ircWriter
+	writeMutex *sync.Mutex
+	joined     *joinedChannels // channels Twitch has confirmed the bot is in on the current connection
+
+	limiter         *rateLimiter
+	rateLimitBurst  int
+	rateLimitPeriod time.Duration
+
+	channelsFile string
+	channelsJSON string // the config given inline, used if channelsFile doesn't exist
+
+	configURL     string        // where the config is fetched from instead of channelsFile
+	configRefresh time.Duration // how often the config is fetched again from configURL
+	configCache   string        // where the config last fetched from configURL is saved, to start with if it's down
+	fetchedConfig []byte        // the config last fetched from configURL, to tell whether it changed
+
+	// mutex guards steamIDToTwitchChannel and steamIDToLastLog. steamIDToTwitchChannel is replaced, never
+	// modified, when the config changes, read it with playerChannels.
+	mutex                  *sync.Mutex
+	steamIDToTwitchChannel map[string]channelList
+	steamIDToLastLog       map[string]lastLog
+	stateFileName          string
+	posted                 *postedLogs
+
+	pausedChannelsFile string
+	postedLogsFile     string
+	dedupWindow        time.Duration // how long a posted log isn't posted again anywhere, zero to only dedup per channel
+	postedIDs          *postedLogIDs
+	paused             *pausedChannels // channels with !logs off
+
+	httpClient    *http.Client
+	userAgent     string // sent with every request, so logs.tf can tell who's polling it
+	httpTimeout   time.Duration
+	logsTFBaseURL string      // used for both logs.tf API calls and posted links, without a trailing slash
+	mirrors       *mirrorPool // the base URL and any mirrors, which API calls are spread across
+	logLinkFormat string      // posted instead of the logs.tf link with <id> replaced, empty if unset
+	logCache      *logCache
+	breaker       *circuitBreaker   // stops polling logs.tf for a while when it's down
+	details       *detailCache      // details of recently posted logs
+	logPlayers    *logPlayers       // tracked players recent logs were found for
+	webhookURL    string            // where an event is sent for each posted log, "" for none
+	webhookEvents chan webhookEvent // events waiting to be sent to webhookURL
+	leagueAPIURL  string            // where to look up logs' league matches, without a trailing slash, empty if unset
+	steamAPIKey   string            // for resolving vanity profile URLs in the config, empty if unset
+	steamAPIURL   string            // without a trailing slash
+	vanityNames   *vanityCache
+
+	httpAddr   string
+	health     healthState
+	metrics    metrics
+	pollErrors *pollErrorLog
+	invalidIDs *invalidSteamIDs
+	statuses   *playerStatuses
+	stats      *sessionStats
+
+	commandUsers map[string]bool // who can run chat commands, anyone if empty
+	hideSteamIDs bool            // leave steamids out of command replies
+	adminUsers   map[string]bool // who can change the channels by whispering the bot
+	configMutex  *sync.Mutex     // held while an admin command updates the channels config
+
+	once     bool               // check every player once and exit, for running from cron
+	shutdown context.CancelFunc // stops the bot once the -once pass is done
+	posts    *sync.WaitGroup    // logs being posted, and mirrored to Discord, in the background
 
 	userName string
 	oauthKey string
 }
 
 func main() {
-	userName := os.Getenv(userNameEnvName)
-	oauthKey := os.Getenv(oauthKeyEnvName)
+	configFile := flag.String("config", "", "path to the channels config file, overrides "+configEnvName+" (default "+channelsFileName+")")
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	once := flag.Bool("once", false, "connect, check every player once, post any new logs and exit")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
 
-	if userName == "" || oauthKey == "" {
-		log.Printf("Environment variables %v and %v not set.", userNameEnvName, oauthKeyEnvName)
+	if err := setupLogging(); err != nil {
+		slog.Error(err.Error())
 		os.Exit(1)
 	}
 
-	steamIDToTwitchChannel, err := loadChannelsFromFile()
+	b, err := newBotConfigFromEnv()
 	if err != nil {
-		log.Printf("Failed to load channels from %v: %v\n", channelsFileName, err)
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	if *configFile != "" {
+		b.channelsFile = *configFile
+	}
+
+	v, c, date := buildInfo()
+	slog.Info("Starting logs-bot", "version", v, "commit", c, "build_date", date)
+	b.logConfig()
+
+	// cancel everything on SIGINT/SIGTERM so in-flight work, including the startup checks, can wind down cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *once {
+		b.once = true
+		ctx, b.shutdown = context.WithCancel(ctx)
+	}
+
+	b.steamIDToTwitchChannel, err = b.loadChannels(ctx)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Error("Channels config not found, create it, set its path with -config or "+configEnvName+", or set "+channelsJSONEnvName, "path", b.channelsFile)
 		os.Exit(1)
+	} else if err != nil {
+		slog.Error("Failed to load channels", "path", b.channelsFile, "error", err)
+		os.Exit(1)
+	}
+
+	if err := b.validateSteamIDs(ctx); ctx.Err() != nil {
+		slog.Info("Shut down")
+		return
+	} else if err != nil {
+		if b.strictConfig {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		slog.Warn(err.Error() + ", continuing since " + strictConfigEnvName + " isn't set")
+	}
+
+	b.steamIDToLastLog = loadState(b.stateFileName)
+	b.paused = loadPausedChannels(b.pausedChannelsFile)
+	b.postedIDs = loadPostedLogIDs(b.postedLogsFile, b.dedupWindow)
+	if b.seedOnStartup {
+		b.seedLastSeen(ctx)
 	}
+	b.postingFrom = time.Now().Add(b.startupDelay)
+	if b.startupDelay > 0 {
+		go func() {
+			if sleepUntil(ctx, b.postingFrom) {
+				slog.Info("Startup delay is over, posting new logs")
+			}
+		}()
+	}
+	b.metrics.setTrackedPlayers(len(b.playerChannels()))
 
-	b := &botConfig{
-		userName:               userName,
-		oauthKey:               oauthKey,
-		steamIDToTwitchChannel: steamIDToTwitchChannel,
-		mutex:             &sync.Mutex{},
-		steamIDToLastTime: map[string]time.Time{},
+	// a single pass sends its webhook events once it's done posting instead
+	if b.webhookURL != "" && !b.once {
+		go b.sendWebhookEvents(ctx)
 	}
 
+	// a single pass doesn't run long enough for reloads or the HTTP server to be of use
+	if !b.once {
+		go b.saveStatePeriodically(ctx)
+		go b.reloadChannelsOnHangup(ctx)
+		if b.configURL != "" {
+			go b.refreshChannelsPeriodically(ctx)
+		}
+		go b.serveHTTP(ctx, b.httpAddr)
+	}
+
+	retry := &backoff{base: b.reconnectBase, max: b.reconnectMax}
 	for {
-		err := b.Serve()
-		log.Printf("Error serving: %v, retrying in %v seconds\n", err, twitchIRCRetryTimeInSeconds)
-		time.Sleep(twitchIRCRetryTimeInSeconds * time.Second)
+		start := time.Now()
+		err := b.Serve(ctx)
+		if ctx.Err() != nil || errors.Is(err, errAuthFailed) {
+			if err := b.saveState(); err != nil {
+				slog.Error("Failed to save state", "path", b.stateFileName, "error", err)
+			}
+
+			if errors.Is(err, errAuthFailed) {
+				slog.Error("Error serving, check "+userNameEnvName+" and "+oauthKeyEnvName, "error", err)
+				os.Exit(1)
+			}
+
+			slog.Info("Shut down")
+			return
+		}
+
+		// a pass that didn't finish is retried by the next run rather than by reconnecting
+		if b.once {
+			if err := b.saveState(); err != nil {
+				slog.Error("Failed to save state", "path", b.stateFileName, "error", err)
+			}
+			slog.Error("Error serving", "error", err)
+			os.Exit(1)
+		}
+
+		// the server or an admin asked us to reconnect, so do it right away
+		if errors.Is(err, errReconnectRequested) || errors.Is(err, errReconnectCommanded) {
+			slog.Info("Reconnecting", "reason", err)
+			retry.reset()
+			continue
+		}
+
+		// a connection that stayed up for a while means things were healthy, so start the backoff over
+		if time.Since(start) >= b.reconnectReset {
+			retry.reset()
+		}
+
+		delay := retry.next()
+		slog.Error("Error serving, retrying", "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
 	}
 }
 
-func (b *botConfig) Serve() error {
-	log.Printf("Connecting to Twitch IRC server\n")
-	if err := b.connect(); err != nil {
-		return fmt.Errorf("Failed to connect to Twitch IRC server\n")
+func (b *botConfig) Serve(ctx context.Context) error {
+	slog.Info("Connecting to Twitch IRC server", "addr", b.ircAddr)
+	if err := b.connect(ctx); err != nil {
+		return fmt.Errorf("Failed to connect to Twitch IRC server: %v\n", err)
 	}
 
-	log.Printf("Connected!\n")
-
-	// spawn a worker processes that periodically checks for log updates and shuts down when
-	// the IRC server connection errors/drops
-	die := make(chan struct{})
-	go func(die chan struct{}) {
-		for {
-			select {
-			case <-die:
-				return
-			default:
-				for steamid, channel := range b.steamIDToTwitchChannel {
-					go b.checkLogsForPlayer(steamid, channel)
-				}
-				time.Sleep(logRefreshTimeInSeconds * time.Second)
+	slog.Info("Connected!")
+	b.stats.recordConnection()
+	b.health.setConnected(true)
+	defer b.health.setConnected(false)
+
+	// the session context is cancelled when the bot is shutting down or the connection drops,
+	// which stops the worker and closes the connection
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	conn, writer := b.conn, b.writer
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		<-sessionCtx.Done()
+
+		// only say goodbye if we're shutting down, otherwise the connection is already gone
+		var finalLines []string
+		if ctx.Err() != nil {
+			for _, channel := range b.joined.names() {
+				finalLines = append(finalLines, "PART #"+channel)
 			}
+			finalLines = append(finalLines, "QUIT")
 		}
-	}(die)
+		writer.stop(finalLines...)
+		conn.Close()
+	}()
+
+	// the joins from any previous session are gone, so (re)join every channel before polling
+	if err := b.joinChannels(sessionCtx); err != nil {
+		cancel()
+		<-closed
+		return fmt.Errorf("Failed to join channels: %v\n", err)
+	}
 
-	// read messages endlessly until an error occurs, then shut down worker process
-	err := b.readMessages()
-	die <- struct{}{}
+	// spawn the pollers that periodically check for log updates and shut down when the IRC server
+	// connection errors/drops
+	polling := make(chan struct{})
+	go func() {
+		defer close(polling)
+		if b.once {
+			b.pollOnce(sessionCtx)
+			b.shutdown()
+			return
+		}
+		b.pollLogs(sessionCtx)
+	}()
+
+	// read messages endlessly until an error occurs or the connection is closed, then shut down
+	// the pollers
+	err := b.readMessages(sessionCtx)
+	cancel()
+	<-polling
+	<-closed
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	return err
 }
 
-func (b *botConfig) connect() error {
-	conn, err := net.Dial("tcp", twitchIRCHostPort)
+// ircConn is the connection to the IRC server. It's satisfied by net.Conn, and lets the connection be
+// swapped out for an in-memory one by replacing botConfig.dial.
+type ircConn interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// dialIRC connects to the Twitch IRC server, over TLS unless it's disabled
+func (b *botConfig) dialIRC(ctx context.Context) (ircConn, error) {
+	if b.tlsConfig != nil {
+		d := &tls.Dialer{Config: b.tlsConfig}
+		return d.DialContext(ctx, "tcp", b.ircAddr)
+	}
+
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", b.ircAddr)
+}
+
+func (b *botConfig) connect(ctx context.Context) error {
+	conn, err := b.dial(ctx)
 	if err != nil {
 		return err
 	}
 
-	fmt.Fprintf(conn, "PASS %s\r\n", string(b.oauthKey))
-	fmt.Fprintf(conn, "NICK %s\r\n", b.userName)
+	writer := newIRCWriter(conn, b.limiter, b.writeTimeout)
+	go writer.run()
 
+	b.writeMutex.Lock()
 	b.conn = conn
+	b.writer = writer
+	b.writeMutex.Unlock()
+
+	// joins don't carry over to a new connection
+	b.joined.reset()
+
+	if err := b.send(ctx, "CAP REQ :%s", twitchCapabilities); err != nil {
+		writer.stop()
+		conn.Close()
+		return err
+	}
+
+	if err := b.send(ctx, "PASS %s", b.oauthKey); err != nil {
+		writer.stop()
+		conn.Close()
+		return err
+	}
+
+	if err := b.send(ctx, "NICK %s", b.userName); err != nil {
+		writer.stop()
+		conn.Close()
+		return err
+	}
+
+	return nil
+}
+
+// send queues a single IRC line to be written to the current connection
+func (b *botConfig) send(ctx context.Context, format string, args ...interface{}) error {
+	b.writeMutex.Lock()
+	writer := b.writer
+	b.writeMutex.Unlock()
+
+	if writer == nil {
+		return errWriterClosed
+	}
+
+	return writer.send(ctx, fmt.Sprintf(format, args...))
+}
+
+func (b *botConfig) joinChannels(ctx context.Context) error {
+	for i, channel := range b.channels() {
+		// pace the joins to stay under Twitch's join rate limit
+		if i > 0 && i%twitchJoinBatchSize == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(twitchJoinBatchDelaySeconds * time.Second):
+			}
+		}
+
+		if err := b.send(ctx, "JOIN #%s", channel); err != nil {
+			return err
+		}
+
+		slog.Debug("Joining channel", "channel", channel)
+	}
+
 	return nil
 }
 
-func (b *botConfig) readMessages() error {
+// channels returns the distinct Twitch channels in the config, sorted so joins happen in a stable order
+func (b *botConfig) channels() []string {
+	return channelNames(b.playerChannels())
+}
+
+// playerChannels returns the current steamid to channels mapping. The mapping is replaced rather than
+// modified when the config is reloaded, so the returned map is safe to read without holding the lock.
+func (b *botConfig) playerChannels() map[string]channelList {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.steamIDToTwitchChannel
+}
+
+// channelNames returns the distinct channel names in the mapping, sorted
+func channelNames(steamIDToTwitchChannel map[string]channelList) []string {
+	seen := map[string]bool{}
+	var channels []string
+	for _, list := range steamIDToTwitchChannel {
+		for _, channel := range list {
+			if !seen[channel.Name] {
+				seen[channel.Name] = true
+				channels = append(channels, channel.Name)
+			}
+		}
+	}
+
+	sort.Strings(channels)
+	return channels
+}
+
+func (b *botConfig) readMessages(ctx context.Context) error {
 	tp := textproto.NewReader(bufio.NewReader(b.conn))
 
+	// Twitch pings every five minutes or so. If nothing arrives for half the read timeout we ping it
+	// ourselves, and if there's still nothing by the full timeout the connection is dead and ReadLine would
+	// otherwise block forever.
+	pinged := false
 	for {
-		time.Sleep(1 * time.Second)
+		if err := b.conn.SetReadDeadline(time.Now().Add(b.readTimeout / 2)); err != nil {
+			return err
+		}
 
 		line, err := tp.ReadLine()
-		if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && !pinged {
+			if err := b.send(ctx, "PING :tmi.twitch.tv"); err != nil {
+				return err
+			}
+			pinged = true
+			continue
+		} else if err != nil {
 			return err
 		}
+		pinged = false
 
-		// respond to pings to keep the bot alive
-		if strings.Contains(line, "PING") {
-			fmt.Fprintf(b.conn, "PONG :tmi.twitch.tv\r\n")
+		msg, ok := parseIRCMessage(line)
+		if !ok {
+			slog.Debug("Ignoring unparseable line from Twitch IRC server", "line", line)
+			continue
+		}
+
+		switch msg.command {
+		case "NOTICE":
+			// Twitch sends a NOTICE and drops the connection when the oauth key is rejected, retrying with the
+			// same credentials won't help
+			if isAuthFailureNotice(msg.trailing) {
+				return errAuthFailed
+			}
+
+		case "RECONNECT":
+			// Twitch asks clients to reconnect before it closes the connection for maintenance
+			return errReconnectRequested
+
+		case "CAP":
+			// Twitch acknowledges (or rejects) the capabilities requested in connect
+			switch msg.param(1) {
+			case "ACK":
+				slog.Info("Twitch IRC capabilities acknowledged", "line", line)
+			case "NAK":
+				slog.Warn("Twitch IRC capabilities rejected", "line", line)
+			}
+
+		case "JOIN", "PART", "366":
+			// other users' joins and parts are only interesting to know when our own have gone through
+			nick, channel, joined, ok := parseMembership(msg)
+			if !ok || nick != strings.ToLower(b.userName) {
+				continue
+			}
+			if joined {
+				slog.Info("Joined channel", "channel", channel)
+				b.joined.confirm(channel)
+			} else {
+				slog.Info("Parted channel", "channel", channel)
+				b.joined.part(channel)
+			}
+
+		case "PRIVMSG":
+			// handle chat commands in the background so a slow logs.tf lookup doesn't hold up reading
+			if m, ok := parsePrivmsg(msg); ok {
+				go b.handleChatMessage(ctx, m)
+			}
+
+		case "WHISPER":
+			m, ok := parseWhisper(msg)
+			if !ok {
+				continue
+			}
+			// reconnecting tears down this connection, so it can't be handled in the background
+			if b.isReconnectCommand(m) {
+				slog.Info("Reconnect requested", "user", m.user)
+				return errReconnectCommanded
+			}
+			go b.handleWhisper(ctx, m)
+
+		case "PING":
+			// respond to pings to keep the bot alive
+			if err := b.send(ctx, "PONG :%s", msg.trailing); err != nil {
+				return err
+			}
 		}
 	}
 }
 
-func (b *botConfig) checkLogsForPlayer(steamid, channel string) error {
-	res, err := getNewestLogForPlayer(steamid)
+func isAuthFailureNotice(text string) bool {
+	return strings.Contains(text, "Login authentication failed") ||
+		strings.Contains(text, "Improperly formatted auth")
+}
+
+func (b *botConfig) checkLogsForPlayer(ctx context.Context, steamid string, channels channelList) error {
+	// channels listing their players wait on this to know the player's had a chance to find a shared log
+	defer b.logPlayers.checked(steamid, time.Now())
+
+	// transient failures are retried within the lookup, but not for longer than the player's poll interval
+	// so a struggling logs.tf doesn't hold the player up past its next check
+	lookupCtx, cancel := context.WithTimeout(ctx, channels.pollInterval(b.logRefreshTime))
+	logs, err := b.getRecentLogsForPlayer(lookupCtx, steamid)
+	cancel()
+	if ctx.Err() == nil {
+		b.statuses.record(steamid, err)
+	}
+
+	// a player without any logs yet isn't a failed poll, there's just nothing to post
+	if errors.Is(err, errNoLogs) {
+		b.health.recordPollSuccess()
+		slog.Debug("Player has no logs", "steamid", steamid)
+		return nil
+	}
 	if err != nil {
+		b.metrics.pollError()
 		return err
 	}
+	b.health.recordPollSuccess()
+
+	// more than one log can be new if the player finished several matches since the last check, e.g. after
+	// an outage, so go through them oldest first and post each one
+	var claimed []claimedLog
+	for i := len(logs) - 1; i >= 0; i-- {
+		if c, ok := b.claimLog(steamid, &logs[i], i == 0, channels); ok {
+			claimed = append(claimed, c)
+		}
+	}
+	if len(claimed) == 0 {
+		return nil
+	}
+
+	// send in the background so the spoiler delay doesn't tie up a poll worker, one log after another so
+	// they're posted in the order they were played
+	b.posts.Add(1)
+	go func() {
+		defer b.posts.Done()
+		for _, c := range claimed {
+			b.postLog(ctx, steamid, c.log, c.seen, c.last, c.channels)
+		}
+	}()
+	return nil
+}
+
+// claimedLog is a log that's new for the player, along with the channels that want it
+type claimedLog struct {
+	log        *logResponse
+	seen, last lastLog // the log, and the player's last seen log before it
+	channels   channelList
+}
+
+// claimLog marks the log as seen for the player if it's newer than their last seen log, returning false if
+// it isn't or no channel wants it. newest is set for the player's newest log.
+func (b *botConfig) claimLog(steamid string, res *logResponse, newest bool, channels channelList) (claimedLog, bool) {
+	seen := lastLog{Time: time.Unix(res.Date, 0), ID: res.ID}
 
-	id := strconv.Itoa(res.ID)
-	timestamp := time.Unix(res.Date, 0)
+	// a log can be up to a poll cycle old by the time the player is checked, so players checked less often
+	// than the stale threshold only have logs older than their cycle skipped
+	stale := b.staleLogThreshold
+	if cycle := b.pollCycle(channels.pollInterval(b.logRefreshTime)); cycle > stale {
+		stale = cycle
+	}
 
+	// claim the log under the lock so a concurrent check for the same player won't also send it,
+	// but don't hold the lock through the spoiler delay
 	b.mutex.Lock()
-	defer b.mutex.Unlock()
-	lastTime, _ := b.steamIDToLastTime[steamid]
+	last := b.steamIDToLastLog[steamid]
+
+	// only post logs that are newer than the last one we've seen and recent enough to be from a match that
+	// just ended, different logs can share a timestamp so compare ids too. State saved before log ids were
+	// tracked only has the timestamp.
+	switch {
+	case time.Since(seen.Time) > stale:
+		b.mutex.Unlock()
+		return claimedLog{}, false
+	case seen.Time.Before(last.Time):
+		b.mutex.Unlock()
+		// older logs than the last one seen are expected among the player's recent logs, but not as the newest
+		if newest {
+			slog.Warn("Newest log is older than the last one seen, skipping", "steamid", steamid, "log_id", seen.ID, "last_log_id", last.ID)
+		}
+		return claimedLog{}, false
+	case seen.Time.Equal(last.Time) && (seen.ID <= last.ID || last.ID == 0):
+		b.mutex.Unlock()
+		return claimedLog{}, false
+	}
 
-	// if the log is stale or it hasn't been updated (its timestamp is the same as the last one we've seen),
-	// then do nothing
-	elapsed := time.Since(timestamp)
-	if elapsed.Seconds() > staleLogThresholdInSeconds || timestamp.Equal(lastTime) {
-		return nil
+	b.steamIDToLastLog[steamid] = seen
+	b.mutex.Unlock()
+	b.logPlayers.add(seen.ID, steamid)
+
+	// logs found while the bot is starting up are only marked as seen, in case the config needs a look first
+	if time.Now().Before(b.postingFrom) {
+		slog.Info("Skipping log, the bot only started recently", "steamid", steamid, "log_id", seen.ID,
+			"posting_in", time.Until(b.postingFrom).Round(time.Second).String())
+		return claimedLog{}, false
 	}
 
-	if err := b.sendLogToChannel(id, channel); err != nil {
-		return err
+	// a player without a last seen log has never had one posted (or seeded), which is worth telling apart
+	// from routine updates when a restart re-announces a match
+	initial := last.Time.IsZero()
+	slog.Info("New log for player", "steamid", steamid, "log_id", seen.ID, "initial", initial)
+	b.metrics.newLog(initial)
+
+	// only post to the channels that want logs of this format and map, the log stays claimed either way so
+	// it isn't checked again
+	format := b.gameFormatForPlayers(res.Players)
+	if channels = b.channelsAllowingFormat(channels, format); len(channels) == 0 {
+		slog.Debug("Skipping log, no channel allows its format", "steamid", steamid, "log_id", res.ID, "players", res.Players, "format", format)
+		return claimedLog{}, false
+	}
+	if channels = b.channelsAllowingMap(channels, res.Map); len(channels) == 0 {
+		slog.Debug("Skipping log, no channel allows its map", "steamid", steamid, "log_id", res.ID, "map", res.Map)
+		return claimedLog{}, false
+	}
+	if channels = b.channelsNotPaused(channels); len(channels) == 0 {
+		slog.Debug("Skipping log, posts are paused in every channel", "steamid", steamid, "log_id", res.ID)
+		return claimedLog{}, false
+	}
+	if channels = b.channelsOutsideQuietHours(channels, time.Now()); len(channels) == 0 {
+		slog.Debug("Skipping log, every channel is in its quiet hours", "steamid", steamid, "log_id", res.ID)
+		return claimedLog{}, false
+	}
+	if !b.cooldowns.ready(steamid) {
+		slog.Info("Skipping log, a log was posted for the player too recently", "steamid", steamid, "log_id", res.ID, "cooldown", b.cooldowns.cooldown.String())
+		return claimedLog{}, false
 	}
 
-	// save the last seen timestamp
-	b.steamIDToLastTime[steamid] = timestamp
-	return nil
+	return claimedLog{log: res, seen: seen, last: last, channels: channels}, true
 }
 
-func (b *botConfig) sendLogToChannel(logID, channel string) error {
-	// sleep to prevent spoilers due to stream delay
-	time.Sleep(spoilerDelayInSeconds * time.Second)
+// postLog sends a claimed log to the player's channels, releasing the claim if every send failed
+func (b *botConfig) postLog(ctx context.Context, steamid string, l *logResponse, seen, last lastLog, channels channelList) {
+	// look up the league match alongside the details, so a slow league API doesn't add to the wait
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.addLeagueMatch(ctx, l)
+	}()
+
+	outcome := ""
+	var length time.Duration
+	uploader := ""
+	if d, ok := b.addDetail(ctx, l); ok {
+		outcome = d.outcome(steamid)
+		length = d.length
+		uploader = d.uploader
+	}
+	wg.Wait()
+	if channels = channelsAllowingOutcome(channels, outcome); len(channels) == 0 {
+		slog.Debug("Skipping log, no channel allows its outcome", "steamid", steamid, "log_id", l.ID, "outcome", outcome)
+		return
+	}
+	if channels = channelsAllowingLength(channels, length); len(channels) == 0 {
+		slog.Debug("Skipping log, it's shorter than every channel's min_duration", "steamid", steamid, "log_id", l.ID, "length", length.String())
+		return
+	}
+	if channels = channelsAllowingUploader(channels, uploader); len(channels) == 0 {
+		slog.Debug("Skipping log, no channel allows its uploader", "steamid", steamid, "log_id", l.ID, "uploader", uploader)
+		return
+	}
 
-	_, err := fmt.Fprintf(b.conn, "PRIVMSG #"+channel+" :http://logs.tf/"+logID+"\r\n")
-	if err != nil {
-		return err
+	// an earlier log from the same check may have started the player's cooldown since this one was claimed
+	if !b.cooldowns.ready(steamid) {
+		slog.Info("Skipping log, a log was posted for the player too recently", "steamid", steamid, "log_id", l.ID, "cooldown", b.cooldowns.cooldown.String())
+		return
 	}
 
-	log.Printf("Sent log id=%v channel=%v\n", logID, channel)
-	return nil
+	// with LOGS_BOT_DEDUP_WINDOW set, a log goes to the channels of whichever player it's found for first
+	if !b.postedIDs.claim(l.ID) {
+		slog.Debug("Skipping log, it was already posted", "steamid", steamid, "log_id", l.ID)
+		return
+	}
+
+	if err := b.sendLogToChannels(ctx, steamid, l, channels); err != nil {
+		b.postedIDs.release(l.ID)
+
+		// release the claim so the log is retried on the next check, unless a newer log has been seen since.
+		// A player that had no last seen log goes back to having none rather than a zero time.
+		b.mutex.Lock()
+		if b.steamIDToLastLog[steamid] == seen {
+			if last.Time.IsZero() {
+				delete(b.steamIDToLastLog, steamid)
+			} else {
+				b.steamIDToLastLog[steamid] = last
+			}
+		}
+		b.mutex.Unlock()
+		return
+	}
+
+	b.cooldowns.record(steamid)
 }
 
-func getNewestLogForPlayer(steamid string) (*logResponse, error) {
-	res, err := http.Get("http://logs.tf/json_search?player=" + steamid + "&limit=1")
+// sendLogToChannels sends the log to every channel concurrently, and only returns an error if none of the
+// sends succeeded so that a log isn't re-posted to channels that already received it
+func (b *botConfig) sendLogToChannels(ctx context.Context, steamid string, l *logResponse, channels channelList) error {
+	errs := make(chan error, len(channels))
+	var wg sync.WaitGroup
+	for _, channel := range channels {
+		wg.Add(1)
+		go func(channel channelConfig) {
+			defer wg.Done()
+			if err := b.sendLogToChannel(ctx, steamid, l, channel); err != nil {
+				slog.Error("Failed to send log", "log_id", l.ID, "channel", channel.Name, "error", err)
+				errs <- err
+			}
+		}(channel)
+	}
+
+	wg.Wait()
+	close(errs)
 
-	if err != nil {
-		return nil, err
+	if len(errs) > 0 && len(errs) == len(channels) {
+		return <-errs
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	return nil
+}
 
-	if err != nil {
-		return nil, err
+func (b *botConfig) sendLogToChannel(ctx context.Context, steamid string, l *logResponse, channel channelConfig) error {
+	// another tracked player in the same match may have already posted this log to the channel
+	if !b.posted.claim(channel.Name, l.ID) {
+		slog.Debug("Skipping log, already posted", "log_id", l.ID, "channel", channel.Name)
+		return nil
+	}
+
+	// a poll that finds many new logs would otherwise have them all sent together once the delay is up
+	select {
+	case <-ctx.Done():
+		b.posted.release(channel.Name, l.ID)
+		return ctx.Err()
+	case b.postSlots <- struct{}{}:
 	}
+	defer func() { <-b.postSlots }()
 
-	type queryResponse struct {
-		Logs    []logResponse `json:"logs"`
-		Results int           `json:"results"`
-		Success bool          `json:"success"`
+	if err := b.deliverLog(ctx, steamid, l, channel); err != nil {
+		b.posted.release(channel.Name, l.ID)
+		return err
 	}
 
-	var q queryResponse
-	err = json.Unmarshal(body, &q)
+	return nil
+}
 
-	if err != nil {
-		return nil, err
+// deliverLog waits out the channel's spoiler delay and then posts the log
+func (b *botConfig) deliverLog(ctx context.Context, steamid string, l *logResponse, channel channelConfig) error {
+	// sleep to prevent spoilers due to stream delay, abandoning the send if we shut down in the meantime
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(channel.spoilerDelay(b.spoilerDelay)):
 	}
 
-	if q.Success == false || q.Results == 0 {
-		return nil, fmt.Errorf("Failed to get log for steamid=%v, response:\n%v\n", steamid, string(body))
+	// a channel listing its players holds the log until the rest of the poll cycle has had a chance to find it
+	if channel.ListPlayers {
+		b.waitForPlayersInLog(ctx, l, channel.Name)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 	}
 
-	return &(q.Logs[0]), nil
-}
+	// posts may have been turned off while waiting
+	if b.paused.isPaused(channel.Name) {
+		slog.Debug("Skipping log, posts are paused in the channel", "log_id", l.ID, "channel", channel.Name)
+		return nil
+	}
 
-func loadChannelsFromFile() (map[string]string, error) {
-	var channels map[string]string
-	b, err := ioutil.ReadFile(channelsFileName)
-	if err != nil {
-		return nil, err
+	wait, ok := b.pacer.reserve(channel.Name)
+	if !ok {
+		slog.Info("Dropping log, the channel was posted to too recently", "log_id", l.ID, "channel", channel.Name)
+		return nil
+	}
+	if wait > 0 {
+		slog.Debug("Queueing log, the channel was posted to too recently", "log_id", l.ID, "channel", channel.Name, "wait", wait.String())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
 	}
 
-	err = json.Unmarshal(b, &channels)
+	message, err := b.renderMessage(l, channel)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	// log what would have been posted without writing to the connection, the log is still treated as
+	// sent so the bot behaves the same as it would for real
+	if b.dryRun {
+		slog.Info("Dry run, not sending log", "log_id", l.ID, "channel", channel.Name, "message", message)
+		return nil
 	}
 
-	return channels, nil
+	// Twitch drops messages to channels the bot isn't in yet, so wait for the join to go through
+	if !b.joined.wait(ctx, channel.Name, joinConfirmTimeout) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errNotJoined
+	}
+
+	if err := b.send(ctx, "PRIVMSG #%s :%s", channel.Name, message); err != nil {
+		return err
+	}
+
+	slog.Info("Sent log", "log_id", l.ID, "channel", channel.Name)
+	// only mirror logs that made it to Twitch, a failed send is retried and would be mirrored twice
+	b.mirrorToDiscord(l, channel, message)
+	b.queueWebhookEvent(steamid, l, channel)
+	b.metrics.logPosted(channel.Name)
+	b.stats.recordLogPosted()
+	return nil
 }
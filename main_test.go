@@ -0,0 +1,964 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnectLogsIn(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+
+	dialTestBot(t, b, twitch)
+	twitch.expect(t, "CAP REQ :"+twitchCapabilities, "PASS oauth:secret", "NICK "+testUserName)
+}
+
+func TestReadMessagesAnswersPing(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch)
+	readInBackground(t, b)
+
+	twitch.write(t, "PING :tmi.twitch.tv")
+	twitch.expect(t, "PONG :tmi.twitch.tv")
+}
+
+func TestReadMessagesIgnoresCommandsInChat(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch)
+	done := readInBackground(t, b)
+
+	twitch.write(t, ":viewer!viewer@viewer.tmi.twitch.tv PRIVMSG #lansky :PING :tmi.twitch.tv")
+	twitch.write(t, ":viewer!viewer@viewer.tmi.twitch.tv PRIVMSG #lansky :RECONNECT")
+	twitch.write(t, "PING :flush")
+	if line := twitch.next(t); line != "PONG :flush" {
+		t.Errorf("Sent %q, want only the PONG to the server's PING", line)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("readMessages returned %v for a chat message saying RECONNECT", err)
+	default:
+	}
+}
+
+func TestCheckLogsForPlayerPostsNewLog(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	l := testLog(100, time.Minute)
+	logsTF.setLogs(testSteamID, l)
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	twitch.expect(t, "PRIVMSG #lansky :"+logsTF.URL+"/100 — serveme.tf #100 (cp_process_f12)")
+	if last := b.steamIDToLastLog[testSteamID]; last.ID != 100 || last.Time.Unix() != l.Date {
+		t.Errorf("Last seen log is %+v, want log 100", last)
+	}
+}
+
+func TestCheckLogsForPlayerCatchesUpOldestFirst(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{
+		messageTemplateEnvName: "{{.ID}}",
+		searchLimitEnvName:     "10",
+	})
+	connectTestBot(t, b, twitch, testChannel)
+
+	// 100 was posted before the outage, 99 is older than that and 98 is too old to post at all
+	seen := testLog(100, 30*time.Minute)
+	b.steamIDToLastLog[testSteamID] = lastLog{Time: logTime(&seen), ID: seen.ID}
+	logsTF.setLogs(testSteamID, testLog(103, time.Minute), testLog(102, 10*time.Minute), testLog(101, 20*time.Minute),
+		seen, testLog(99, 40*time.Minute), testLog(98, 2*time.Hour))
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	want := []string{"PRIVMSG #lansky :101", "PRIVMSG #lansky :102", "PRIVMSG #lansky :103"}
+	if got := privmsgs(twitch.flush(t, b)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q, want %q in the order they were played", got, want)
+	}
+	if last := b.steamIDToLastLog[testSteamID]; last.ID != 103 {
+		t.Errorf("Last seen log is %v, want the newest, 103", last.ID)
+	}
+}
+
+func TestNothingIsPostedDuringTheStartupDelay(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{
+		startupDelayEnvName:    "200ms",
+		messageTemplateEnvName: "{{.ID}}",
+	})
+	connectTestBot(t, b, twitch, testChannel)
+
+	// a log found while starting up is only marked as seen
+	played := testLog(100, 2*time.Minute)
+	logsTF.setLogs(testSteamID, played)
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+	if got := privmsgs(twitch.flush(t, b)); len(got) != 0 {
+		t.Errorf("Posted %q during the startup delay", got)
+	}
+	if last := b.steamIDToLastLog[testSteamID]; last.ID != 100 {
+		t.Errorf("Last seen log is %v, want 100", last.ID)
+	}
+
+	time.Sleep(time.Until(b.postingFrom))
+	logsTF.setLogs(testSteamID, testLog(101, time.Minute), played)
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+	if got, want := privmsgs(twitch.flush(t, b)), []string{"PRIVMSG #lansky :101"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q after the startup delay, want %q", got, want)
+	}
+}
+
+func TestCheckLogsForPlayerSkipsSeenAndStaleLogs(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID, otherSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+	channels := b.playerChannels()[testSteamID]
+
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.setLogs(otherSteamID, testLog(90, 2*time.Hour))
+	for i := 0; i < 2; i++ {
+		for _, steamid := range []string{testSteamID, otherSteamID} {
+			if err := b.checkLogsForPlayer(context.Background(), steamid, channels); err != nil {
+				t.Fatalf("checkLogsForPlayer failed: %v", err)
+			}
+		}
+		b.posts.Wait()
+	}
+
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 1 {
+		t.Errorf("Posted %q, want only the new log posted once", messages)
+	}
+	if _, ok := b.steamIDToLastLog[otherSteamID]; ok {
+		t.Error("The stale log was marked as seen")
+	}
+}
+
+func TestCheckLogsForPlayerReturnsLookupErrors(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	// a poll interval shorter than the retry delay means the lookup isn't retried
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{logRefreshTimeEnvName: "100ms"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.fail(1)
+	var statusErr *httpStatusError
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); !errors.As(err, &statusErr) {
+		t.Fatalf("checkLogsForPlayer returned %v, want the 500 from logs.tf", err)
+	}
+	b.posts.Wait()
+
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 0 {
+		t.Errorf("Posted %q after a failed lookup", messages)
+	}
+	if _, ok := b.steamIDToLastLog[testSteamID]; ok {
+		t.Error("A log was marked as seen after a failed lookup")
+	}
+}
+
+func TestSendLogToChannelPostsOnce(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	l := testLog(100, time.Minute)
+	for i := 0; i < 2; i++ {
+		if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: testChannel}); err != nil {
+			t.Fatalf("sendLogToChannel failed: %v", err)
+		}
+	}
+
+	want := "PRIVMSG #lansky :https://logs.tf/100 — serveme.tf #100 (cp_process_f12)"
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 1 || messages[0] != want {
+		t.Errorf("Posted %q, want just %q", messages, want)
+	}
+}
+
+func TestSendLogToChannelReleasesFailedSend(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	// the template only fails when it's rendered for a real log
+	var channel channelConfig
+	if err := json.Unmarshal([]byte(`{"channel": "lansky", "template": "{{if .ID}}{{index .Title 99}}{{end}}"}`), &channel); err != nil {
+		t.Fatalf("Failed to parse the channel: %v", err)
+	}
+	l := testLog(100, time.Minute)
+	if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channel); err == nil {
+		t.Fatal("sendLogToChannel succeeded, want the template's error")
+	}
+
+	if !b.posted.claim(testChannel, l.ID) {
+		t.Error("The log is still claimed for the channel after the send failed, so it won't be retried")
+	}
+}
+
+func TestJoinChannelsJoinsEveryChannel(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, map[string]channelList{
+		testSteamID:  {{Name: testChannel}, {Name: otherTestChannel}},
+		otherSteamID: {{Name: testChannel}},
+	}, nil)
+
+	// the old session's joins are gone after a reconnect, so each connection joins again
+	for i := 0; i < 2; i++ {
+		connectTestBot(t, b, twitch)
+		if err := b.joinChannels(context.Background()); err != nil {
+			t.Fatalf("joinChannels failed: %v", err)
+		}
+		if lines := twitch.flush(t, b); len(lines) != 2 || lines[0] != "JOIN #clockwork" || lines[1] != "JOIN #lansky" {
+			t.Errorf("Connection %d wrote %q, want JOIN #clockwork and JOIN #lansky", i+1, lines)
+		}
+	}
+}
+
+func TestConcurrentChecksPostOnce(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{spoilerDelayEnvName: "200ms"})
+	connectTestBot(t, b, twitch, testChannel)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+				t.Errorf("checkLogsForPlayer failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the spoiler delay is waited out without holding the lock, so other players can be checked meanwhile
+	locked := make(chan struct{})
+	go func() {
+		b.mutex.Lock()
+		b.mutex.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(100 * time.Millisecond):
+		t.Error("The lock is held during the spoiler delay")
+	}
+
+	b.posts.Wait()
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 1 {
+		t.Errorf("Posted %q, want the log posted once", messages)
+	}
+}
+
+func TestCheckLogsForPlayerPostsToEveryChannel(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel, otherTestChannel), nil)
+	connectTestBot(t, b, twitch, testChannel, otherTestChannel)
+
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	messages := privmsgs(twitch.flush(t, b))
+	sort.Strings(messages)
+	if len(messages) != 2 || !strings.HasPrefix(messages[0], "PRIVMSG #clockwork :") || !strings.HasPrefix(messages[1], "PRIVMSG #lansky :") {
+		t.Errorf("Posted %q, want the log posted to #clockwork and #lansky", messages)
+	}
+}
+
+func TestServeReturnsOnShutdown(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), nil)
+	b.dial = twitch.dial
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- b.Serve(ctx)
+	}()
+
+	twitch.nextMatching(t, "JOIN #lansky")
+	twitch.write(t, ":logsbot!logsbot@logsbot.tmi.twitch.tv JOIN #lansky")
+	waitFor(t, "the join to be confirmed", func() bool { return len(b.joined.names()) == 1 })
+
+	cancel()
+	select {
+	case err := <-served:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Serve returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve didn't return after the context was cancelled")
+	}
+
+	twitch.expect(t, "PART #lansky", "QUIT")
+}
+
+func TestServeDoesntWaitOnASleepingPoller(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{
+		spoilerDelayEnvName:   "1h",
+		logRefreshTimeEnvName: "1h",
+	})
+	b.dial = twitch.dial
+
+	// once the log is found the poller sleeps until its next check and the post waits out the spoiler
+	// delay, neither holds up reconnecting or shutting down
+	serve := func(ctx context.Context) <-chan error {
+		requests := len(logsTF.requests())
+		served := make(chan error, 1)
+		go func() {
+			served <- b.Serve(ctx)
+		}()
+
+		twitch.nextMatching(t, "JOIN #lansky")
+		twitch.write(t, ":logsbot!logsbot@logsbot.tmi.twitch.tv JOIN #lansky")
+		waitFor(t, "the log to be found", func() bool {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			return len(logsTF.requests()) > requests && b.steamIDToLastLog[testSteamID].ID == 100
+		})
+		return served
+	}
+
+	served := serve(context.Background())
+	twitch.write(t, ":tmi.twitch.tv RECONNECT")
+	select {
+	case err := <-served:
+		if !errors.Is(err, errReconnectRequested) {
+			t.Errorf("Serve returned %v, want %v", err, errReconnectRequested)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve didn't return after RECONNECT while the poller was sleeping")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served = serve(ctx)
+	cancel()
+	select {
+	case err := <-served:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Serve returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve didn't return after the context was cancelled while the poller was sleeping")
+	}
+}
+
+func TestOnceChecksEveryPlayerAndStops(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.setLogs(otherSteamID, testLog(101, 2*time.Minute))
+	state := filepath.Join(t.TempDir(), defaultStateFileName)
+	channels := players([]string{testSteamID, otherSteamID}, testChannel)
+
+	// what main does with -once, and what it saves on the way out
+	runOnce := func() []string {
+		b := newTestBot(t, logsTF, channels, map[string]string{stateFileEnvName: state, messageTemplateEnvName: "{{.ID}}"})
+		lines := serveOnce(t, b)
+		if err := b.saveState(); err != nil {
+			t.Fatalf("saveState failed: %v", err)
+		}
+		return lines
+	}
+
+	lines := runOnce()
+	sort.Strings(lines)
+	if want := []string{"PART #lansky", "PRIVMSG #lansky :100", "PRIVMSG #lansky :101"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("The first run wrote %q, want %q", lines, want)
+	}
+
+	// the next run picks up where the saved state left off, so it has nothing to post
+	if lines := runOnce(); !reflect.DeepEqual(lines, []string{"PART #lansky"}) {
+		t.Errorf("The second run wrote %q, want just PART #lansky", lines)
+	}
+}
+
+func TestOnceSendsWebhookEventsBeforeStopping(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.setLogs(otherSteamID, testLog(101, 2*time.Minute))
+	receiver := newFakeDiscord(t, http.StatusOK)
+	b := newTestBot(t, logsTF, players([]string{testSteamID, otherSteamID}, testChannel), map[string]string{webhookURLEnvName: receiver.URL})
+
+	serveOnce(t, b)
+	if posted := receiver.posted(); len(posted) != 2 {
+		t.Errorf("Sent %v to the webhook before stopping, want both logs", posted)
+	}
+}
+
+func TestOnceWaitsForLogsToBeMirroredToDiscord(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	discord := newFakeDiscord(t, http.StatusNoContent)
+	channels := map[string]channelList{testSteamID: {{Name: testChannel, DiscordWebhook: discord.URL}}}
+	b := newTestBot(t, logsTF, channels, nil)
+
+	serveOnce(t, b)
+	if posted := discord.posted(); len(posted) != 1 {
+		t.Errorf("Mirrored %v to Discord before stopping, want the log", posted)
+	}
+}
+
+// serveOnce runs Serve with -once the way main does, returning what was written to Twitch between joining
+// and quitting
+func serveOnce(t *testing.T, b *botConfig) []string {
+	t.Helper()
+
+	twitch := newFakeTwitch(t)
+	b.dial = twitch.dial
+	b.once = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.shutdown = cancel
+
+	served := make(chan error, 1)
+	go func() {
+		served <- b.Serve(ctx)
+	}()
+	twitch.nextMatching(t, "JOIN #lansky")
+	twitch.write(t, ":logsbot!logsbot@logsbot.tmi.twitch.tv JOIN #lansky")
+
+	var lines []string
+	for line := twitch.next(t); line != "QUIT"; line = twitch.next(t) {
+		lines = append(lines, line)
+	}
+	select {
+	case err := <-served:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Serve returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(harnessTimeout):
+		t.Fatal("Serve didn't return after checking every player")
+	}
+	return lines
+}
+
+// tlsListener listens for TLS connections on localhost with a self-signed certificate, sending the first
+// lines of each connection on lines
+func tlsListener(t *testing.T, lines chan<- string) net.Listener {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate a key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create a certificate: %v", err)
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					lines <- strings.TrimSuffix(s.Text(), "\r")
+				}
+			}()
+		}
+	}()
+
+	return l
+}
+
+func TestConnectOverTLS(t *testing.T) {
+	lines := make(chan string, 10)
+	l := tlsListener(t, lines)
+	b := newTestBot(t, nil, nil, map[string]string{ircAddrEnvName: l.Addr().String(), tlsSkipVerifyEnvName: "true"})
+
+	if err := b.connect(context.Background()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer b.conn.Close()
+	defer b.writer.stop()
+
+	for _, want := range []string{"CAP REQ :" + twitchCapabilities, "PASS oauth:secret", "NICK " + testUserName} {
+		select {
+		case got := <-lines:
+			if got != want {
+				t.Fatalf("Server read %q, want %q", got, want)
+			}
+		case <-time.After(harnessTimeout):
+			t.Fatalf("Timed out waiting for %q", want)
+		}
+	}
+}
+
+func TestConnectOverTLSVerifiesTheCertificate(t *testing.T) {
+	l := tlsListener(t, make(chan string, 10))
+	b := newTestBot(t, nil, nil, map[string]string{ircAddrEnvName: l.Addr().String()})
+
+	var certErr *tls.CertificateVerificationError
+	if err := b.connect(context.Background()); !errors.As(err, &certErr) {
+		t.Fatalf("connect returned %v, want the self-signed certificate rejected", err)
+	}
+}
+
+func TestReadMessagesReturnsAuthFailure(t *testing.T) {
+	for _, notice := range []string{
+		":tmi.twitch.tv NOTICE * :Login authentication failed",
+		":tmi.twitch.tv NOTICE * :Improperly formatted auth",
+	} {
+		twitch := newFakeTwitch(t)
+		b := newTestBot(t, nil, nil, nil)
+		connectTestBot(t, b, twitch)
+		done := readInBackground(t, b)
+
+		twitch.write(t, notice)
+		select {
+		case err := <-done:
+			if !errors.Is(err, errAuthFailed) {
+				t.Errorf("readMessages returned %v for %q, want %v", err, notice, errAuthFailed)
+			}
+		case <-time.After(harnessTimeout):
+			t.Fatalf("readMessages didn't return after %q", notice)
+		}
+	}
+}
+
+func TestReadMessagesIgnoresOtherNotices(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch)
+	done := readInBackground(t, b)
+
+	twitch.write(t, "@msg-id=msg_ratelimit :tmi.twitch.tv NOTICE #lansky :Your message was not sent because you are sending messages too quickly.")
+	twitch.write(t, "PING :tmi.twitch.tv")
+	twitch.expect(t, "PONG :tmi.twitch.tv")
+	select {
+	case err := <-done:
+		t.Fatalf("readMessages returned %v for a NOTICE that isn't an auth failure", err)
+	default:
+	}
+}
+
+func TestReadMessagesReturnsOnReconnect(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch)
+	done := readInBackground(t, b)
+
+	twitch.write(t, ":tmi.twitch.tv RECONNECT")
+	select {
+	case err := <-done:
+		if !errors.Is(err, errReconnectRequested) {
+			t.Errorf("readMessages returned %v, want %v", err, errReconnectRequested)
+		}
+	case <-time.After(harnessTimeout):
+		t.Fatal("readMessages didn't return after RECONNECT")
+	}
+}
+
+func TestServeReturnsOnReconnect(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), nil)
+	b.dial = twitch.dial
+
+	served := make(chan error, 1)
+	go func() {
+		served <- b.Serve(context.Background())
+	}()
+
+	twitch.nextMatching(t, "JOIN #lansky")
+	twitch.write(t, ":tmi.twitch.tv RECONNECT")
+	select {
+	case err := <-served:
+		if !errors.Is(err, errReconnectRequested) {
+			t.Errorf("Serve returned %v, want %v", err, errReconnectRequested)
+		}
+	case <-time.After(harnessTimeout):
+		t.Fatal("Serve didn't return after RECONNECT")
+	}
+}
+
+func TestSendLogToChannelsWaitsOutEachChannelsDelay(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{spoilerDelayEnvName: "300ms"})
+	connectTestBot(t, b, twitch, testChannel, otherTestChannel)
+
+	noDelay := duration(0)
+	channels := channelList{{Name: testChannel}, {Name: otherTestChannel, SpoilerDelay: &noDelay}}
+	l := testLog(100, time.Minute)
+	start := time.Now()
+	go b.sendLogToChannels(context.Background(), testSteamID, &l, channels)
+
+	if line := twitch.next(t); !strings.HasPrefix(line, "PRIVMSG #clockwork :") {
+		t.Fatalf("Bot wrote %q first, want the channel without a delay posted to", line)
+	}
+	if line := twitch.next(t); !strings.HasPrefix(line, "PRIVMSG #lansky :") {
+		t.Fatalf("Bot wrote %q, want the channel with the default delay posted to", line)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("The channel with the default delay was posted to after %v, want 300ms", elapsed)
+	}
+}
+
+func TestPostsWaitingOutTheSpoilerDelayAreLimited(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{
+		spoilerDelayEnvName:    "50ms",
+		maxPostsEnvName:        "3",
+		messageTemplateEnvName: "{{.ID}}",
+	})
+	connectTestBot(t, b, twitch, testChannel)
+
+	const logs = 12
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < logs; i++ {
+		wg.Add(1)
+		go func(l logResponse) {
+			defer wg.Done()
+			if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: testChannel}); err != nil {
+				t.Errorf("sendLogToChannel failed: %v", err)
+			}
+		}(testLog(100+i, time.Minute))
+	}
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		wg.Wait()
+	}()
+	most := 0
+	for waiting := true; waiting; {
+		select {
+		case <-sent:
+			waiting = false
+		case <-time.After(time.Millisecond):
+			if n := len(b.postSlots); n > most {
+				most = n
+			}
+		}
+	}
+
+	if most == 0 || most > 3 {
+		t.Errorf("%d posts were in flight at once, want at most 3", most)
+	}
+	// 12 posts 3 at a time is 4 spoiler delays
+	if elapsed := time.Since(start); elapsed < 4*50*time.Millisecond {
+		t.Errorf("Sending took %v, want the posts to take turns", elapsed)
+	}
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != logs {
+		t.Errorf("Posted %d logs, want all %d", len(messages), logs)
+	}
+}
+
+func TestMaxConcurrentPostsMustBePositive(t *testing.T) {
+	setCredentials(t)
+	t.Setenv(maxPostsEnvName, "0")
+
+	if _, err := newBotConfigFromEnv(); err == nil || !strings.Contains(err.Error(), maxPostsEnvName) {
+		t.Errorf("newBotConfigFromEnv returned %v, want an error naming %v", err, maxPostsEnvName)
+	}
+}
+
+func TestReadMessagesHandlesCapabilityAcks(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch)
+	done := readInBackground(t, b)
+
+	twitch.write(t, ":tmi.twitch.tv CAP * ACK :"+twitchCapabilities)
+	twitch.write(t, ":tmi.twitch.tv CAP * NAK :twitch.tv/unknown")
+	twitch.write(t, "PING :tmi.twitch.tv")
+	twitch.expect(t, "PONG :tmi.twitch.tv")
+
+	select {
+	case err := <-done:
+		t.Errorf("readMessages returned %v after the capability replies", err)
+	default:
+	}
+}
+
+func TestDryRunLogsInsteadOfPosting(t *testing.T) {
+	logs := captureLogs(t)
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{dryRunEnvName: "true"})
+	connectTestBot(t, b, twitch)
+
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 0 {
+		t.Errorf("Posted %q in a dry run", messages)
+	}
+	want := `msg="Dry run, not sending log" log_id=100 channel=lansky message="` + logsTF.URL + `/100 — serveme.tf #100 (cp_process_f12)"`
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("Logged %q, want it to include %q", logs, want)
+	}
+	if last := b.steamIDToLastLog[testSteamID]; last.ID != 100 {
+		t.Errorf("Last seen log is %+v, want log 100 as if it was posted", last)
+	}
+}
+
+func TestClaimLogComparesTimestampsAndIDs(t *testing.T) {
+	at := time.Now().Add(-time.Minute).Truncate(time.Second)
+	tests := []struct {
+		name string
+		last lastLog
+		log  logResponse
+		want bool
+	}{
+		{"newer", lastLog{Time: at, ID: 100}, logResponse{ID: 101, Date: at.Add(time.Second).Unix()}, true},
+		{"same log", lastLog{Time: at, ID: 100}, logResponse{ID: 100, Date: at.Unix()}, false},
+		{"same timestamp, newer id", lastLog{Time: at, ID: 100}, logResponse{ID: 101, Date: at.Unix()}, true},
+		{"same timestamp, older id", lastLog{Time: at, ID: 100}, logResponse{ID: 99, Date: at.Unix()}, false},
+		{"same timestamp, state without ids", lastLog{Time: at}, logResponse{ID: 101, Date: at.Unix()}, false},
+		{"older", lastLog{Time: at, ID: 100}, logResponse{ID: 101, Date: at.Add(-time.Second).Unix()}, false},
+		{"first", lastLog{}, logResponse{ID: 101, Date: at.Unix()}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestBot(t, nil, players([]string{testSteamID}, testChannel), nil)
+			if !test.last.Time.IsZero() {
+				b.steamIDToLastLog[testSteamID] = test.last
+			}
+
+			if _, ok := b.claimLog(testSteamID, &test.log, true, b.playerChannels()[testSteamID]); ok != test.want {
+				t.Errorf("claimLog claimed log %d: %v, want %v", test.log.ID, ok, test.want)
+			}
+
+			want := test.last
+			if test.want {
+				want = lastLog{Time: time.Unix(test.log.Date, 0), ID: test.log.ID}
+			}
+			if got := b.steamIDToLastLog[testSteamID]; got != want {
+				t.Errorf("Last seen log is %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestStaleThresholdAllowsForThePollInterval(t *testing.T) {
+	interval := duration(5 * time.Minute)
+	channels := map[string]channelList{testSteamID: {{Name: testChannel, PollInterval: &interval}}}
+	b := newTestBot(t, nil, channels, map[string]string{staleLogThresholdEnvName: "60s", httpTimeoutEnvName: "10s"})
+
+	// a log can be most of an interval old by the time the player's checked
+	recent := logResponse{ID: 100, Date: time.Now().Add(-4 * time.Minute).Unix()}
+	if _, ok := b.claimLog(testSteamID, &recent, true, b.playerChannels()[testSteamID]); !ok {
+		t.Error("Skipped a log within the player's poll interval as stale")
+	}
+
+	// 5m plus 10% jitter plus the 10s timeout
+	old := logResponse{ID: 101, Date: time.Now().Add(-6 * time.Minute).Unix()}
+	if _, ok := b.claimLog(testSteamID, &old, true, b.playerChannels()[testSteamID]); ok {
+		t.Error("Claimed a log older than the player's poll cycle")
+	}
+}
+
+func TestClaimLogWarnsWhenTheNewestLogGoesBackwards(t *testing.T) {
+	logs := captureLogs(t)
+	b := newTestBot(t, nil, players([]string{testSteamID}, testChannel), nil)
+	at := time.Now().Add(-time.Minute)
+	b.steamIDToLastLog[testSteamID] = lastLog{Time: at, ID: 100}
+
+	// older logs among the player's recent ones are expected, so only the newest going backwards is warned about
+	older := logResponse{ID: 99, Date: at.Add(-time.Minute).Unix()}
+	b.claimLog(testSteamID, &older, false, b.playerChannels()[testSteamID])
+	if strings.Contains(logs.String(), "older than the last one seen") {
+		t.Errorf("Logged %q for a log that isn't the newest", logs)
+	}
+
+	b.claimLog(testSteamID, &older, true, b.playerChannels()[testSteamID])
+	if want := `level=WARN msg="Newest log is older than the last one seen, skipping" steamid=` + testSteamID + ` log_id=99 last_log_id=100`; !strings.Contains(logs.String(), want) {
+		t.Errorf("Logged %q, want it to include %q", logs, want)
+	}
+}
+
+func TestOnlyAPlayersFirstLogIsInitial(t *testing.T) {
+	logs := captureLogs(t)
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), map[string]string{metricsEnabledEnvName: "true"})
+	connectTestBot(t, b, twitch, testChannel)
+
+	check := func(logs ...logResponse) {
+		t.Helper()
+		logsTF.setLogs(testSteamID, logs...)
+		if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+			t.Fatalf("checkLogsForPlayer failed: %v", err)
+		}
+		b.posts.Wait()
+	}
+	check(testLog(100, 2*time.Minute))
+	check(testLog(101, time.Minute), testLog(100, 2*time.Minute))
+
+	for _, want := range []string{
+		`msg="New log for player" steamid=` + testSteamID + ` log_id=100 initial=true`,
+		`msg="New log for player" steamid=` + testSteamID + ` log_id=101 initial=false`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("Logged %q, want it to include %q", logs, want)
+		}
+	}
+	if n := strings.Count(logs.String(), "initial=true"); n != 1 {
+		t.Errorf("Logged %d initial logs, want 1", n)
+	}
+
+	w := httptest.NewRecorder()
+	b.metrics.(http.Handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{`logsbot_new_logs_total{kind="initial"} 1`, `logsbot_new_logs_total{kind="subsequent"} 1`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("/metrics is missing %q:\n%s", want, w.Body)
+		}
+	}
+}
+
+func TestReadMessagesAnswersPingsRightAway(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch)
+	readInBackground(t, b)
+
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		twitch.write(t, "PING :tmi.twitch.tv")
+		twitch.expect(t, "PONG :tmi.twitch.tv")
+		if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+			t.Errorf("PING was answered after %v, want right away", elapsed)
+		}
+	}
+}
+
+func TestReadMessagesTimesOutOnADeadConnection(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{readTimeoutEnvName: "200ms"})
+	connectTestBot(t, b, twitch)
+
+	start := time.Now()
+	done := readInBackground(t, b)
+
+	// halfway through the timeout the bot checks whether the connection is still there
+	twitch.expect(t, "PING :tmi.twitch.tv")
+	select {
+	case err := <-done:
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("readMessages returned %v, want a timeout", err)
+		}
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+			t.Errorf("readMessages gave up after %v, want about the 200ms read timeout", elapsed)
+		}
+	case <-time.After(harnessTimeout):
+		t.Fatal("readMessages didn't notice the connection was dead")
+	}
+}
+
+func TestReadMessagesStaysUpWhileTwitchIsTalking(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{readTimeoutEnvName: "200ms"})
+	connectTestBot(t, b, twitch)
+	done := readInBackground(t, b)
+
+	// each line, including the reply to the bot's own PING, resets the deadline
+	for i := 0; i < 8; i++ {
+		time.Sleep(50 * time.Millisecond)
+		twitch.write(t, ":tmi.twitch.tv PONG tmi.twitch.tv :tmi.twitch.tv")
+	}
+
+	select {
+	case err := <-done:
+		t.Errorf("readMessages returned %v while Twitch kept sending lines", err)
+	default:
+	}
+}
+
+func TestShutdownPartsEveryJoinedChannel(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, newFakeLogsTF(t), players([]string{testSteamID}, testChannel, otherTestChannel, "notjoined"), nil)
+	b.dial = twitch.dial
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- b.Serve(ctx)
+	}()
+
+	twitch.nextMatching(t, "JOIN ")
+	for _, channel := range []string{testChannel, otherTestChannel} {
+		twitch.write(t, ":logsbot!logsbot@logsbot.tmi.twitch.tv JOIN #"+channel)
+	}
+	waitFor(t, "the joins to be confirmed", func() bool { return len(b.joined.names()) == 2 })
+	twitch.flush(t, b)
+
+	cancel()
+	select {
+	case <-served:
+	case <-time.After(harnessTimeout):
+		t.Fatal("Serve didn't return after the context was cancelled")
+	}
+
+	// the channel whose join never went through isn't parted
+	twitch.expect(t, "PART #clockwork", "PART #lansky", "QUIT")
+}
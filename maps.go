@@ -0,0 +1,47 @@
+package main
+
+import "strings"
+
+// mapMatches reports whether the map matches any of the patterns, a pattern matches maps starting with it
+// so "koth_" matches every koth map and "cp_process_f12" matches just that map
+func mapMatches(mapName string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasPrefix(mapName, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsMap reports whether the channel wants logs on the map. A channel's own allowed_maps and denied_maps
+// replace LOGS_BOT_ALLOWED_MAPS and LOGS_BOT_DENIED_MAPS, a denied map is never posted and if there's an
+// allowlist only maps on it are.
+func (b *botConfig) allowsMap(channel channelConfig, mapName string) bool {
+	allowed, denied := b.allowedMaps, b.deniedMaps
+	if channel.AllowedMaps != nil {
+		allowed = channel.AllowedMaps
+	}
+	if channel.DeniedMaps != nil {
+		denied = channel.DeniedMaps
+	}
+
+	mapName = strings.ToLower(mapName)
+	if mapMatches(mapName, denied) {
+		return false
+	}
+
+	return len(allowed) == 0 || mapMatches(mapName, allowed)
+}
+
+// channelsAllowingMap returns the channels that want logs on the map
+func (b *botConfig) channelsAllowingMap(channels channelList, mapName string) channelList {
+	var allowed channelList
+	for _, channel := range channels {
+		if b.allowsMap(channel, mapName) {
+			allowed = append(allowed, channel)
+		}
+	}
+
+	return allowed
+}
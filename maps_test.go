@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestAllowsMap(t *testing.T) {
+	deny := newTestBot(t, nil, nil, map[string]string{deniedMapsEnvName: "jump_, surf_,tr_walkway_rc2"})
+	allow := newTestBot(t, nil, nil, map[string]string{allowedMapsEnvName: "cp_,KOTH_", deniedMapsEnvName: "cp_orange"})
+
+	tests := []struct {
+		b     *botConfig
+		maps  map[string]bool
+		which string
+	}{
+		{deny, map[string]bool{"cp_process_f12": true, "jump_beef": false, "SURF_UTOPIA": false, "tr_walkway_rc2": false, "tr_walkway": true}, "denylist"},
+		{allow, map[string]bool{"cp_process_f12": true, "koth_product_final": true, "cp_orange_x3": false, "pl_upward": false, "": false}, "allowlist"},
+	}
+	for _, test := range tests {
+		for mapName, want := range test.maps {
+			if got := test.b.allowsMap(channelConfig{Name: testChannel}, mapName); got != want {
+				t.Errorf("With the %v, allowsMap(%q) = %v, want %v", test.which, mapName, got, want)
+			}
+		}
+	}
+}
+
+func TestChannelMapListsReplaceTheGlobalOnes(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{allowedMapsEnvName: "koth_", deniedMapsEnvName: "cp_"})
+	channel := channelConfig{Name: testChannel, AllowedMaps: []string{"cp_"}, DeniedMaps: []string{}}
+
+	if !b.allowsMap(channel, "cp_process_f12") {
+		t.Error("A map on the channel's allowlist was denied by the global denylist")
+	}
+	if b.allowsMap(channel, "koth_product_final") {
+		t.Error("A map on the global allowlist was allowed despite the channel's allowlist")
+	}
+}
+
+func TestOnlyLogsOnAllowedMapsArePosted(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	channels, err := parseChannels([]byte(`{"76561198107240606": ["lansky", {"channel": "clockwork", "allowed_maps": ["koth_"]}]}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+	b := newTestBot(t, logsTF, channels, map[string]string{deniedMapsEnvName: "jump_", messageTemplateEnvName: "{{.Map}}"})
+	connectTestBot(t, b, twitch, testChannel, otherTestChannel)
+
+	var logs []logResponse
+	for i, mapName := range []string{"jump_beef", "koth_product_final", "cp_process_f12"} {
+		l := testLog(100+i, time.Duration(3-i)*time.Minute)
+		l.Map = mapName
+		logs = append([]logResponse{l}, logs...)
+	}
+	logsTF.setLogs(testSteamID, logs...)
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	posted := privmsgs(twitch.flush(t, b))
+	sort.Strings(posted)
+	want := []string{"PRIVMSG #clockwork :koth_product_final", "PRIVMSG #lansky :cp_process_f12", "PRIVMSG #lansky :koth_product_final"}
+	if !reflect.DeepEqual(posted, want) {
+		t.Errorf("Posted %q, want %q", posted, want)
+	}
+}
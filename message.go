@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	_ "time/tzdata" // so LOGS_BOT_TIMEZONE works on systems without a timezone database
+	"unicode/utf8"
+)
+
+// defaultMessageTemplate is posted for a log when neither the channel nor LOGS_BOT_MESSAGE_TEMPLATE sets a template
+const defaultMessageTemplate = "{{.URL}}{{if .Title}} — {{.Title}}{{end}}{{if .Map}} ({{.Map}}){{end}}{{if .Score}} ({{.Score}}){{end}}{{if .Match}} — {{.League}}: {{.Match}}{{end}}{{if .TrackedPlayers}} — with {{.TrackedPlayers}}{{end}}"
+
+// defaultMaxMessageLength is the longest message Twitch accepts, longer messages are dropped
+const defaultMaxMessageLength = 500
+
+// defaultTimeFormat is how a log's time is written in messages, as a Go time layout
+const defaultTimeFormat = "15:04 MST"
+
+// ellipsis marks where a message was cut short
+const ellipsis = "…"
+
+// messageData is what message templates are rendered with, e.g. "New log! {{.URL}} {{.Title}}"
+type messageData struct {
+	ID      int
+	URL     string
+	Title   string
+	Map     string
+	Players int
+	Views   int
+
+	// Date is when the log was uploaded in LOGS_BOT_TIMEZONE, and Time is it written with LOGS_BOT_TIME_FORMAT
+	// like "18:42 CET"
+	Date time.Time
+	Time string
+
+	// Score is the result like "RED 5 - 3 BLU", empty if the log's score couldn't be fetched, and RedScore and
+	// BlueScore are the team scores on their own
+	Score     string
+	RedScore  int
+	BlueScore int
+
+	// League and Match are the official league match the log was played for, empty if it wasn't one or
+	// LOGS_BOT_LEAGUE_API_URL isn't set
+	League string
+	Match  string
+
+	// TrackedPlayers lists the channel's tracked players who were in the log, like "lansky, clockwork", if the
+	// channel sets list_players
+	TrackedPlayers string
+}
+
+// parseMessageTemplate parses the template and renders it once with placeholder data, so that a template
+// referring to fields that don't exist fails at startup rather than when a log is posted
+func parseMessageTemplate(text string) (*template.Template, error) {
+	t, err := template.New("message").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.Execute(ioutil.Discard, messageData{}); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// renderMessage renders the channel's template for the log, or the global template if the channel doesn't
+// have one
+func (b *botConfig) renderMessage(l *logResponse, channel channelConfig) (string, error) {
+	t := b.messageTemplate
+	if channel.template != nil {
+		t = channel.template
+	}
+
+	data := messageData{
+		ID:      l.ID,
+		URL:     b.logURL(l.ID),
+		Title:   l.Title,
+		Map:     l.Map,
+		Players: l.Players,
+		Views:   l.Views,
+		Date:    logTime(l).In(b.timeLocation),
+	}
+	data.Time = data.Date.Format(b.timeFormat)
+	if l.score != nil {
+		data.Score = l.score.String()
+		data.RedScore, data.BlueScore = l.score.Red, l.score.Blue
+	}
+	if l.match != nil {
+		data.League, data.Match = l.match.League, l.match.Match
+	}
+	if channel.ListPlayers {
+		data.TrackedPlayers = b.trackedPlayersInLog(l, channel.Name)
+	}
+
+	// the spaces around the template's output are collapsed below, so an empty prefix or suffix leaves none
+	var buf bytes.Buffer
+	buf.WriteString(channel.Prefix + " ")
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	buf.WriteString(" " + channel.Suffix)
+
+	// a message has to fit on a single IRC line, and within Twitch's length limit without losing the link
+	message := strings.Join(strings.Fields(buf.String()), " ")
+	return truncateMessage(message, b.maxMessageLength, data.URL), nil
+}
+
+// truncateMessage shortens the message to at most limit bytes of UTF-8, marking the cut with an ellipsis.
+// If keep is in the message it's never cut, the text around it is shortened instead.
+func truncateMessage(message string, limit int, keep string) string {
+	if len(message) <= limit {
+		return message
+	}
+
+	i := strings.Index(message, keep)
+	if keep == "" || i < 0 {
+		return cutString(message, limit-len(ellipsis)) + ellipsis
+	}
+
+	// the cut falls after what we're keeping, so just drop the end
+	if i+len(keep) <= limit-len(ellipsis) {
+		return cutString(message, limit-len(ellipsis)) + ellipsis
+	}
+
+	// otherwise shorten the text before it and drop whatever comes after
+	if before := cutString(message[:i], limit-len(keep)-len(ellipsis)-1); before != "" {
+		return before + ellipsis + " " + keep
+	}
+
+	return keep
+}
+
+// cutString returns the longest prefix of s that's at most n bytes without splitting a character, and
+// without trailing spaces
+func cutString(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) > n {
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		s = s[:n]
+	}
+
+	return strings.TrimRight(s, " ")
+}
+
+// logLinkIDPlaceholder is replaced with the log's id in LOGS_BOT_LOG_LINK_FORMAT
+const logLinkIDPlaceholder = "<id>"
+
+// logURL returns the link to the log on logs.tf, or in the link format if one is set
+func (b *botConfig) logURL(id int) string {
+	if b.logLinkFormat != "" {
+		return strings.ReplaceAll(b.logLinkFormat, logLinkIDPlaceholder, strconv.Itoa(id))
+	}
+
+	return b.logsTFBaseURL + "/" + strconv.Itoa(id)
+}
+
+// logTime returns when the log was uploaded
+func logTime(l *logResponse) time.Time {
+	return time.Unix(l.Date, 0)
+}
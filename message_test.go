@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestRenderMessageTemplateFields(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{
+		logsTFBaseURLEnvName:   "https://logs.tf",
+		messageTemplateEnvName: "New log! {{.ID}} {{.URL}} {{.Title}} {{.Date.Format \"2006-01-02\"}}",
+	})
+
+	l := &logResponse{ID: 123, Title: "RGL: lansky vs clockwork", Date: time.Date(2023, 4, 1, 18, 42, 0, 0, time.UTC).Unix()}
+	got, err := b.renderMessage(l, channelConfig{Name: testChannel})
+	if err != nil {
+		t.Fatalf("renderMessage failed: %v", err)
+	}
+
+	if want := "New log! 123 https://logs.tf/123 RGL: lansky vs clockwork 2023-04-01"; got != want {
+		t.Errorf("renderMessage = %q, want %q", got, want)
+	}
+}
+
+func TestRenderMessagePrefersTheChannelsTemplate(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{
+		logsTFBaseURLEnvName:   "https://logs.tf",
+		messageTemplateEnvName: "global {{.URL}}",
+	})
+
+	channels, err := parseChannels([]byte(`{"76561198107240606": ["lansky", {"channel": "clockwork", "template": "vs team X {{.URL}}"}]}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+
+	want := []string{"global https://logs.tf/123", "vs team X https://logs.tf/123"}
+	for i, channel := range channels[testSteamID] {
+		if got, err := b.renderMessage(&logResponse{ID: 123}, channel); err != nil || got != want[i] {
+			t.Errorf("renderMessage for %v = %q, %v, want %q", channel.Name, got, err, want[i])
+		}
+	}
+}
+
+func TestInvalidTemplatesFailAtStartup(t *testing.T) {
+	for _, text := range []string{"{{.URL", "{{.Nope}}", "{{template \"missing\"}}"} {
+		setCredentials(t)
+		t.Setenv(messageTemplateEnvName, text)
+		if _, err := newBotConfigFromEnv(); err == nil || !strings.Contains(err.Error(), messageTemplateEnvName) {
+			t.Errorf("newBotConfigFromEnv with template %q returned %v, want an error naming %v", text, err, messageTemplateEnvName)
+		}
+
+		if _, err := parseChannels([]byte(`{"76561198107240606": {"channel": "lansky", "template": "` + strings.ReplaceAll(text, `"`, `\"`) + `"}}`)); err == nil {
+			t.Errorf("parseChannels accepted the template %q", text)
+		}
+	}
+}
+
+func TestDefaultMessageHasTheTitleAndMap(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, logResponse{ID: 123, Date: time.Now().Unix(), Title: "serveme.tf #123", Map: "cp_process_f12", Players: 12, Views: 40})
+	b := newTestBot(t, logsTF, nil, nil)
+
+	l, err := b.getNewestLogForPlayer(context.Background(), testSteamID)
+	if err != nil {
+		t.Fatalf("getNewestLogForPlayer failed: %v", err)
+	}
+	if l.Map != "cp_process_f12" || l.Players != 12 || l.Views != 40 {
+		t.Errorf("Parsed map %q, %d players and %d views, want cp_process_f12, 12 and 40", l.Map, l.Players, l.Views)
+	}
+
+	if got, want := mustRender(t, b, l), logsTF.URL+"/123 — serveme.tf #123 (cp_process_f12)"; got != want {
+		t.Errorf("Default message is %q, want %q", got, want)
+	}
+}
+
+func TestDefaultMessageLeavesOutMissingFields(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: "https://logs.tf"})
+
+	if got, want := mustRender(t, b, &logResponse{ID: 123}), "https://logs.tf/123"; got != want {
+		t.Errorf("Default message for a log without a title or map is %q, want %q", got, want)
+	}
+}
+
+func TestURLOnlyMessage(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: "https://logs.tf", messageTemplateEnvName: "{{.URL}}"})
+
+	if got, want := mustRender(t, b, &logResponse{ID: 123, Title: "serveme.tf #123", Map: "cp_process_f12"}), "https://logs.tf/123"; got != want {
+		t.Errorf("Message is %q, want just the link %q", got, want)
+	}
+}
+
+// mustRender renders the log's message for testChannel with the channel's default options
+func mustRender(t *testing.T, b *botConfig, l *logResponse) string {
+	t.Helper()
+
+	message, err := b.renderMessage(l, channelConfig{Name: testChannel})
+	if err != nil {
+		t.Fatalf("renderMessage failed: %v", err)
+	}
+
+	return message
+}
+
+func TestOverlongMessagesKeepTheURL(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: "https://logs.tf", maxMessageLengthEnvName: "60"})
+
+	l := &logResponse{ID: 123, Title: strings.Repeat("ÜberCharge ", 20), Map: "cp_process_f12"}
+	got := mustRender(t, b, l)
+	if len(got) > 60 || !utf8.ValidString(got) {
+		t.Errorf("Rendered %q, %d bytes, want valid UTF-8 within 60 bytes", got, len(got))
+	}
+	if !strings.HasPrefix(got, "https://logs.tf/123 — ÜberCharge") || !strings.HasSuffix(got, ellipsis) {
+		t.Errorf("Rendered %q, want the link and the start of the title, cut short", got)
+	}
+
+	// the link is kept even when the text before it is what makes the message too long
+	channel := channelConfig{Name: testChannel, Prefix: strings.Repeat("@lansky ", 20)}
+	got, err := b.renderMessage(&logResponse{ID: 123}, channel)
+	if err != nil {
+		t.Fatalf("renderMessage failed: %v", err)
+	}
+	if len(got) > 60 || !strings.HasSuffix(got, ellipsis+" https://logs.tf/123") {
+		t.Errorf("Rendered %q, %d bytes, want the prefix shortened to keep the link within 60 bytes", got, len(got))
+	}
+}
+
+func TestTruncateMessage(t *testing.T) {
+	const url = "https://logs.tf/123"
+	tests := []struct {
+		message string
+		limit   int
+		want    string
+	}{
+		{"short " + url, 100, "short " + url},
+		{url + " a long title", 25, url + " a…"},
+		{"a long prefix " + url, 25, "a…" + " " + url},
+		{"a long prefix " + url + " and suffix", 25, "a…" + " " + url},
+		{"way too long " + url, 10, url},
+		{"ééééé", 7, "éé" + ellipsis},
+	}
+	for _, test := range tests {
+		keep := url
+		if !strings.Contains(test.message, url) {
+			keep = ""
+		}
+		if got := truncateMessage(test.message, test.limit, keep); got != test.want {
+			t.Errorf("truncateMessage(%q, %d) = %q, want %q", test.message, test.limit, got, test.want)
+		}
+	}
+}
+
+func TestCustomLogLinkFormat(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{
+		logsTFBaseURLEnvName: "https://logs.tf",
+		logLinkFormatEnvName: "https://tf2.example.com/l/<id>?from=<id>",
+	})
+
+	l := &logResponse{ID: 123, Title: "serveme.tf #123", Map: "cp_process_f12"}
+	if got, want := mustRender(t, b, l), "https://tf2.example.com/l/123?from=123 — serveme.tf #123 (cp_process_f12)"; got != want {
+		t.Errorf("Rendered %q, want %q", got, want)
+	}
+
+	// without a format the logs.tf link is posted
+	b = newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: "https://logs.tf", logLinkFormatEnvName: ""})
+	if got, want := b.logURL(123), "https://logs.tf/123"; got != want {
+		t.Errorf("logURL(123) = %q, want %q", got, want)
+	}
+}
+
+func TestLogLinkFormatNeedsTheIDPlaceholder(t *testing.T) {
+	setCredentials(t)
+	t.Setenv(logLinkFormatEnvName, "https://tf2.example.com/l/")
+
+	if _, err := newBotConfigFromEnv(); err == nil || !strings.Contains(err.Error(), logLinkIDPlaceholder) {
+		t.Errorf("newBotConfigFromEnv returned %v, want an error about the missing %v", err, logLinkIDPlaceholder)
+	}
+}
+
+func TestRenderLogTimeInTimezone(t *testing.T) {
+	// 18:42 UTC on a summer day, when Berlin is on CEST and New York on EDT
+	l := &logResponse{ID: 123, Date: time.Date(2023, 7, 1, 18, 42, 0, 0, time.UTC).Unix()}
+	tests := []struct {
+		timezone, format, want string
+	}{
+		{"UTC", "", "18:42 UTC"},
+		{"Europe/Berlin", "", "20:42 CEST"},
+		{"America/New_York", "", "14:42 EDT"},
+		{"Europe/Berlin", "Jan 2 15:04", "Jul 1 20:42"},
+	}
+	for _, test := range tests {
+		env := map[string]string{timezoneEnvName: test.timezone, messageTemplateEnvName: "{{.Time}}"}
+		if test.format != "" {
+			env[timeFormatEnvName] = test.format
+		}
+		b := newTestBot(t, nil, nil, env)
+
+		if got := mustRender(t, b, l); got != test.want {
+			t.Errorf("Rendered %q in %v, want %q", got, test.timezone, test.want)
+		}
+	}
+}
+
+func TestInvalidTimezoneFailsAtStartup(t *testing.T) {
+	setCredentials(t)
+	t.Setenv(timezoneEnvName, "Mars/Olympus_Mons")
+
+	if _, err := newBotConfigFromEnv(); err == nil || !strings.Contains(err.Error(), timezoneEnvName) {
+		t.Errorf("newBotConfigFromEnv returned %v, want an error naming %v", err, timezoneEnvName)
+	}
+}
+
+func TestChannelPrefixAndSuffix(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: "https://logs.tf"})
+	channels, err := parseChannels([]byte(`{"76561198107240606": [
+		{"channel": "lansky", "prefix": "@everyone"},
+		{"channel": "clockwork", "suffix": "GG!"},
+		{"channel": "teamchannel", "prefix": "New log:", "suffix": "(scrim)"}
+	]}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+
+	l := &logResponse{ID: 123, Title: "serveme.tf #123", Map: "cp_process_f12"}
+	want := []string{
+		"@everyone https://logs.tf/123 — serveme.tf #123 (cp_process_f12)",
+		"https://logs.tf/123 — serveme.tf #123 (cp_process_f12) GG!",
+		"New log: https://logs.tf/123 — serveme.tf #123 (cp_process_f12) (scrim)",
+	}
+	for i, channel := range channels[testSteamID] {
+		if got, err := b.renderMessage(l, channel); err != nil || got != want[i] {
+			t.Errorf("renderMessage for %v = %q, %v, want %q", channel.Name, got, err, want[i])
+		}
+	}
+
+	// the prefix and suffix are kept when the channel is saved and loaded again
+	data, err := json.Marshal(channels)
+	if err != nil {
+		t.Fatalf("Failed to marshal the channels: %v", err)
+	}
+	if reloaded, err := parseChannels(data); err != nil || !reflect.DeepEqual(reloaded, channels) {
+		t.Errorf("Parsing %s returned %v, %v, want %v", data, reloaded, err, channels)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metrics receives instrumentation events from the bot. noopMetrics is used when metrics are disabled.
+type metrics interface {
+	logPosted(channel string)
+	newLog(initial bool)
+	pollError()
+	setTrackedPlayers(n int)
+	setCircuitState(state string)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) logPosted(channel string)     {}
+func (noopMetrics) newLog(initial bool)          {}
+func (noopMetrics) pollError()                   {}
+func (noopMetrics) setTrackedPlayers(n int)      {}
+func (noopMetrics) setCircuitState(state string) {}
+
+// promMetrics keeps counters in memory and serves them in the Prometheus text exposition format
+type promMetrics struct {
+	mutex          sync.Mutex
+	logsPosted     map[string]uint64 // by channel
+	initialLogs    uint64            // new logs for players we'd never seen a log for
+	laterLogs      uint64            // new logs for players we had
+	pollErrors     uint64
+	trackedPlayers int
+	circuitState   string // of the logs.tf circuit breaker
+}
+
+func newPromMetrics() *promMetrics {
+	return &promMetrics{logsPosted: map[string]uint64{}, circuitState: circuitClosed}
+}
+
+func (m *promMetrics) logPosted(channel string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.logsPosted[channel]++
+}
+
+func (m *promMetrics) newLog(initial bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if initial {
+		m.initialLogs++
+	} else {
+		m.laterLogs++
+	}
+}
+
+func (m *promMetrics) pollError() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.pollErrors++
+}
+
+func (m *promMetrics) setTrackedPlayers(n int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.trackedPlayers = n
+}
+
+func (m *promMetrics) setCircuitState(state string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.circuitState = state
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (m *promMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP logsbot_logs_posted_total Logs posted to Twitch channels.")
+	fmt.Fprintln(w, "# TYPE logsbot_logs_posted_total counter")
+	channels := make([]string, 0, len(m.logsPosted))
+	for channel := range m.logsPosted {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		fmt.Fprintf(w, "logsbot_logs_posted_total{channel=\"%s\"} %d\n", labelValueEscaper.Replace(channel), m.logsPosted[channel])
+	}
+
+	fmt.Fprintln(w, "# HELP logsbot_new_logs_total New logs detected for tracked players, initial is a player's first.")
+	fmt.Fprintln(w, "# TYPE logsbot_new_logs_total counter")
+	fmt.Fprintf(w, "logsbot_new_logs_total{kind=\"initial\"} %d\n", m.initialLogs)
+	fmt.Fprintf(w, "logsbot_new_logs_total{kind=\"subsequent\"} %d\n", m.laterLogs)
+
+	fmt.Fprintln(w, "# HELP logsbot_poll_errors_total Failed logs.tf polls.")
+	fmt.Fprintln(w, "# TYPE logsbot_poll_errors_total counter")
+	fmt.Fprintf(w, "logsbot_poll_errors_total %d\n", m.pollErrors)
+
+	fmt.Fprintln(w, "# HELP logsbot_tracked_players Players whose logs are being tracked.")
+	fmt.Fprintln(w, "# TYPE logsbot_tracked_players gauge")
+	fmt.Fprintf(w, "logsbot_tracked_players %d\n", m.trackedPlayers)
+
+	fmt.Fprintln(w, "# HELP logsbot_logstf_circuit_state The state of the logs.tf circuit breaker, 1 for the current state.")
+	fmt.Fprintln(w, "# TYPE logsbot_logstf_circuit_state gauge")
+	for _, state := range circuitStates {
+		current := 0
+		if state == m.circuitState {
+			current = 1
+		}
+		fmt.Fprintf(w, "logsbot_logstf_circuit_state{state=\"%s\"} %d\n", state, current)
+	}
+}
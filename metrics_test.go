@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsCountPostsAndPollErrors(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel, otherTestChannel), map[string]string{
+		metricsEnabledEnvName: "true",
+		logRefreshTimeEnvName: "100ms",
+	})
+	connectTestBot(t, b, twitch, testChannel, otherTestChannel)
+	b.metrics.setTrackedPlayers(len(b.playerChannels()))
+
+	for _, id := range []int{100, 101} {
+		l := testLog(id, time.Minute)
+		if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: testChannel}); err != nil {
+			t.Fatalf("sendLogToChannel failed: %v", err)
+		}
+	}
+	l := testLog(100, time.Minute)
+	if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: otherTestChannel}); err != nil {
+		t.Fatalf("sendLogToChannel failed: %v", err)
+	}
+	logsTF.fail(1)
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err == nil {
+		t.Fatal("checkLogsForPlayer succeeded, want the 500 from logs.tf")
+	}
+
+	w := httptest.NewRecorder()
+	b.metrics.(http.Handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`logsbot_logs_posted_total{channel="clockwork"} 1`,
+		`logsbot_logs_posted_total{channel="lansky"} 2`,
+		`logsbot_poll_errors_total 1`,
+		`logsbot_tracked_players 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want+"\n") {
+			t.Errorf("/metrics is missing %v:\n%v", want, w.Body.String())
+		}
+	}
+}
+
+func TestMetricsCanBeDisabled(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{metricsEnabledEnvName: "false"})
+
+	if _, ok := b.metrics.(http.Handler); ok {
+		t.Error("Metrics are served with " + metricsEnabledEnvName + "=false")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// mirrorDownTime is how long a logs.tf mirror that failed a request is tried after the healthy ones
+const mirrorDownTime = 30 * time.Second
+
+// logsTFMirror is a logs.tf compatible API the bot can poll
+type logsTFMirror struct {
+	url       string // without a trailing slash
+	failures  int    // requests failed in a row
+	lastError string
+	downUntil time.Time // when the mirror is considered healthy again after failing
+}
+
+// mirrorPool spreads requests across the logs.tf base URL and any mirrors, taking turns between the healthy
+// ones and failing over to the next when a request fails. A mirror that failed is only tried once the
+// healthy ones have been, until mirrorDownTime has passed.
+type mirrorPool struct {
+	mutex   *sync.Mutex
+	mirrors []*logsTFMirror
+	next    int // which mirror the next request starts at
+}
+
+func newMirrorPool(urls []string) *mirrorPool {
+	p := &mirrorPool{mutex: &sync.Mutex{}}
+	for _, url := range urls {
+		p.mirrors = append(p.mirrors, &logsTFMirror{url: url})
+	}
+
+	return p
+}
+
+// order returns the mirrors to try a request with, healthy ones first starting from the next one's turn
+func (p *mirrorPool) order() []*logsTFMirror {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	var healthy, down []*logsTFMirror
+	for i := range p.mirrors {
+		m := p.mirrors[(p.next+i)%len(p.mirrors)]
+		if now.Before(m.downUntil) {
+			down = append(down, m)
+		} else {
+			healthy = append(healthy, m)
+		}
+	}
+	p.next = (p.next + 1) % len(p.mirrors)
+
+	return append(healthy, down...)
+}
+
+// record updates the mirror's health with the result of a request to it
+func (p *mirrorPool) record(m *logsTFMirror, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err == nil {
+		m.failures = 0
+		m.downUntil = time.Time{}
+		return
+	}
+
+	m.failures++
+	m.lastError = err.Error()
+	m.downUntil = time.Now().Add(mirrorDownTime)
+}
+
+// mirrorStatus is a mirror's health as served by /status
+type mirrorStatus struct {
+	URL       string `json:"url"`
+	Healthy   bool   `json:"healthy"`
+	Failures  int    `json:"failures"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// snapshot returns the health of every mirror
+func (p *mirrorPool) snapshot() []mirrorStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	statuses := make([]mirrorStatus, 0, len(p.mirrors))
+	for _, m := range p.mirrors {
+		statuses = append(statuses, mirrorStatus{URL: m.url, Healthy: !now.Before(m.downUntil), Failures: m.failures, LastError: m.lastError})
+	}
+
+	return statuses
+}
+
+// getFromMirrors fetches the path (e.g. "/json/123") from one of the logs.tf mirrors, trying the others in
+// turn if it fails. Only the last mirror tried has its request retried, failing over is quicker than waiting
+// for a struggling mirror.
+func (b *botConfig) getFromMirrors(ctx context.Context, path string) ([]byte, error) {
+	mirrors := b.mirrors.order()
+
+	var err error
+	for i, m := range mirrors {
+		var body []byte
+		if i < len(mirrors)-1 {
+			body, err = b.get(ctx, m.url+path)
+		} else {
+			body, err = b.getWithRetries(ctx, m.url+path)
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		// logs.tf responding that the request is bad isn't the mirror's fault
+		if statusErr, ok := err.(*httpStatusError); ok && !statusErr.temporary() {
+			return nil, err
+		}
+
+		b.mirrors.record(m, err)
+		if err == nil {
+			return body, nil
+		}
+
+		if i < len(mirrors)-1 {
+			slog.Warn("Failed to reach logs.tf mirror, trying the next one", "url", m.url, "error", err)
+		}
+	}
+
+	return nil, err
+}
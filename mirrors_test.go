@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRequestsAreSpreadAcrossMirrors(t *testing.T) {
+	logsTF, mirror := newFakeLogsTF(t), newFakeLogsTF(t)
+	for _, f := range []*fakeLogsTF{logsTF, mirror} {
+		f.setLogs(testSteamID, testLog(100, 0))
+	}
+	b := newTestBot(t, logsTF, nil, map[string]string{logsTFMirrorsEnvName: mirror.URL + "/"})
+
+	for i := 0; i < 4; i++ {
+		if l, err := b.getNewestLogForPlayer(context.Background(), testSteamID); err != nil || l.ID != 100 {
+			t.Fatalf("getNewestLogForPlayer returned %v, %v, want log 100", l, err)
+		}
+	}
+
+	if a, b := len(logsTF.requests()), len(mirror.requests()); a != 2 || b != 2 {
+		t.Errorf("logs.tf got %d requests and the mirror %d, want them to take turns", a, b)
+	}
+	// links point at logs.tf whichever mirror found the log
+	if got, want := b.logURL(100), logsTF.URL+"/100"; got != want {
+		t.Errorf("logURL(100) = %q, want %q", got, want)
+	}
+}
+
+func TestRequestsFailOverToAnotherMirror(t *testing.T) {
+	logsTF, mirror := newFakeLogsTF(t), newFakeLogsTF(t)
+	logsTF.fail(100)
+	mirror.setLogs(testSteamID, testLog(100, 0))
+	b := newTestBot(t, logsTF, nil, map[string]string{logsTFMirrorsEnvName: mirror.URL})
+
+	for i := 0; i < 4; i++ {
+		if l, err := b.getNewestLogForPlayer(context.Background(), testSteamID); err != nil || l.ID != 100 {
+			t.Fatalf("getNewestLogForPlayer returned %v, %v, want log 100 from the mirror", l, err)
+		}
+	}
+
+	// once it's failed, logs.tf is only tried after the healthy mirror
+	if n := len(logsTF.requests()); n != 1 {
+		t.Errorf("logs.tf got %d requests, want 1 before it was marked down", n)
+	}
+	if n := len(mirror.requests()); n != 4 {
+		t.Errorf("The mirror got %d requests, want all 4", n)
+	}
+
+	statuses := b.mirrors.snapshot()
+	if len(statuses) != 2 {
+		t.Fatalf("Got the health of %d mirrors, want 2", len(statuses))
+	}
+	if s := statuses[0]; s.URL != logsTF.URL || s.Healthy || s.Failures != 1 || s.LastError == "" {
+		t.Errorf("logs.tf's health is %+v, want it down after 1 failure", s)
+	}
+	if s := statuses[1]; s.URL != mirror.URL || !s.Healthy || s.Failures != 0 {
+		t.Errorf("The mirror's health is %+v, want it healthy", s)
+	}
+}
+
+func TestMirrorsMustBeHTTPURLs(t *testing.T) {
+	setCredentials(t)
+	t.Setenv(logsTFMirrorsEnvName, "https://mirror.example.com, mirror.example.com")
+
+	if _, err := newBotConfigFromEnv(); err == nil {
+		t.Errorf("newBotConfigFromEnv accepted a mirror without a scheme")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	postPolicyQueue = "queue" // wait until the channel's interval has passed
+	postPolicyDrop  = "drop"  // skip the log
+)
+
+// channelPacer keeps posts to a channel at least interval apart, so a channel tracking several players
+// doesn't get a burst of links at once
+type channelPacer struct {
+	mutex    *sync.Mutex
+	interval time.Duration // zero disables pacing
+	drop     bool          // drop posts that come too soon instead of queueing them
+	lastPost map[string]time.Time
+}
+
+func newChannelPacer(interval time.Duration, policy string) *channelPacer {
+	return &channelPacer{
+		mutex:    &sync.Mutex{},
+		interval: interval,
+		drop:     policy == postPolicyDrop,
+		lastPost: map[string]time.Time{},
+	}
+}
+
+// reserve books the channel's next post, returning how long to wait before posting, or false if the post
+// should be dropped
+func (p *channelPacer) reserve(channel string) (time.Duration, bool) {
+	if p.interval <= 0 {
+		return 0, true
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	next := p.lastPost[channel].Add(p.interval)
+	if !next.After(now) {
+		p.lastPost[channel] = now
+		return 0, true
+	}
+
+	if p.drop {
+		return 0, false
+	}
+
+	// queued posts each take the next free slot
+	p.lastPost[channel] = next
+	return next.Sub(now), true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sendLogsAtOnce sends the logs to the channel at the same time, waiting for every send to finish
+func sendLogsAtOnce(t *testing.T, b *botConfig, channel string, ids ...int) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			l := testLog(id, time.Minute)
+			if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: channel}); err != nil {
+				t.Errorf("sendLogToChannel failed: %v", err)
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+func TestPostsToAChannelAreSpacedOut(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{postIntervalEnvName: "200ms"})
+	connectTestBot(t, b, twitch, testChannel, otherTestChannel)
+
+	go sendLogsAtOnce(t, b, testChannel, 100, 101)
+	twitch.nextMatching(t, "PRIVMSG #lansky ")
+	first := time.Now()
+
+	// other channels have their own interval
+	sendLogsAtOnce(t, b, otherTestChannel, 100)
+	if line := twitch.next(t); time.Since(first) > 100*time.Millisecond {
+		t.Errorf("%q waited on another channel's interval", line)
+	}
+
+	twitch.nextMatching(t, "PRIVMSG #lansky ")
+	if gap := time.Since(first); gap < 180*time.Millisecond {
+		t.Errorf("The second post to the channel came %v after the first, want at least the 200ms interval", gap)
+	}
+}
+
+func TestPostsThatComeTooSoonCanBeDropped(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{postIntervalEnvName: "1h", postPolicyEnvName: postPolicyDrop})
+	connectTestBot(t, b, twitch, testChannel)
+
+	sendLogsAtOnce(t, b, testChannel, 100, 101, 102)
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 1 {
+		t.Errorf("Posted %q, want the posts after the first dropped", messages)
+	}
+}
+
+func TestChannelPacer(t *testing.T) {
+	p := newChannelPacer(time.Minute, postPolicyQueue)
+	if wait, ok := p.reserve(testChannel); !ok || wait != 0 {
+		t.Errorf("The first post waits %v, %v, want it right away", wait, ok)
+	}
+
+	// each queued post takes the next free slot
+	for i := 1; i <= 2; i++ {
+		wait, ok := p.reserve(testChannel)
+		if want := time.Duration(i) * time.Minute; !ok || wait < want-time.Second || wait > want {
+			t.Errorf("Post %d waits %v, %v, want about %v", i+1, wait, ok, want)
+		}
+	}
+
+	if wait, ok := newChannelPacer(0, postPolicyDrop).reserve(testChannel); !ok || wait != 0 {
+		t.Errorf("A post waits %v, %v without pacing, want it right away", wait, ok)
+	}
+}
+
+func TestChannelPostPolicyMustBeKnown(t *testing.T) {
+	setCredentials(t)
+	t.Setenv(postPolicyEnvName, "shuffle")
+	if _, err := newBotConfigFromEnv(); err == nil {
+		t.Errorf("newBotConfigFromEnv accepted a %v of shuffle", postPolicyEnvName)
+	}
+}
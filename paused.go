@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+)
+
+const defaultPausedChannelsFileName = "paused_channels.json"
+
+// pausedChannels are the channels a moderator has turned log posts off for with !logs off. New logs are
+// still recorded as seen for their players while a channel is paused, so turning posts back on doesn't
+// flood the channel with everything it missed.
+type pausedChannels struct {
+	mutex    *sync.Mutex
+	path     string // where the paused channels are saved, so they stay paused across restarts
+	channels map[string]bool
+}
+
+// loadPausedChannels reads the paused channels from the file, a missing or unreadable file just means no
+// channels are paused
+func loadPausedChannels(path string) *pausedChannels {
+	p := &pausedChannels{mutex: &sync.Mutex{}, path: path, channels: map[string]bool{}}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p
+	} else if err != nil {
+		slog.Warn("Failed to read paused channels, starting with none paused", "path", path, "error", err)
+		return p
+	}
+
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		slog.Warn("Failed to parse paused channels, starting with none paused", "path", path, "error", err)
+		return p
+	}
+
+	for _, name := range names {
+		p.channels[name] = true
+	}
+
+	return p
+}
+
+func (p *pausedChannels) isPaused(channel string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.channels[channel]
+}
+
+// set pauses or resumes posts to the channel and saves the change, the change is undone if it couldn't be
+// saved so a restart doesn't silently flip it back
+func (p *pausedChannels) set(channel string, paused bool) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.channels[channel] == paused {
+		return nil
+	}
+
+	p.channels[channel] = paused
+	if err := p.save(); err != nil {
+		p.channels[channel] = !paused
+		return err
+	}
+
+	return nil
+}
+
+func (p *pausedChannels) save() error {
+	names := make([]string, 0, len(p.channels))
+	for name, paused := range p.channels {
+		if paused {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	data, err := json.MarshalIndent(names, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(p.path, data)
+}
+
+// channelsNotPaused returns the channels that logs are currently posted to
+func (b *botConfig) channelsNotPaused(channels channelList) channelList {
+	var active channelList
+	for _, channel := range channels {
+		if !b.paused.isPaused(channel.Name) {
+			active = append(active, channel)
+		}
+	}
+
+	return active
+}
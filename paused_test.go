@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogsOffStopsPostsWithoutABacklog(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+	mod := chatMessage{user: "mod", channel: testChannel, badges: map[string]string{"moderator": "1"}}
+
+	check := func(logs ...logResponse) []string {
+		t.Helper()
+		logsTF.setLogs(testSteamID, logs...)
+		if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+			t.Fatalf("checkLogsForPlayer failed: %v", err)
+		}
+		b.posts.Wait()
+		return privmsgs(twitch.flush(t, b))
+	}
+
+	mod.text = "!logs off"
+	b.handleChatMessage(context.Background(), mod)
+	if got, want := twitch.flush(t, b), []string{"PRIVMSG #lansky :Log posts are now off in this channel"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("!logs off replied %q, want %q", got, want)
+	}
+
+	if messages := check(testLog(101, time.Minute), testLog(100, 2*time.Minute)); len(messages) != 0 {
+		t.Errorf("Posted %q while posts were off", messages)
+	}
+	if last := b.steamIDToLastLog[testSteamID]; last.ID != 101 {
+		t.Errorf("Last seen log is %+v while posts are off, want log 101 still tracked", last)
+	}
+
+	mod.text = "!logs on"
+	b.handleChatMessage(context.Background(), mod)
+	twitch.flush(t, b)
+
+	// only logs uploaded after posts were turned back on are posted
+	messages := check(testLog(102, 0), testLog(101, time.Minute), testLog(100, 2*time.Minute))
+	if len(messages) != 1 || messages[0] != "PRIVMSG #lansky :"+logsTF.URL+"/102 — serveme.tf #102 (cp_process_f12)" {
+		t.Errorf("Posted %q after posts were turned back on, want just the new log", messages)
+	}
+}
+
+func TestLogsCommandIsForModerators(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, players([]string{testSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	b.handleChatMessage(context.Background(), chatMessage{user: "viewer", channel: testChannel, text: "!logs off"})
+	if got := twitch.flush(t, b); len(got) != 0 || b.paused.isPaused(testChannel) {
+		t.Errorf("A viewer turned posts off, replying %q", got)
+	}
+
+	tests := map[string]string{
+		"!logs":       "Log posts are on in this channel, use !logs off to turn them off",
+		"!logs maybe": "Usage: !logs on|off",
+	}
+	for text, want := range tests {
+		b.handleChatMessage(context.Background(), chatMessage{user: testChannel, channel: testChannel, text: text})
+		if got := twitch.flush(t, b); !reflect.DeepEqual(got, []string{"PRIVMSG #lansky :" + want}) {
+			t.Errorf("%q replied %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestPausedChannelsSurviveARestart(t *testing.T) {
+	b := newTestBot(t, nil, nil, nil)
+	if err := b.paused.set(testChannel, true); err != nil {
+		t.Fatalf("Failed to pause the channel: %v", err)
+	}
+	if err := b.paused.set(otherTestChannel, true); err != nil {
+		t.Fatalf("Failed to pause the channel: %v", err)
+	}
+	if err := b.paused.set(otherTestChannel, false); err != nil {
+		t.Fatalf("Failed to resume the channel: %v", err)
+	}
+
+	restarted := loadPausedChannels(b.pausedChannelsFile)
+	if !restarted.isPaused(testChannel) || restarted.isPaused(otherTestChannel) {
+		t.Errorf("After a restart %v paused: %v and %v paused: %v, want only %v paused", testChannel,
+			restarted.isPaused(testChannel), otherTestChannel, restarted.isPaused(otherTestChannel), testChannel)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pollErrorLogInterval is how often a player that keeps failing to poll is logged, errors in between
+// are counted and reported with the next one
+const pollErrorLogInterval = 5 * time.Minute
+
+// pollJitter is how much the time between a player's checks is randomly varied by, as a fraction of their
+// interval. The jitter is symmetric so the average is still the interval.
+const pollJitter = 0.1
+
+// a player whose steamid logs.tf rejects invalidSteamIDThreshold checks in a row is only checked every
+// invalidSteamIDPollInterval, since the id won't start working on its own. Reloading the config checks them
+// at their usual interval again.
+const (
+	invalidSteamIDThreshold    = 3
+	invalidSteamIDPollInterval = 1 * time.Hour
+)
+
+// pollJob is a single check of a player's newest log
+type pollJob struct {
+	steamid  string
+	channels channelList
+}
+
+// pollLogs checks each player on their own schedule, every logRefreshTime unless one of their channels sets
+// a poll_interval, and runs the checks on a pool of pollWorkers workers so at most that many requests hit
+// logs.tf at once. The players' first checks are spread across their interval rather than all happening
+// at startup, so logs.tf sees a steady trickle of requests instead of a burst. It returns once the context
+// is done and the workers have finished their current checks.
+func (b *botConfig) pollLogs(ctx context.Context) {
+	jobs := make(chan pollJob, b.pollWorkers)
+
+	var wg sync.WaitGroup
+	b.startPollWorkers(ctx, jobs, &wg)
+
+	defer wg.Wait()
+	defer close(jobs)
+
+	s := newPollSchedule()
+	for {
+		// the config can be reloaded at any time, so take the current mapping each time round
+		players := b.playerChannels()
+		now := time.Now()
+		s.update(players, now, b.logRefreshTime)
+
+		for _, steamid := range s.due(now) {
+			channels := players[steamid]
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- pollJob{steamid: steamid, channels: channels}:
+			}
+			interval := channels.pollInterval(b.logRefreshTime)
+			if b.invalidIDs.backedOff(steamid) {
+				interval = invalidSteamIDPollInterval
+			}
+			s.reschedule(steamid, interval)
+		}
+
+		// wake up at least every logRefreshTime so players added by a reload don't wait on a long interval
+		wake := time.Now().Add(b.logRefreshTime)
+		if next, ok := s.next(); ok && next.Before(wake) {
+			wake = next
+		}
+		if !sleepUntil(ctx, wake) {
+			return
+		}
+	}
+}
+
+// pollOnce checks every player once on the pool of workers, and waits for any new logs to be posted and
+// sent to the webhook
+func (b *botConfig) pollOnce(ctx context.Context) {
+	players := b.playerChannels()
+	steamids := make([]string, 0, len(players))
+	for steamid := range players {
+		steamids = append(steamids, steamid)
+	}
+	sort.Strings(steamids)
+
+	jobs := make(chan pollJob, b.pollWorkers)
+	var wg sync.WaitGroup
+	b.startPollWorkers(ctx, jobs, &wg)
+
+	for _, steamid := range steamids {
+		select {
+		case <-ctx.Done():
+		case jobs <- pollJob{steamid: steamid, channels: players[steamid]}:
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+	b.posts.Wait()
+	if b.webhookURL != "" {
+		b.sendQueuedWebhookEvents(ctx)
+	}
+	slog.Info("Checked every player", "players", len(steamids))
+}
+
+// startPollWorkers starts pollWorkers workers checking the players sent on jobs, until jobs is closed
+func (b *botConfig) startPollWorkers(ctx context.Context, jobs <-chan pollJob, wg *sync.WaitGroup) {
+	for i := 0; i < b.pollWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				err := b.checkLogsForPlayer(ctx, job.steamid, job.channels)
+				if ctx.Err() != nil {
+					// the check was cut short by shutdown or a reconnect, that's not a failure
+					continue
+				}
+				b.pollErrors.report(job, err)
+				b.invalidIDs.record(job.steamid, err)
+				b.stats.recordPoll(err != nil)
+			}
+		}()
+	}
+}
+
+// pollSchedule tracks when each player is next due to be checked
+type pollSchedule struct {
+	nextCheck map[string]time.Time
+	started   bool
+}
+
+func newPollSchedule() *pollSchedule {
+	return &pollSchedule{nextCheck: map[string]time.Time{}}
+}
+
+// update schedules players that were added to the config and forgets ones that were removed. The players
+// there at the start are spread across their interval, players added later are checked right away.
+func (s *pollSchedule) update(players map[string]channelList, now time.Time, def time.Duration) {
+	for steamid := range s.nextCheck {
+		if _, ok := players[steamid]; !ok {
+			delete(s.nextCheck, steamid)
+		}
+	}
+
+	// sort so the initial spread doesn't depend on map order
+	var added []string
+	for steamid := range players {
+		if _, ok := s.nextCheck[steamid]; !ok {
+			added = append(added, steamid)
+		}
+	}
+	sort.Strings(added)
+
+	for i, steamid := range added {
+		next := now
+		if !s.started {
+			interval := players[steamid].pollInterval(def)
+			next = now.Add(interval * time.Duration(i) / time.Duration(len(added)))
+		}
+		s.nextCheck[steamid] = next
+	}
+	s.started = true
+}
+
+// due returns the players whose next check is at or before now, most overdue first
+func (s *pollSchedule) due(now time.Time) []string {
+	var due []string
+	for steamid, next := range s.nextCheck {
+		if !next.After(now) {
+			due = append(due, steamid)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return s.nextCheck[due[i]].Before(s.nextCheck[due[j]]) })
+
+	return due
+}
+
+// reschedule sets the player's next check an interval after the one that was due, varied by pollJitter.
+// A player that's fallen more than an interval behind, e.g. because the workers were busy, is scheduled
+// from now instead so it doesn't get checked several times in a row to catch up.
+func (s *pollSchedule) reschedule(steamid string, interval time.Duration) {
+	next := s.nextCheck[steamid].Add(jitter(interval, pollJitter))
+	if now := time.Now(); next.Before(now) {
+		next = now.Add(jitter(interval, pollJitter))
+	}
+	s.nextCheck[steamid] = next
+}
+
+// next returns the earliest next check, false if there are no players
+func (s *pollSchedule) next() (time.Time, bool) {
+	var earliest time.Time
+	for _, next := range s.nextCheck {
+		if earliest.IsZero() || next.Before(earliest) {
+			earliest = next
+		}
+	}
+
+	return earliest, !earliest.IsZero()
+}
+
+// pollCycle returns how long a player checked every interval can go between checks, allowing for the
+// jitter and the request to check them
+func (b *botConfig) pollCycle(interval time.Duration) time.Duration {
+	return interval + time.Duration(pollJitter*float64(interval)) + b.httpTimeout
+}
+
+// jitter returns d randomly varied by up to fraction of d in either direction
+func jitter(d time.Duration, fraction float64) time.Duration {
+	return d + time.Duration((rand.Float64()*2-1)*fraction*float64(d))
+}
+
+// sleepUntil waits until t, returning false if the context is done first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// pollErrorLog logs failed player checks, but at most once per pollErrorLogInterval for each player so a
+// player that keeps failing doesn't flood the output
+type pollErrorLog struct {
+	mutex      *sync.Mutex
+	lastLogged map[string]time.Time
+	suppressed map[string]int
+}
+
+func newPollErrorLog() *pollErrorLog {
+	return &pollErrorLog{
+		mutex:      &sync.Mutex{},
+		lastLogged: map[string]time.Time{},
+		suppressed: map[string]int{},
+	}
+}
+
+// report logs the result of a check, err is nil if it succeeded
+func (p *pollErrorLog) report(job pollJob, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err == nil {
+		if _, failing := p.lastLogged[job.steamid]; failing {
+			slog.Info("Polling player recovered", "steamid", job.steamid)
+			delete(p.lastLogged, job.steamid)
+			delete(p.suppressed, job.steamid)
+		}
+		return
+	}
+
+	if last, ok := p.lastLogged[job.steamid]; ok && time.Since(last) < pollErrorLogInterval {
+		p.suppressed[job.steamid]++
+		return
+	}
+
+	slog.Warn("Failed to poll player", "steamid", job.steamid, "channels", strings.Join(job.channels.names(), ","),
+		"error", err, "suppressed", p.suppressed[job.steamid])
+	p.lastLogged[job.steamid] = time.Now()
+	p.suppressed[job.steamid] = 0
+}
+
+// invalidSteamIDs counts how many checks in a row each player's steamid was rejected by logs.tf, so players
+// with an id that will never work can be checked less often instead of wasting a request every cycle
+type invalidSteamIDs struct {
+	mutex    *sync.Mutex
+	failures map[string]int
+}
+
+func newInvalidSteamIDs() *invalidSteamIDs {
+	return &invalidSteamIDs{mutex: &sync.Mutex{}, failures: map[string]int{}}
+}
+
+// record counts the check if logs.tf rejected the steamid, err is nil if it succeeded. Other errors, like
+// logs.tf being down, say nothing about the id so they're ignored.
+func (i *invalidSteamIDs) record(steamid string, err error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	var invalidErr *invalidSteamIDError
+	switch {
+	case err == nil:
+		delete(i.failures, steamid)
+	case errors.As(err, &invalidErr):
+		i.failures[steamid]++
+		if i.failures[steamid] == invalidSteamIDThreshold {
+			slog.Warn("Steamid keeps being rejected by logs.tf, checking it less often until the config is reloaded",
+				"steamid", steamid, "error", err, "interval", invalidSteamIDPollInterval.String())
+		}
+	}
+}
+
+// backedOff reports whether the player has been rejected too many times in a row to check at their usual interval
+func (i *invalidSteamIDs) backedOff(steamid string) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.failures[steamid] >= invalidSteamIDThreshold
+}
+
+// reset forgets every player's failures, for when the config is reloaded and the ids may have been fixed
+func (i *invalidSteamIDs) reset() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.failures = map[string]int{}
+}
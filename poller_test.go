@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPollOnceLimitsConcurrentRequests(t *testing.T) {
+	var mutex sync.Mutex
+	inFlight, most, requests := 0, 0, 0
+	logsTF := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		requests++
+		if inFlight > most {
+			most = inFlight
+		}
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"success": true, "results": 0, "logs": []}`))
+
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+	}))
+	defer logsTF.Close()
+
+	var steamids []string
+	for i := 0; i < 12; i++ {
+		steamids = append(steamids, strconv.Itoa(steamID64Base+1000+i))
+	}
+	b := newTestBot(t, nil, players(steamids, testChannel), map[string]string{
+		logsTFBaseURLEnvName: logsTF.URL,
+		pollWorkersEnvName:   "3",
+	})
+
+	b.pollOnce(context.Background())
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if requests != len(steamids) {
+		t.Errorf("logs.tf got %d requests, want one for each of the %d players", requests, len(steamids))
+	}
+	if most > 3 {
+		t.Errorf("logs.tf got %d requests at once, want at most %d", most, b.pollWorkers)
+	}
+}
+
+func TestPollLogsReturnsOnceTheWorkersStop(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID, otherSteamID}, testChannel), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		b.pollLogs(ctx)
+	}()
+
+	waitFor(t, "the first check", func() bool { return len(logsTF.requests()) > 0 })
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(harnessTimeout):
+		t.Fatal("pollLogs didn't return after the context was cancelled")
+	}
+}
+
+func TestPollScheduleSpreadsTheFirstChecks(t *testing.T) {
+	var steamids []string
+	for i := 0; i < 4; i++ {
+		steamids = append(steamids, strconv.Itoa(steamID64Base+1000+i))
+	}
+
+	now := time.Now()
+	s := newPollSchedule()
+	s.update(players(steamids, testChannel), now, 8*time.Second)
+	for i, steamid := range steamids {
+		if want := now.Add(time.Duration(i) * 2 * time.Second); !s.nextCheck[steamid].Equal(want) {
+			t.Errorf("%v is first checked %v in, want %v", steamid, s.nextCheck[steamid].Sub(now), want.Sub(now))
+		}
+	}
+
+	// players added later don't wait for a slot
+	added := strconv.Itoa(steamID64Base + 2000)
+	s.update(players(append(steamids, added), testChannel), now, 8*time.Second)
+	if !s.nextCheck[added].Equal(now) {
+		t.Errorf("A player added later is first checked %v in, want right away", s.nextCheck[added].Sub(now))
+	}
+}
+
+func TestJitterAveragesToTheInterval(t *testing.T) {
+	const interval, runs = 10 * time.Second, 10000
+
+	var total time.Duration
+	for i := 0; i < runs; i++ {
+		d := jitter(interval, pollJitter)
+		if d < 9*time.Second || d > 11*time.Second {
+			t.Fatalf("jitter(%v, %v) = %v, want within 10%%", interval, pollJitter, d)
+		}
+		total += d
+	}
+
+	if average := total / runs; average < 9900*time.Millisecond || average > 10100*time.Millisecond {
+		t.Errorf("jitter averaged %v, want about %v", average, interval)
+	}
+}
+
+func TestPollLogsStaggersTheChecks(t *testing.T) {
+	var mutex sync.Mutex
+	var times []time.Time
+	logsTF := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		times = append(times, time.Now())
+		mutex.Unlock()
+		w.Write([]byte(`{"success": true, "results": 0, "logs": []}`))
+	}))
+	defer logsTF.Close()
+
+	var steamids []string
+	for i := 0; i < 4; i++ {
+		steamids = append(steamids, strconv.Itoa(steamID64Base+1000+i))
+	}
+	b := newTestBot(t, nil, players(steamids, testChannel), map[string]string{
+		logsTFBaseURLEnvName:  logsTF.URL,
+		logRefreshTimeEnvName: "400ms",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		b.pollLogs(ctx)
+	}()
+	waitFor(t, "every player to be checked", func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(times) >= len(steamids)
+	})
+	cancel()
+	<-stopped
+
+	// the first checks are 100ms apart, a burst would land them all within a few milliseconds
+	mutex.Lock()
+	defer mutex.Unlock()
+	if spread := times[len(steamids)-1].Sub(times[0]); spread < 250*time.Millisecond {
+		t.Errorf("The first checks of %d players were %v apart, want them spread across the 400ms interval", len(steamids), spread)
+	}
+}
+
+func TestPollErrorsAreReported(t *testing.T) {
+	logs := captureLogs(t)
+	logsTF := newFakeLogsTF(t)
+	logsTF.errors[testSteamID] = "Invalid steamid"
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel, otherTestChannel), nil)
+
+	b.pollOnce(context.Background())
+
+	want := `msg="Failed to poll player" steamid=` + testSteamID + ` channels=lansky,clockwork error="invalid steamid=` + testSteamID + `: logs.tf responded Invalid steamid" suppressed=0`
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("Logged %q, want it to include %q", logs, want)
+	}
+}
+
+func TestPollErrorLogIsRateLimited(t *testing.T) {
+	logs := captureLogs(t)
+	p := newPollErrorLog()
+	job := pollJob{steamid: testSteamID, channels: players([]string{testSteamID}, testChannel)[testSteamID]}
+
+	for i := 0; i < 5; i++ {
+		p.report(job, errors.New("logs.tf is down"))
+	}
+	if n := strings.Count(logs.String(), "Failed to poll player"); n != 1 {
+		t.Errorf("Logged %d failures in a row, want 1 with the rest suppressed", n)
+	}
+	if p.suppressed[testSteamID] != 4 {
+		t.Errorf("Suppressed %d failures, want 4", p.suppressed[testSteamID])
+	}
+
+	// once the interval has passed the next failure is logged with the count of the ones in between
+	p.lastLogged[testSteamID] = time.Now().Add(-pollErrorLogInterval)
+	p.report(job, errors.New("logs.tf is down"))
+	if !strings.Contains(logs.String(), "suppressed=4") {
+		t.Errorf("Logged %q, want the 4 suppressed failures reported", logs)
+	}
+
+	p.report(job, nil)
+	if !strings.Contains(logs.String(), `msg="Polling player recovered" steamid=`+testSteamID) {
+		t.Errorf("Logged %q, want the recovery logged", logs)
+	}
+	p.report(job, errors.New("logs.tf is down again"))
+	if n := strings.Count(logs.String(), "Failed to poll player"); n != 3 {
+		t.Errorf("Logged %d failures, want a failure after recovering logged straight away", n)
+	}
+}
+
+func TestPlayersArePolledAtTheirOwnInterval(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	fast, slow := duration(50*time.Millisecond), duration(750*time.Millisecond)
+	b := newTestBot(t, logsTF, map[string]channelList{
+		testSteamID:  {{Name: testChannel, PollInterval: &fast}},
+		otherSteamID: {{Name: otherTestChannel, PollInterval: &slow}},
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		b.pollLogs(ctx)
+	}()
+	time.Sleep(600 * time.Millisecond)
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(harnessTimeout):
+		t.Fatal("pollLogs didn't return after the context was cancelled")
+	}
+
+	checks := map[string]int{}
+	for _, path := range logsTF.requests() {
+		for _, steamid := range []string{testSteamID, otherSteamID} {
+			if strings.Contains(path, steamid) {
+				checks[steamid]++
+			}
+		}
+	}
+	// 600ms is about 12 checks at 50ms, and only the first check at 750ms
+	if checks[testSteamID] < 5 || checks[otherSteamID] != 1 {
+		t.Errorf("Checked the 50ms player %d times and the 750ms player %d times, want many and one", checks[testSteamID], checks[otherSteamID])
+	}
+}
+
+func TestChannelListPollInterval(t *testing.T) {
+	short, long := duration(2*time.Second), duration(30*time.Second)
+	tests := []struct {
+		channels channelList
+		want     time.Duration
+	}{
+		{channels: channelList{{Name: testChannel}}, want: 10 * time.Second},
+		{channels: channelList{{Name: testChannel, PollInterval: &long}}, want: 30 * time.Second},
+		// a player in several channels is checked as often as the most frequent one asks
+		{channels: channelList{{Name: testChannel, PollInterval: &long}, {Name: otherTestChannel, PollInterval: &short}}, want: 2 * time.Second},
+		{channels: channelList{{Name: testChannel}, {Name: otherTestChannel, PollInterval: &long}}, want: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := tt.channels.pollInterval(10 * time.Second); got != tt.want {
+			t.Errorf("pollInterval of %v = %v, want %v", tt.channels.names(), got, tt.want)
+		}
+	}
+
+	if _, err := parseChannels([]byte(`{"76561198107240606": {"channel": "lansky", "poll_interval": 0}}`)); err == nil {
+		t.Error("parseChannels accepted a poll_interval of 0")
+	}
+}
+
+func TestPlayersWithRejectedSteamIDsAreCheckedLessOften(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.errors[testSteamID] = "Invalid steamid"
+	interval := duration(30 * time.Millisecond)
+	b := newTestBot(t, logsTF, map[string]channelList{
+		testSteamID:  {{Name: testChannel, PollInterval: &interval}},
+		otherSteamID: {{Name: otherTestChannel, PollInterval: &interval}},
+	}, nil)
+	logs := captureLogs(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		b.pollLogs(ctx)
+	}()
+	time.Sleep(600 * time.Millisecond)
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(harnessTimeout):
+		t.Fatal("pollLogs didn't return after the context was cancelled")
+	}
+
+	checks := map[string]int{}
+	for _, path := range logsTF.requests() {
+		for _, steamid := range []string{testSteamID, otherSteamID} {
+			if strings.Contains(path, steamid) {
+				checks[steamid]++
+			}
+		}
+	}
+	// the rejected player is held back once they reach the threshold, a check may already have been
+	// scheduled at the usual interval by then
+	if checks[testSteamID] > invalidSteamIDThreshold+1 || checks[otherSteamID] < 5 {
+		t.Errorf("Checked the rejected player %d times and the other player %d times, want at most %d and many",
+			checks[testSteamID], checks[otherSteamID], invalidSteamIDThreshold+1)
+	}
+	if !b.invalidIDs.backedOff(testSteamID) || b.invalidIDs.backedOff(otherSteamID) {
+		t.Error("Want only the rejected player backed off")
+	}
+	if n := strings.Count(logs.String(), "Steamid keeps being rejected"); n != 1 {
+		t.Errorf("Warned about the rejected steamid %d times, want once", n)
+	}
+
+	// reloading the config gives the player another go
+	writeTestFile(t, filepath.Dir(b.channelsFile), filepath.Base(b.channelsFile), `{"76561198107240606": "lansky"}`)
+	if err := b.reloadChannels(context.Background()); err != nil {
+		t.Fatalf("reloadChannels failed: %v", err)
+	}
+	if b.invalidIDs.backedOff(testSteamID) {
+		t.Error("The player is still backed off after a reload")
+	}
+}
+
+func TestOnlyRejectedSteamIDsCountTowardsBackingOff(t *testing.T) {
+	i := newInvalidSteamIDs()
+	for n := 0; n < invalidSteamIDThreshold; n++ {
+		i.record(testSteamID, &httpStatusError{StatusCode: http.StatusInternalServerError})
+		i.record(otherSteamID, &invalidSteamIDError{steamid: otherSteamID, reason: "Invalid steamid"})
+	}
+	if i.backedOff(testSteamID) {
+		t.Error("logs.tf being down backed off a player")
+	}
+	if !i.backedOff(otherSteamID) {
+		t.Errorf("A player rejected %d times in a row isn't backed off", invalidSteamIDThreshold)
+	}
+
+	// a successful check starts the count again
+	i.record(otherSteamID, nil)
+	i.record(otherSteamID, &invalidSteamIDError{steamid: otherSteamID, reason: "Invalid steamid"})
+	if i.backedOff(otherSteamID) {
+		t.Error("A player is still backed off after a successful check")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPostedLogsFileName = "posted_logs.json"
+	maxPostedLogIDs           = 10000 // how many posted log ids to remember at most, the oldest are forgotten first
+)
+
+// postedLogIDs remembers every log posted in the last window, whichever player and channels it was posted
+// for, so a log is only ever announced once. It's saved to a file so losing or going back to an old state
+// file doesn't post logs again. A zero window turns it off.
+type postedLogIDs struct {
+	mutex  *sync.Mutex
+	path   string
+	window time.Duration
+	posted map[int]time.Time
+}
+
+// loadPostedLogIDs reads the posted logs from the file, a missing or unreadable file just means nothing
+// has been posted
+func loadPostedLogIDs(path string, window time.Duration) *postedLogIDs {
+	p := &postedLogIDs{mutex: &sync.Mutex{}, path: path, window: window, posted: map[int]time.Time{}}
+	if window == 0 {
+		return p
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p
+	} else if err != nil {
+		slog.Warn("Failed to read posted logs, starting with none", "path", path, "error", err)
+		return p
+	}
+
+	var saved map[int]int64
+	if err := json.Unmarshal(b, &saved); err != nil {
+		slog.Warn("Failed to parse posted logs, starting with none", "path", path, "error", err)
+		return p
+	}
+
+	for id, unix := range saved {
+		p.posted[id] = time.Unix(unix, 0)
+	}
+	p.prune(time.Now())
+	return p
+}
+
+// claim records the log as posted, returning false if it already was within the window
+func (p *postedLogIDs) claim(id int) bool {
+	if p.window == 0 {
+		return true
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	if posted, ok := p.posted[id]; ok && now.Sub(posted) < p.window {
+		return false
+	}
+
+	p.posted[id] = now
+	p.prune(now)
+	return true
+}
+
+// release forgets that the log was posted, used when every send failed
+func (p *postedLogIDs) release(id int) {
+	if p.window == 0 {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.posted, id)
+}
+
+// prune forgets logs posted before the window, and the oldest logs past maxPostedLogIDs. The caller
+// holds the mutex.
+func (p *postedLogIDs) prune(now time.Time) {
+	for id, posted := range p.posted {
+		if now.Sub(posted) >= p.window {
+			delete(p.posted, id)
+		}
+	}
+
+	for len(p.posted) > maxPostedLogIDs {
+		oldest, oldestTime := 0, now
+		for id, posted := range p.posted {
+			if !posted.After(oldestTime) {
+				oldest, oldestTime = id, posted
+			}
+		}
+		delete(p.posted, oldest)
+	}
+}
+
+// save writes the posted logs to the file, with times as unix seconds
+func (p *postedLogIDs) save() error {
+	if p.window == 0 {
+		return nil
+	}
+
+	p.mutex.Lock()
+	saved := make(map[int]int64, len(p.posted))
+	for id, posted := range p.posted {
+		saved[id] = posted.Unix()
+	}
+	p.mutex.Unlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(p.path, data)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPostedLogIsSuppressedAfterARestart(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.setLogs(otherSteamID, testLog(100, time.Minute))
+	postedLogs := filepath.Join(t.TempDir(), defaultPostedLogsFileName)
+
+	// each run has its own state file, as if the state was lost between them
+	run := func(steamid, channel string) []string {
+		twitch := newFakeTwitch(t)
+		b := newTestBot(t, logsTF, players([]string{steamid}, channel), map[string]string{
+			postedLogsEnvName:      postedLogs,
+			dedupWindowEnvName:     "1h",
+			messageTemplateEnvName: "{{.ID}}",
+		})
+		connectTestBot(t, b, twitch, channel)
+
+		if err := b.checkLogsForPlayer(context.Background(), steamid, b.playerChannels()[steamid]); err != nil {
+			t.Fatalf("checkLogsForPlayer failed: %v", err)
+		}
+		b.posts.Wait()
+		if err := b.saveState(); err != nil {
+			t.Fatalf("saveState failed: %v", err)
+		}
+		return privmsgs(twitch.flush(t, b))
+	}
+
+	if got, want := run(testSteamID, testChannel), []string{"PRIVMSG #lansky :100"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("The first run posted %q, want %q", got, want)
+	}
+	if got := run(testSteamID, testChannel); len(got) != 0 {
+		t.Errorf("Posted %q again after a restart", got)
+	}
+	// whichever player and channel it's found for
+	if got := run(otherSteamID, otherTestChannel); len(got) != 0 {
+		t.Errorf("Posted %q again for another player after a restart", got)
+	}
+}
+
+func TestPostedLogIDsAreForgottenAfterTheWindow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), defaultPostedLogsFileName)
+	p := loadPostedLogIDs(path, time.Hour)
+	if !p.claim(100) || p.claim(100) {
+		t.Fatal("Want the first claim of a log to succeed and the second to fail")
+	}
+
+	// a log posted before the window is dropped when the file is loaded
+	p.posted[99] = time.Now().Add(-2 * time.Hour)
+	if err := p.save(); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	loaded := loadPostedLogIDs(path, time.Hour)
+	if _, ok := loaded.posted[100]; !ok {
+		t.Error("Log 100 wasn't loaded")
+	}
+	if _, ok := loaded.posted[99]; ok {
+		t.Error("Log 99 was loaded though it was posted before the window")
+	}
+
+	// a released claim can be claimed again, for when every send failed
+	loaded.release(100)
+	if !loaded.claim(100) {
+		t.Error("A released log couldn't be claimed again")
+	}
+}
+
+func TestPostedLogIDsAreBounded(t *testing.T) {
+	p := loadPostedLogIDs(filepath.Join(t.TempDir(), defaultPostedLogsFileName), time.Hour)
+	start := time.Now().Add(-time.Minute)
+	for id := 1; id <= maxPostedLogIDs; id++ {
+		p.posted[id] = start.Add(time.Duration(id) * time.Millisecond)
+	}
+
+	if !p.claim(maxPostedLogIDs + 1) {
+		t.Fatal("Claiming a new log failed")
+	}
+	if len(p.posted) != maxPostedLogIDs {
+		t.Errorf("Remembering %d logs, want at most %d", len(p.posted), maxPostedLogIDs)
+	}
+	if _, ok := p.posted[1]; ok {
+		t.Error("The oldest log is still remembered")
+	}
+}
+
+func TestPostedLogIDsCanBeTurnedOff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), defaultPostedLogsFileName)
+	p := loadPostedLogIDs(path, 0)
+	if !p.claim(100) || !p.claim(100) {
+		t.Error("A log couldn't be claimed twice without a window")
+	}
+	if err := p.save(); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Saved posted logs without a window: %v", err)
+	}
+}
+
+func TestUnreadablePostedLogIDsStartEmpty(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), defaultPostedLogsFileName, "{")
+	if p := loadPostedLogIDs(path, time.Hour); len(p.posted) != 0 || !p.claim(100) {
+		t.Errorf("Loaded %v from a corrupt file, want nothing", p.posted)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const quietHoursClockFormat = "15:04"
+
+// quietHours is a daily window during which a channel isn't posted logs, like when the streamer is offline
+// overnight. Logs found during the window are still marked as seen, so they aren't all posted once it ends.
+// A window whose end is before its start crosses midnight.
+type quietHours struct {
+	Start    string `json:"start"`              // e.g. "23:00"
+	End      string `json:"end"`                // e.g. "08:00"
+	Timezone string `json:"timezone,omitempty"` // an IANA timezone, LOGS_BOT_TIMEZONE if empty
+
+	start, end time.Duration // since midnight
+	location   *time.Location
+}
+
+func (q *quietHours) UnmarshalJSON(data []byte) error {
+	type plain quietHours
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("quiet_hours must be an object with a start and end, got %s", string(data))
+	}
+
+	var err error
+	if p.start, err = parseClock(p.Start); err != nil {
+		return fmt.Errorf("invalid start for quiet_hours: %q, expected a time like 23:00", p.Start)
+	}
+	if p.end, err = parseClock(p.End); err != nil {
+		return fmt.Errorf("invalid end for quiet_hours: %q, expected a time like 08:00", p.End)
+	}
+	if p.start == p.end {
+		return fmt.Errorf("invalid quiet_hours: start and end are both %v", p.Start)
+	}
+
+	if p.Timezone != "" {
+		if p.location, err = time.LoadLocation(p.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone for quiet_hours: %q, expected an IANA timezone like America/New_York", p.Timezone)
+		}
+	}
+
+	*q = quietHours(p)
+	return nil
+}
+
+// parseClock parses a time of day like "23:00" into how long it is after midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse(quietHoursClockFormat, s)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains reports whether t is inside the window, in the window's timezone or def if it doesn't set one
+func (q *quietHours) contains(t time.Time, def *time.Location) bool {
+	location := q.location
+	if location == nil {
+		location = def
+	}
+
+	t = t.In(location)
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if q.start < q.end {
+		return clock >= q.start && clock < q.end
+	}
+
+	// the window crosses midnight
+	return clock >= q.start || clock < q.end
+}
+
+// channelsOutsideQuietHours returns the channels that aren't in their quiet hours at t
+func (b *botConfig) channelsOutsideQuietHours(channels channelList, t time.Time) channelList {
+	var active channelList
+	for _, channel := range channels {
+		if channel.QuietHours == nil || !channel.QuietHours.contains(t, b.timeLocation) {
+			active = append(active, channel)
+		}
+	}
+
+	return active
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mustParseQuietHours(t *testing.T, config string) *quietHours {
+	t.Helper()
+
+	var q quietHours
+	if err := json.Unmarshal([]byte(config), &q); err != nil {
+		t.Fatalf("Failed to parse quiet hours %v: %v", config, err)
+	}
+	return &q
+}
+
+func TestQuietHoursContains(t *testing.T) {
+	day := func(hour, minute int) time.Time { return time.Date(2023, 7, 1, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		config string
+		t      time.Time
+		want   bool
+	}{
+		{`{"start": "09:00", "end": "17:00"}`, day(8, 59), false},
+		{`{"start": "09:00", "end": "17:00"}`, day(9, 0), true},
+		{`{"start": "09:00", "end": "17:00"}`, day(16, 59), true},
+		{`{"start": "09:00", "end": "17:00"}`, day(17, 0), false},
+
+		// crossing midnight
+		{`{"start": "23:00", "end": "08:00"}`, day(22, 59), false},
+		{`{"start": "23:00", "end": "08:00"}`, day(23, 30), true},
+		{`{"start": "23:00", "end": "08:00"}`, day(0, 0), true},
+		{`{"start": "23:00", "end": "08:00"}`, day(7, 59), true},
+		{`{"start": "23:00", "end": "08:00"}`, day(8, 0), false},
+		{`{"start": "23:00", "end": "08:00"}`, day(12, 0), false},
+
+		// 21:30 UTC is 23:30 in Berlin in the summer
+		{`{"start": "23:00", "end": "08:00", "timezone": "Europe/Berlin"}`, day(21, 30), true},
+		{`{"start": "23:00", "end": "08:00", "timezone": "Europe/Berlin"}`, day(6, 30), false},
+	}
+	for _, test := range tests {
+		if got := mustParseQuietHours(t, test.config).contains(test.t, time.UTC); got != test.want {
+			t.Errorf("%v contains %v = %v, want %v", test.config, test.t.Format(quietHoursClockFormat), got, test.want)
+		}
+	}
+}
+
+func TestQuietHoursDefaultToTheBotsTimezone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Failed to load Europe/Berlin: %v", err)
+	}
+
+	q := mustParseQuietHours(t, `{"start": "23:00", "end": "08:00"}`)
+	if at := time.Date(2023, 7, 1, 21, 30, 0, 0, time.UTC); !q.contains(at, berlin) || q.contains(at, time.UTC) {
+		t.Errorf("21:30 UTC isn't only in the quiet hours in Berlin")
+	}
+}
+
+func TestInvalidQuietHoursAreRejected(t *testing.T) {
+	for _, quiet := range []string{
+		`"23:00-08:00"`,
+		`{"start": "11pm", "end": "08:00"}`,
+		`{"start": "23:00", "end": "25:00"}`,
+		`{"start": "23:00"}`,
+		`{"start": "08:00", "end": "08:00"}`,
+		`{"start": "23:00", "end": "08:00", "timezone": "Mars/Olympus_Mons"}`,
+	} {
+		if _, err := parseChannels([]byte(`{"76561198107240606": {"channel": "lansky", "quiet_hours": ` + quiet + `}}`)); err == nil {
+			t.Errorf("parseChannels accepted the quiet hours %v", quiet)
+		}
+	}
+}
+
+func TestLogsArentPostedDuringQuietHours(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	l := testLog(100, time.Minute)
+	logsTF.setLogs(testSteamID, l)
+	twitch := newFakeTwitch(t)
+
+	// lansky is in its quiet hours for the hour either side of now, clockwork is outside them
+	now := time.Now().UTC()
+	clock := func(t time.Time) string { return t.Format(quietHoursClockFormat) }
+	channels, err := parseChannels([]byte(fmt.Sprintf(`{"76561198107240606": [
+		{"channel": "lansky", "quiet_hours": {"start": %q, "end": %q}},
+		{"channel": "clockwork", "quiet_hours": {"start": %q, "end": %q}}
+	]}`, clock(now.Add(-time.Hour)), clock(now.Add(time.Hour)), clock(now.Add(2*time.Hour)), clock(now.Add(3*time.Hour)))))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+	b := newTestBot(t, logsTF, channels, map[string]string{messageTemplateEnvName: "{{.ID}}"})
+	connectTestBot(t, b, twitch, testChannel, otherTestChannel)
+
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	if got, want := privmsgs(twitch.flush(t, b)), []string{"PRIVMSG #clockwork :100"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q, want %q", got, want)
+	}
+
+	// with every channel quiet the log is still seen, so it isn't posted once the quiet hours end
+	b.setChannels(context.Background(), map[string]channelList{testSteamID: channels[testSteamID][:1]})
+	next := testLog(101, 0)
+	logsTF.setLogs(testSteamID, next, l)
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	if got := privmsgs(twitch.flush(t, b)); len(got) != 0 {
+		t.Errorf("Posted %q during the channel's quiet hours", got)
+	}
+	if last := b.steamIDToLastLog[testSteamID]; last.ID != 101 {
+		t.Errorf("Last seen log is %v, want 101", last.ID)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that allows bursts of up to burst events, refilled at burst events per period
+type rateLimiter struct {
+	mutex  sync.Mutex
+	burst  float64
+	rate   float64 // tokens added per second
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(burst int, period time.Duration) *rateLimiter {
+	return &rateLimiter{
+		burst:  float64(burst),
+		rate:   float64(burst) / period.Seconds(),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a token is available or the context is done
+func (r *rateLimiter) wait(ctx context.Context) error {
+	for {
+		r.mutex.Lock()
+		now := time.Now()
+		r.tokens += now.Sub(r.last).Seconds() * r.rate
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+		r.last = now
+
+		if r.tokens >= 1 {
+			r.tokens--
+			r.mutex.Unlock()
+			return nil
+		}
+
+		delay := time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+		r.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllowsBurstThenPaces(t *testing.T) {
+	// a burst of 2, then one every 100ms
+	r := newRateLimiter(2, 200*time.Millisecond)
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := r.wait(context.Background()); err != nil {
+			t.Fatalf("wait failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("The burst took %v, want no wait", elapsed)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := r.wait(context.Background()); err != nil {
+			t.Fatalf("wait failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > time.Second {
+		t.Errorf("5 waits took %v, want about 300ms", elapsed)
+	}
+}
+
+func TestRateLimiterWaitStopsWithContext(t *testing.T) {
+	r := newRateLimiter(1, time.Hour)
+	r.wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("wait returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSendsArePacedByTheRateLimit(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{rateLimitBurstEnvName: "3", rateLimitPeriodEnvName: "300ms"})
+	connectTestBot(t, b, twitch)
+
+	// logging in took the burst, so each of these waits for the next token
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := b.send(context.Background(), "PRIVMSG #lansky :flood"); err != nil {
+			t.Fatalf("send failed: %v", err)
+		}
+	}
+	twitch.expect(t, "PRIVMSG #lansky :flood", "PRIVMSG #lansky :flood", "PRIVMSG #lansky :flood")
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("3 sends went out in %v, want them paced to one every 100ms", elapsed)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadChannelsOnHangup reloads the channels config every time the process receives SIGHUP, until the
+// context is done
+func (b *botConfig) reloadChannelsOnHangup(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("Reloading channels", "path", b.channelsFile)
+			if err := b.reloadChannels(ctx); err != nil {
+				slog.Error("Failed to reload channels, keeping the current config", "path", b.channelsFile, "error", err)
+			}
+		}
+	}
+}
+
+// reloadChannels re-reads the channels config and swaps it in
+func (b *botConfig) reloadChannels(ctx context.Context) error {
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
+
+	steamIDToTwitchChannel, err := b.loadChannels(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.setChannels(ctx, steamIDToTwitchChannel)
+	// a reload may have fixed the steamids that were being rejected, so give them another go
+	b.invalidIDs.reset()
+	slog.Info("Reloaded channels", "players", len(steamIDToTwitchChannel))
+	return nil
+}
+
+// setChannels swaps in a new steamid to channels mapping, joining channels that were added and parting
+// channels that were removed. Last seen logs are kept for players that are still tracked.
+func (b *botConfig) setChannels(ctx context.Context, steamIDToTwitchChannel map[string]channelList) {
+	b.mutex.Lock()
+	oldChannels := channelNames(b.steamIDToTwitchChannel)
+	b.steamIDToTwitchChannel = steamIDToTwitchChannel
+	for steamid := range b.steamIDToLastLog {
+		if _, ok := steamIDToTwitchChannel[steamid]; !ok {
+			delete(b.steamIDToLastLog, steamid)
+		}
+	}
+	b.mutex.Unlock()
+
+	b.statuses.retain(steamIDToTwitchChannel)
+	b.cooldowns.retain(steamIDToTwitchChannel)
+	b.logPlayers.retain(steamIDToTwitchChannel)
+	b.metrics.setTrackedPlayers(len(steamIDToTwitchChannel))
+
+	added, removed := diffChannels(oldChannels, channelNames(steamIDToTwitchChannel))
+	for _, channel := range added {
+		if err := b.send(ctx, "JOIN #%s", channel); err != nil {
+			slog.Warn("Failed to join channel, it will be joined on the next connection", "channel", channel, "error", err)
+			continue
+		}
+		slog.Debug("Joining channel", "channel", channel)
+	}
+
+	for _, channel := range removed {
+		if err := b.send(ctx, "PART #%s", channel); err != nil {
+			slog.Warn("Failed to part channel", "channel", channel, "error", err)
+			continue
+		}
+		slog.Debug("Parting channel", "channel", channel)
+	}
+}
+
+// diffChannels returns the channels in new but not old, and the channels in old but not new
+func diffChannels(old, new []string) (added, removed []string) {
+	inOld := map[string]bool{}
+	for _, channel := range old {
+		inOld[channel] = true
+	}
+
+	inNew := map[string]bool{}
+	for _, channel := range new {
+		inNew[channel] = true
+		if !inOld[channel] {
+			added = append(added, channel)
+		}
+	}
+
+	for _, channel := range old {
+		if !inNew[channel] {
+			removed = append(removed, channel)
+		}
+	}
+
+	return added, removed
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestReloadChannelsJoinsAndPartsTheDifference(t *testing.T) {
+	const newSteamID = "76561197960287930"
+
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	if err := os.WriteFile(b.channelsFile, []byte(`{"76561198107240606": "lansky", "76561197991735941": "clockwork"}`), 0644); err != nil {
+		t.Fatalf("Failed to write the channels: %v", err)
+	}
+	if err := b.reloadChannels(context.Background()); err != nil {
+		t.Fatalf("reloadChannels failed: %v", err)
+	}
+	connectTestBot(t, b, twitch)
+	twitch.flush(t, b)
+
+	b.steamIDToLastLog[testSteamID] = lastLog{Time: time.Unix(1700000000, 0), ID: 100}
+	b.steamIDToLastLog[otherSteamID] = lastLog{Time: time.Unix(1700000100, 0), ID: 101}
+	if err := os.WriteFile(b.channelsFile, []byte(`{"76561198107240606": "lansky", "76561197960287930": ["clockwork", "newchannel"]}`), 0644); err != nil {
+		t.Fatalf("Failed to write the channels: %v", err)
+	}
+	if err := b.reloadChannels(context.Background()); err != nil {
+		t.Fatalf("reloadChannels failed: %v", err)
+	}
+
+	// clockwork is still used by the new player, so it's neither joined nor parted
+	if lines := twitch.flush(t, b); !reflect.DeepEqual(lines, []string{"JOIN #newchannel"}) {
+		t.Errorf("Reloading wrote %q, want just JOIN #newchannel", lines)
+	}
+
+	if err := os.WriteFile(b.channelsFile, []byte(`{"76561198107240606": "lansky"}`), 0644); err != nil {
+		t.Fatalf("Failed to write the channels: %v", err)
+	}
+	if err := b.reloadChannels(context.Background()); err != nil {
+		t.Fatalf("reloadChannels failed: %v", err)
+	}
+	lines := twitch.flush(t, b)
+	sort.Strings(lines)
+	if want := []string{"PART #clockwork", "PART #newchannel"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("Reloading wrote %q, want %q", lines, want)
+	}
+
+	want := map[string]lastLog{testSteamID: {Time: time.Unix(1700000000, 0), ID: 100}}
+	if !reflect.DeepEqual(b.steamIDToLastLog, want) {
+		t.Errorf("Last seen logs after reloading are %v, want only the remaining player's %v", b.steamIDToLastLog, want)
+	}
+	if _, ok := b.playerChannels()[newSteamID]; ok {
+		t.Errorf("%v is still tracked after being removed", newSteamID)
+	}
+}
+
+func TestReloadChannelsKeepsTheConfigOnErrors(t *testing.T) {
+	b := newTestBot(t, nil, players([]string{testSteamID}, testChannel), nil)
+	if err := os.WriteFile(b.channelsFile, []byte(`{"76561198107240606": `), 0644); err != nil {
+		t.Fatalf("Failed to write the channels: %v", err)
+	}
+
+	if err := b.reloadChannels(context.Background()); err == nil {
+		t.Fatal("reloadChannels accepted a broken config")
+	}
+	if got := b.playerChannels()[testSteamID].names(); !reflect.DeepEqual(got, []string{testChannel}) {
+		t.Errorf("%v is posted to %v after a failed reload, want [%v]", testSteamID, got, testChannel)
+	}
+}
+
+// run with -race, reloading while the config is read from the poller, commands and the HTTP endpoints
+func TestChannelsCanBeReloadedWhileInUse(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, newFakeLogsTF(t), players([]string{testSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	configs := []map[string]channelList{
+		players([]string{testSteamID, otherSteamID}, testChannel),
+		players([]string{otherSteamID}, otherTestChannel),
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			b.setChannels(context.Background(), configs[i%len(configs)])
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		b.pollOnce(context.Background())
+		b.steamIDsForChannel(testChannel)
+		b.handleStatus(httptest.NewRecorder(), httptest.NewRequest("GET", "/status", nil))
+		b.handleHealthz(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+		for steamid, channels := range b.playerChannels() {
+			_ = steamid + channels.names()[0]
+		}
+	}
+}
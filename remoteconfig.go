@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConfigRefresh       = 5 * time.Minute       // how often the channels config is fetched again from LOGS_BOT_CONFIG_URL
+	defaultConfigCacheFileName = "channels_cache.json" // where the config last fetched from LOGS_BOT_CONFIG_URL is saved
+)
+
+// fetchChannelsConfig fetches the channels config from configURL, converting it to JSON if it's YAML. A
+// config is taken to be YAML if the URL's path has a .yaml or .yml extension.
+func (b *botConfig) fetchChannelsConfig(ctx context.Context) ([]byte, error) {
+	body, err := b.get(ctx, b.configURL)
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return nil, fmt.Errorf("config server responded with %v", statusErr.StatusCode)
+	}
+	if err != nil {
+		// the url may have credentials in it, so leave it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+
+	if u, err := url.Parse(b.configURL); err == nil {
+		switch strings.ToLower(path.Ext(u.Path)) {
+		case ".yaml", ".yml":
+			return yamlToJSON(body)
+		}
+	}
+
+	return body, nil
+}
+
+// loadChannelsFromURL fetches the channels config from configURL, saving it to the cache file so the bot
+// can still start with the last config fetched if the config server is down. The cache is kept apart from
+// the config file, which may be a directory of configs or not be writable.
+func (b *botConfig) loadChannelsFromURL(ctx context.Context) (map[string]channelList, error) {
+	data, err := b.fetchChannelsConfig(ctx)
+	if err != nil {
+		channels, fileErr := loadChannelsFromFile(b.configCache)
+		if fileErr != nil {
+			return nil, fmt.Errorf("Failed to fetch channels from %v: %v", configURLEnvName, err)
+		}
+		slog.Warn("Failed to fetch channels, using the last config fetched", "path", b.configCache, "error", err)
+		return b.resolveVanityURLs(ctx, channels), nil
+	}
+
+	return b.parseFetchedChannels(ctx, data)
+}
+
+// parseFetchedChannels parses a config fetched from configURL and saves it to the cache file
+func (b *botConfig) parseFetchedChannels(ctx context.Context, data []byte) (map[string]channelList, error) {
+	channels, err := parseChannels(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid channels from %v: %v", configURLEnvName, err)
+	}
+	channels = b.resolveVanityURLs(ctx, channels)
+
+	b.fetchedConfig = data
+	if err := saveChannelsToFile(b.configCache, channels); err != nil {
+		slog.Warn("Failed to save the fetched channels", "path", b.configCache, "error", err)
+	}
+
+	return channels, nil
+}
+
+// refreshChannelsPeriodically fetches the channels config from configURL every configRefresh and swaps it in
+// if it changed, until the context is done. A failed fetch keeps the current config.
+func (b *botConfig) refreshChannelsPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(b.configRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.refreshChannels(ctx); err != nil && ctx.Err() == nil {
+				slog.Error("Failed to refresh channels, keeping the current config", "error", err)
+			}
+		}
+	}
+}
+
+// refreshChannels fetches the channels config and swaps it in, leaving the running config alone if the
+// fetched config is the same as last time
+func (b *botConfig) refreshChannels(ctx context.Context) error {
+	b.configMutex.Lock()
+	defer b.configMutex.Unlock()
+
+	data, err := b.fetchChannelsConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, b.fetchedConfig) {
+		return nil
+	}
+
+	channels, err := b.parseFetchedChannels(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	b.setChannels(ctx, channels)
+	b.invalidIDs.reset()
+	slog.Info("Refreshed channels from "+configURLEnvName, "players", len(channels))
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakeConfigServer serves a channels config that can be changed, or a 500 when failing is set
+type fakeConfigServer struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	config  string
+	failing bool
+}
+
+func newFakeConfigServer(t *testing.T, config string) *fakeConfigServer {
+	t.Helper()
+
+	f := &fakeConfigServer{config: config}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+
+		if f.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(f.config))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeConfigServer) set(config string, failing bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.config, f.failing = config, failing
+}
+
+func TestRefreshChannelsPicksUpChanges(t *testing.T) {
+	server := newFakeConfigServer(t, `{"76561198107240606": "lansky"}`)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{configURLEnvName: server.URL})
+	channels, err := b.loadChannels(context.Background())
+	if err != nil {
+		t.Fatalf("loadChannels failed: %v", err)
+	}
+	b.setChannels(context.Background(), channels)
+	connectTestBot(t, b, twitch, testChannel)
+	twitch.flush(t, b)
+
+	server.set(`{"76561197991735941": "clockwork"}`, false)
+	if err := b.refreshChannels(context.Background()); err != nil {
+		t.Fatalf("refreshChannels failed: %v", err)
+	}
+
+	if lines := twitch.flush(t, b); !reflect.DeepEqual(lines, []string{"JOIN #clockwork", "PART #lansky"}) {
+		t.Errorf("Refreshing wrote %q, want to join #clockwork and part #lansky", lines)
+	}
+	if got := b.playerChannels()[otherSteamID].names(); !reflect.DeepEqual(got, []string{otherTestChannel}) || len(b.playerChannels()) != 1 {
+		t.Errorf("Refreshed to %v, want only %v posted to [%v]", b.playerChannels(), otherSteamID, otherTestChannel)
+	}
+
+	// an unchanged config or a failed fetch leaves the running config alone
+	if err := b.refreshChannels(context.Background()); err != nil {
+		t.Fatalf("refreshChannels failed: %v", err)
+	}
+	server.set("", true)
+	if err := b.refreshChannels(context.Background()); err == nil {
+		t.Error("refreshChannels succeeded with the config server down")
+	}
+	if lines := twitch.flush(t, b); len(lines) != 0 {
+		t.Errorf("Refreshing without changes wrote %q", lines)
+	}
+	if got := b.playerChannels()[otherSteamID].names(); !reflect.DeepEqual(got, []string{otherTestChannel}) {
+		t.Errorf("%v is posted to %v after a failed refresh, want [%v]", otherSteamID, got, otherTestChannel)
+	}
+}
+
+func TestFetchedConfigIsCachedApartFromTheConfigFile(t *testing.T) {
+	// the config path is a directory of configs, which the fetched config can't be saved over
+	dir := t.TempDir()
+	writeTestFile(t, dir, "lansky.json", `{"76561198107240606": "lansky"}`)
+	server := newFakeConfigServer(t, `{"76561197991735941": "clockwork"}`)
+	cache := filepath.Join(t.TempDir(), defaultConfigCacheFileName)
+	env := map[string]string{configEnvName: dir, configURLEnvName: server.URL, configCacheEnvName: cache}
+
+	b := newTestBot(t, nil, nil, env)
+	if _, err := b.loadChannels(context.Background()); err != nil {
+		t.Fatalf("loadChannels failed: %v", err)
+	}
+	if _, err := os.Stat(cache); err != nil {
+		t.Fatalf("The fetched config wasn't cached: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("The config directory has %d files, want it left alone", len(entries))
+	}
+
+	// with the config server down the bot starts with the cached config
+	server.set("", true)
+	b = newTestBot(t, nil, nil, env)
+	channels, err := b.loadChannels(context.Background())
+	if err != nil {
+		t.Fatalf("loadChannels failed with a cached config: %v", err)
+	}
+	if got := channels[otherSteamID].names(); !reflect.DeepEqual(got, []string{otherTestChannel}) || len(channels) != 1 {
+		t.Errorf("Loaded %v from the cache, want only %v posted to [%v]", channels, otherSteamID, otherTestChannel)
+	}
+
+	os.Remove(cache)
+	if _, err := newTestBot(t, nil, nil, env).loadChannels(context.Background()); err == nil {
+		t.Error("loadChannels succeeded with the config server down and nothing cached")
+	}
+}
+
+func TestFetchYAMLConfig(t *testing.T) {
+	server := newFakeConfigServer(t, "# lansky's logs\n\"76561198107240606\": lansky\n")
+	b := newTestBot(t, nil, nil, map[string]string{configURLEnvName: server.URL + "/channels.yaml"})
+
+	channels, err := b.loadChannels(context.Background())
+	if err != nil {
+		t.Fatalf("loadChannels failed: %v", err)
+	}
+	if got := channels[testSteamID].names(); !reflect.DeepEqual(got, []string{testChannel}) {
+		t.Errorf("Loaded %v, want %v posted to [%v]", channels, testSteamID, testChannel)
+	}
+}
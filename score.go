@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const detailCacheSize = 100 // how many logs' details to remember
+
+// a player's outcome in a log, which channels can limit their logs to
+const (
+	outcomeWin  = "win"
+	outcomeLoss = "loss"
+)
+
+// logScore is the final RED and BLU score of a log
+type logScore struct {
+	Red, Blue int
+}
+
+func (s logScore) String() string {
+	return fmt.Sprintf("RED %d - %d BLU", s.Red, s.Blue)
+}
+
+// logDetail is what's used from a log's details on top of the search result
+type logDetail struct {
+	info     logResponse       // what the search result has about the log, for logs that weren't searched for
+	score    *logScore         // nil for logs without team scores, like logs of a single team
+	length   time.Duration     // how long the match went on for, zero if the log doesn't say
+	teams    map[string]string // SteamID64 to "red" or "blue"
+	names    map[string]string // SteamID64 to the player's name in the log
+	uploader string            // SteamID64 of who uploaded the log, "" if the log doesn't say
+}
+
+// outcome returns "win" or "loss" for the player, or "" if it can't be told, e.g. the player isn't in the
+// log or the match was a draw
+func (d logDetail) outcome(steamid string) string {
+	steamID64, err := normalizeSteamID(steamid)
+	if err != nil || d.score == nil {
+		return ""
+	}
+
+	own, other := d.score.Red, d.score.Blue
+	switch d.teams[steamID64] {
+	case "red":
+	case "blue":
+		// compare from BLU's side
+		own, other = other, own
+	default:
+		return ""
+	}
+
+	switch {
+	case own > other:
+		return outcomeWin
+	case own < other:
+		return outcomeLoss
+	default:
+		return ""
+	}
+}
+
+// detailCache remembers the details of recent logs, so a log posted for several players in the same match
+// only has its details fetched once. A log's details never change, so entries don't expire.
+type detailCache struct {
+	mutex   *sync.Mutex
+	details map[int]logDetail
+}
+
+func newDetailCache() *detailCache {
+	return &detailCache{mutex: &sync.Mutex{}, details: map[int]logDetail{}}
+}
+
+func (c *detailCache) get(id int) (logDetail, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	d, ok := c.details[id]
+	return d, ok
+}
+
+func (c *detailCache) put(id int, d logDetail) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// older logs are rarely posted again, so starting over when full is good enough
+	if len(c.details) >= detailCacheSize {
+		c.details = map[int]logDetail{}
+	}
+	c.details[id] = d
+}
+
+// getLogDetail fetches the log's details from logs.tf for its team scores, length and which team each player
+// was on
+func (b *botConfig) getLogDetail(ctx context.Context, id int) (logDetail, error) {
+	if d, ok := b.details.get(id); ok {
+		return d, nil
+	}
+
+	body, err := b.getFromMirrors(ctx, "/json/"+strconv.Itoa(id))
+	if err != nil {
+		return logDetail{}, err
+	}
+
+	type team struct {
+		Score *int `json:"score"`
+	}
+	var response struct {
+		Teams struct {
+			Red  team `json:"Red"`
+			Blue team `json:"Blue"`
+		} `json:"teams"`
+		Players map[string]struct {
+			Team string `json:"team"`
+		} `json:"players"`
+		Names  map[string]string `json:"names"`
+		Length int               `json:"length"` // in seconds
+		Info   struct {
+			Title string `json:"title"`
+			Map   string `json:"map"`
+			Date  int64  `json:"date"`
+
+			Uploader struct {
+				ID string `json:"id"`
+			} `json:"uploader"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return logDetail{}, &decodeError{err: err, body: bodySnippet(body)}
+	}
+
+	d := logDetail{
+		info: logResponse{
+			ID:      id,
+			Date:    response.Info.Date,
+			Title:   response.Info.Title,
+			Map:     response.Info.Map,
+			Players: len(response.Players),
+		},
+		length: time.Duration(response.Length) * time.Second,
+		teams:  map[string]string{},
+		names:  map[string]string{},
+	}
+	if response.Teams.Red.Score != nil && response.Teams.Blue.Score != nil {
+		d.score = &logScore{Red: *response.Teams.Red.Score, Blue: *response.Teams.Blue.Score}
+	}
+	for steamid, p := range response.Players {
+		if steamID64, err := normalizeSteamID(steamid); err == nil {
+			d.teams[steamID64] = strings.ToLower(p.Team)
+		}
+	}
+	if steamID64, err := normalizeSteamID(response.Info.Uploader.ID); err == nil {
+		d.uploader = steamID64
+	}
+	for steamid, name := range response.Names {
+		if steamID64, err := normalizeSteamID(steamid); err == nil {
+			d.names[steamID64] = name
+		}
+	}
+
+	b.details.put(id, d)
+	return d, nil
+}
+
+// addDetail looks up the log's details to include its score in the message, the log is still posted without
+// it if the details can't be fetched. It returns false if there are no details.
+func (b *botConfig) addDetail(ctx context.Context, l *logResponse) (logDetail, bool) {
+	d, err := b.getLogDetail(ctx, l.ID)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Debug("Posting log without its details", "log_id", l.ID, "error", err)
+		}
+		return logDetail{}, false
+	}
+
+	l.score = d.score
+	l.names = d.names
+	return d, true
+}
+
+// channelsAllowingOutcome returns the channels that want the log given how the player's match went. Channels
+// with an outcome set skip logs whose outcome for the player can't be told.
+func channelsAllowingOutcome(channels channelList, outcome string) channelList {
+	var allowed channelList
+	for _, channel := range channels {
+		if channel.Outcome == "" || channel.Outcome == outcome {
+			allowed = append(allowed, channel)
+		}
+	}
+
+	return allowed
+}
+
+// channelsAllowingLength returns the channels that want a log of the match's length. Channels with a
+// min_duration skip logs whose length isn't known.
+func channelsAllowingLength(channels channelList, length time.Duration) channelList {
+	var allowed channelList
+	for _, channel := range channels {
+		if channel.MinDuration == nil || (length > 0 && length >= time.Duration(*channel.MinDuration)) {
+			allowed = append(allowed, channel)
+		}
+	}
+
+	return allowed
+}
+
+// channelsAllowingUploader returns the channels that want a log uploaded by uploader. Channels with uploaders
+// set skip logs whose uploader isn't known.
+func channelsAllowingUploader(channels channelList, uploader string) channelList {
+	var allowed channelList
+	for _, channel := range channels {
+		if channel.Uploaders == nil || (uploader != "" && slices.Contains(channel.Uploaders, uploader)) {
+			allowed = append(allowed, channel)
+		}
+	}
+
+	return allowed
+}
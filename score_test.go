@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const scoredDetail = `{
+	"teams": {"Red": {"score": 5}, "Blue": {"score": 3}},
+	"players": {"[U:1:146974878]": {"team": "Red"}},
+	"length": 1800,
+	"info": {"title": "serveme.tf #100", "map": "cp_process_f12", "date": 1700000000}
+}`
+
+func TestPostedLogsHaveTheirScore(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setDetail(100, scoredDetail)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	want := "PRIVMSG #lansky :" + logsTF.URL + "/100 — serveme.tf #100 (cp_process_f12) (RED 5 - 3 BLU)"
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 1 || messages[0] != want {
+		t.Errorf("Posted %q, want %q", messages, want)
+	}
+}
+
+func TestLogsWithoutAScoreArePostedWithout(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setDetail(101, `{"teams": {"Red": {}, "Blue": {}}, "info": {"title": "serveme.tf #101"}}`)
+	logsTF.setLogs(testSteamID, testLog(101, time.Minute), testLog(100, 2*time.Minute))
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	// log 100's details are missing altogether
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	messages := privmsgs(twitch.flush(t, b))
+	if len(messages) != 2 {
+		t.Fatalf("Posted %q, want both logs", messages)
+	}
+	for _, message := range messages {
+		if strings.Contains(message, "RED") {
+			t.Errorf("Posted %q for a log without a score", message)
+		}
+	}
+}
+
+func TestLogDetailsAreCached(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setDetail(100, scoredDetail)
+	b := newTestBot(t, logsTF, nil, nil)
+
+	for i := 0; i < 3; i++ {
+		d, err := b.getLogDetail(context.Background(), 100)
+		if err != nil {
+			t.Fatalf("getLogDetail failed: %v", err)
+		}
+		if d.score == nil || *d.score != (logScore{Red: 5, Blue: 3}) || d.length != 30*time.Minute {
+			t.Fatalf("Got the score %v and length %v, want RED 5 - 3 BLU and 30m", d.score, d.length)
+		}
+	}
+	if n := len(logsTF.requests()); n != 1 {
+		t.Errorf("logs.tf got %d requests for the log's details, want 1", n)
+	}
+}
+
+func TestRenderMessageScoreFields(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{messageTemplateEnvName: "{{.URL}} {{.RedScore}}:{{.BlueScore}}"})
+
+	l := &logResponse{ID: 100, score: &logScore{Red: 2, Blue: 4}}
+	if got, want := mustRender(t, b, l), b.logURL(100)+" 2:4"; got != want {
+		t.Errorf("renderMessage = %q, want %q", got, want)
+	}
+}
+
+func TestLogDetailOutcome(t *testing.T) {
+	d := logDetail{score: &logScore{Red: 5, Blue: 3}, teams: map[string]string{testSteamID: "red", otherSteamID: "blue"}}
+	tests := map[string]string{
+		testSteamID:         outcomeWin,
+		"[U:1:146974878]":   outcomeWin,
+		otherSteamID:        outcomeLoss,
+		"76561197960287930": "", // not in the log
+		"not a steamid":     "",
+	}
+	for steamid, want := range tests {
+		if got := d.outcome(steamid); got != want {
+			t.Errorf("outcome(%q) = %q, want %q", steamid, got, want)
+		}
+	}
+
+	draw := logDetail{score: &logScore{Red: 2, Blue: 2}, teams: d.teams}
+	if got := draw.outcome(testSteamID); got != "" {
+		t.Errorf("outcome of a draw = %q, want none", got)
+	}
+}
+
+func TestChannelsOnlyGetTheOutcomeTheyWant(t *testing.T) {
+	const (
+		// the tracked player won log 100 on RED and lost log 101 on BLU, log 102 was a draw
+		wonDetail  = `{"teams": {"Red": {"score": 5}, "Blue": {"score": 3}}, "players": {"[U:1:146974878]": {"team": "Red"}}}`
+		lostDetail = `{"teams": {"Red": {"score": 5}, "Blue": {"score": 3}}, "players": {"[U:1:146974878]": {"team": "Blue"}}}`
+		drawDetail = `{"teams": {"Red": {"score": 2}, "Blue": {"score": 2}}, "players": {"[U:1:146974878]": {"team": "Red"}}}`
+	)
+
+	logsTF := newFakeLogsTF(t)
+	logsTF.setDetail(100, wonDetail)
+	logsTF.setDetail(101, lostDetail)
+	logsTF.setDetail(102, drawDetail)
+	twitch := newFakeTwitch(t)
+	channels := map[string]channelList{testSteamID: {
+		{Name: testChannel, Outcome: outcomeWin},
+		{Name: otherTestChannel, Outcome: outcomeLoss},
+		{Name: "everything"},
+	}}
+	b := newTestBot(t, logsTF, channels, map[string]string{messageTemplateEnvName: "{{.ID}}"})
+	connectTestBot(t, b, twitch, testChannel, otherTestChannel, "everything")
+
+	for _, l := range []logResponse{testLog(100, 3*time.Minute), testLog(101, 2*time.Minute), testLog(102, time.Minute)} {
+		b.postLog(context.Background(), testSteamID, &l, lastLog{ID: l.ID, Time: logTime(&l)}, lastLog{}, b.playerChannels()[testSteamID])
+	}
+
+	// a draw is neither a win nor a loss
+	want := []string{
+		"PRIVMSG #clockwork :101",
+		"PRIVMSG #everything :100", "PRIVMSG #everything :101", "PRIVMSG #everything :102",
+		"PRIVMSG #lansky :100",
+	}
+	got := privmsgs(twitch.flush(t, b))
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q, want %q", got, want)
+	}
+}
+
+func TestOutcomeChannelsSkipLogsWithoutDetails(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, map[string]channelList{testSteamID: {{Name: testChannel, Outcome: outcomeWin}}}, nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 0 {
+		t.Errorf("Posted %q, want nothing when the outcome can't be told", messages)
+	}
+}
+
+func TestInvalidOutcomeIsRejected(t *testing.T) {
+	if _, err := parseChannels([]byte(`{"76561198107240606": {"channel": "lansky", "outcome": "draw"}}`)); err == nil || !strings.Contains(err.Error(), "outcome") {
+		t.Errorf("parseChannels returned %v, want an error about the outcome", err)
+	}
+}
+
+func TestChannelsSkipLogsShorterThanTheirMinDuration(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setDetail(100, `{"teams": {"Red": {"score": 1}, "Blue": {"score": 0}}, "length": 300}`)
+	logsTF.setDetail(101, `{"teams": {"Red": {"score": 5}, "Blue": {"score": 3}}, "length": 1800}`)
+	twitch := newFakeTwitch(t)
+	channels, err := parseChannels([]byte(`{"76561198107240606": [{"channel": "lansky", "min_duration": "10m"}, "everything"]}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+	b := newTestBot(t, logsTF, channels, map[string]string{messageTemplateEnvName: "{{.ID}}"})
+	connectTestBot(t, b, twitch, testChannel, "everything")
+
+	// 100 is a 5 minute warmup, 101 a 30 minute match and 102 has no details to tell its length from
+	for _, l := range []logResponse{testLog(100, 3*time.Minute), testLog(101, 2*time.Minute), testLog(102, time.Minute)} {
+		b.postLog(context.Background(), testSteamID, &l, lastLog{ID: l.ID, Time: logTime(&l)}, lastLog{}, b.playerChannels()[testSteamID])
+	}
+
+	want := []string{
+		"PRIVMSG #everything :100", "PRIVMSG #everything :101", "PRIVMSG #everything :102",
+		"PRIVMSG #lansky :101",
+	}
+	got := privmsgs(twitch.flush(t, b))
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q, want %q", got, want)
+	}
+}
+
+func TestChannelsOnlyPostLogsFromTheirUploaders(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setDetail(100, `{"info": {"uploader": {"id": "76561198107240606", "name": "RGL Bot"}}}`)
+	logsTF.setDetail(101, `{"info": {"uploader": {"id": "76561197991735941", "name": "pug uploader"}}}`)
+	twitch := newFakeTwitch(t)
+	channels, err := parseChannels([]byte(`{"76561198107240606": [{"channel": "lansky", "uploaders": ["[U:1:146974878]"]}, "everything"]}`))
+	if err != nil {
+		t.Fatalf("parseChannels failed: %v", err)
+	}
+	b := newTestBot(t, logsTF, channels, map[string]string{messageTemplateEnvName: "{{.ID}}"})
+	connectTestBot(t, b, twitch, testChannel, "everything")
+
+	// 100 is from the allowed uploader, 101 from another and 102 has no details to tell its uploader from
+	for _, l := range []logResponse{testLog(100, 3*time.Minute), testLog(101, 2*time.Minute), testLog(102, time.Minute)} {
+		b.postLog(context.Background(), testSteamID, &l, lastLog{ID: l.ID, Time: logTime(&l)}, lastLog{}, b.playerChannels()[testSteamID])
+	}
+
+	want := []string{
+		"PRIVMSG #everything :100", "PRIVMSG #everything :101", "PRIVMSG #everything :102",
+		"PRIVMSG #lansky :100",
+	}
+	got := privmsgs(twitch.flush(t, b))
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Posted %q, want %q", got, want)
+	}
+}
+
+func TestInvalidUploaderIsRejected(t *testing.T) {
+	if _, err := parseChannels([]byte(`{"76561198107240606": {"channel": "lansky", "uploaders": ["RGL Bot"]}}`)); err == nil || !strings.Contains(err.Error(), "uploader") {
+		t.Errorf("parseChannels returned %v, want an error about the uploader", err)
+	}
+}
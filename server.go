@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const httpShutdownTimeout = 5 * time.Second // how long to wait for in-flight HTTP requests when shutting down
+
+// serveHTTP serves the health, status and metrics endpoints on addr until the context is done
+func (b *botConfig) serveHTTP(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", b.handleHealthz)
+	mux.HandleFunc("/status", b.handleStatus)
+	if h, ok := b.metrics.(http.Handler); ok {
+		mux.Handle("/metrics", h)
+	}
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving HTTP", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Error("Failed to serve HTTP", "addr", addr, "error", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lastLog is the newest log seen for a player
+type lastLog struct {
+	Time time.Time
+	ID   int
+}
+
+// savedLog is how a lastLog is written to the state file
+type savedLog struct {
+	Time  int64 `json:"time"`
+	LogID int   `json:"log_id"`
+}
+
+func (s *savedLog) UnmarshalJSON(data []byte) error {
+	// state files from before log ids were saved only have the unix timestamp
+	var unix int64
+	if err := json.Unmarshal(data, &unix); err == nil {
+		*s = savedLog{Time: unix}
+		return nil
+	}
+
+	type plain savedLog
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+// loadState reads the last seen log for each steamid from the state file. A missing or corrupt state file
+// isn't fatal, the bot just starts without any memory of what it has already posted.
+func loadState(path string) map[string]lastLog {
+	steamIDToLastLog := map[string]lastLog{}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return steamIDToLastLog
+	} else if err != nil {
+		slog.Warn("Failed to read state, starting empty", "path", path, "error", err)
+		return steamIDToLastLog
+	}
+
+	var saved map[string]savedLog
+	if err := json.Unmarshal(b, &saved); err != nil {
+		slog.Warn("Failed to parse state, starting empty", "path", path, "error", err)
+		return steamIDToLastLog
+	}
+
+	for steamid, s := range saved {
+		steamIDToLastLog[steamid] = lastLog{Time: time.Unix(s.Time, 0), ID: s.LogID}
+	}
+
+	return steamIDToLastLog
+}
+
+// seedLastSeen records each player's newest log as already seen without posting it, so only logs uploaded
+// after the bot started are announced. Players that can't be looked up keep whatever the state file had.
+func (b *botConfig) seedLastSeen(ctx context.Context) {
+	for steamid := range b.playerChannels() {
+		res, err := b.getNewestLogForPlayer(ctx, steamid)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, errNoLogs) {
+				slog.Warn("Failed to seed player's last log", "steamid", steamid, "error", err)
+			}
+			continue
+		}
+
+		b.mutex.Lock()
+		b.steamIDToLastLog[steamid] = lastLog{Time: time.Unix(res.Date, 0), ID: res.ID}
+		b.mutex.Unlock()
+		slog.Debug("Seeded player's last log", "steamid", steamid, "log_id", res.ID)
+	}
+}
+
+// saveState writes the last seen log for each steamid to the state file, with timestamps as unix seconds,
+// and the logs posted within LOGS_BOT_DEDUP_WINDOW to their own file
+func (b *botConfig) saveState() error {
+	b.mutex.Lock()
+	saved := make(map[string]savedLog, len(b.steamIDToLastLog))
+	for steamid, last := range b.steamIDToLastLog {
+		saved[steamid] = savedLog{Time: last.Time.Unix(), LogID: last.ID}
+	}
+	b.mutex.Unlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(b.stateFileName, data); err != nil {
+		return err
+	}
+
+	if err := b.postedIDs.save(); err != nil {
+		return fmt.Errorf("Failed to save posted logs to %v: %v", b.postedLogsFile, err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes the data to a temporary file and renames it over path, so a crash mid-write can't
+// leave a truncated file behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// saveStatePeriodically saves the state every stateSaveIntervalInSeconds until the context is done
+func (b *botConfig) saveStatePeriodically(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(stateSaveIntervalInSeconds * time.Second):
+			if err := b.saveState(); err != nil {
+				slog.Error("Failed to save state", "path", b.stateFileName, "error", err)
+			}
+		}
+	}
+}
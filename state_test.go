@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	b := newTestBot(t, nil, nil, nil)
+	b.steamIDToLastLog = map[string]lastLog{
+		testSteamID:  {Time: time.Unix(1700000000, 0), ID: 100},
+		otherSteamID: {Time: time.Unix(1700000100, 0), ID: 101},
+	}
+	if err := b.saveState(); err != nil {
+		t.Fatalf("saveState failed: %v", err)
+	}
+
+	if loaded := loadState(b.stateFileName); !reflect.DeepEqual(loaded, b.steamIDToLastLog) {
+		t.Errorf("Loaded %v, want %v", loaded, b.steamIDToLastLog)
+	}
+}
+
+func TestLoadStateToleratesBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"missing": filepath.Join(dir, "missing.json"),
+		"corrupt": writeTestFile(t, dir, "corrupt.json", `{"76561198107240606": {"time": `),
+		"invalid": writeTestFile(t, dir, "invalid.json", `["not", "a", "state", "file"]`),
+	}
+	for name, path := range tests {
+		t.Run(name, func(t *testing.T) {
+			if loaded := loadState(path); loaded == nil || len(loaded) != 0 {
+				t.Errorf("Loaded %v, want an empty state", loaded)
+			}
+		})
+	}
+}
+
+func TestLoadStateReadsTimestampOnlyState(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), defaultStateFileName, `{"76561198107240606": 1700000000}`)
+
+	want := map[string]lastLog{testSteamID: {Time: time.Unix(1700000000, 0)}}
+	if loaded := loadState(path); !reflect.DeepEqual(loaded, want) {
+		t.Errorf("Loaded %v, want %v", loaded, want)
+	}
+}
+
+func TestSeededLogsArentPosted(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID, otherSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	// the second player has no logs yet, so there's nothing to seed for them
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	b.seedLastSeen(context.Background())
+	if last := b.steamIDToLastLog[testSteamID]; last.ID != 100 {
+		t.Fatalf("Seeded %+v, want log 100", last)
+	}
+	if _, ok := b.steamIDToLastLog[otherSteamID]; ok {
+		t.Errorf("Seeded %+v for a player without logs", b.steamIDToLastLog[otherSteamID])
+	}
+
+	check := func() {
+		t.Helper()
+		if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+			t.Fatalf("checkLogsForPlayer failed: %v", err)
+		}
+		b.posts.Wait()
+	}
+
+	check()
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 0 {
+		t.Errorf("Posted %q, want the seeded log skipped", messages)
+	}
+
+	logsTF.setLogs(testSteamID, testLog(101, 0), testLog(100, time.Minute))
+	check()
+	if messages := privmsgs(twitch.flush(t, b)); len(messages) != 1 || !strings.Contains(messages[0], "/101 ") {
+		t.Errorf("Posted %q, want just the log uploaded after seeding", messages)
+	}
+}
+
+func TestSeedingIsOffByDefault(t *testing.T) {
+	b := newTestBot(t, nil, nil, nil)
+	if b.seedOnStartup {
+		t.Errorf("%v is on by default", seedOnStartupEnvName)
+	}
+
+	b = newTestBot(t, nil, nil, map[string]string{seedOnStartupEnvName: "true"})
+	if !b.seedOnStartup {
+		t.Errorf("%v=true didn't turn seeding on", seedOnStartupEnvName)
+	}
+}
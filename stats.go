@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// recentPollsSize is how many of the latest polls the poll error rate is worked out from
+const recentPollsSize = 100
+
+// sessionStats counts what the bot has done since the process started, for the !botstatus command. They're
+// kept across reconnects.
+type sessionStats struct {
+	mutex       *sync.Mutex
+	started     time.Time
+	connections int
+	logsPosted  int
+
+	recentPolls []bool // whether each of the latest polls failed, oldest first
+}
+
+func newSessionStats() *sessionStats {
+	return &sessionStats{mutex: &sync.Mutex{}, started: time.Now()}
+}
+
+func (s *sessionStats) recordConnection() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.connections++
+}
+
+func (s *sessionStats) recordLogPosted() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.logsPosted++
+}
+
+func (s *sessionStats) recordPoll(failed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.recentPolls) == recentPollsSize {
+		s.recentPolls = s.recentPolls[1:]
+	}
+	s.recentPolls = append(s.recentPolls, failed)
+}
+
+// summary describes the stats in a line short enough for chat, e.g. "Up 3h2m10s, 1 reconnect, 14 logs
+// posted, 2 of the last 100 polls failed"
+func (s *sessionStats) summary() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reconnects := 0
+	if s.connections > 1 {
+		reconnects = s.connections - 1
+	}
+
+	failed := 0
+	for _, f := range s.recentPolls {
+		if f {
+			failed++
+		}
+	}
+	polls := "no polls yet"
+	if len(s.recentPolls) > 0 {
+		polls = fmt.Sprintf("%d of the last %d %s failed", failed, len(s.recentPolls), plural(len(s.recentPolls), "poll", "polls"))
+	}
+
+	return fmt.Sprintf("Up %v, %d %s, %d %s posted, %s", time.Since(s.started).Round(time.Second), reconnects,
+		plural(reconnects, "reconnect", "reconnects"), s.logsPosted, plural(s.logsPosted, "log", "logs"), polls)
+}
+
+// plural returns one if n is 1 and many otherwise
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+
+	return many
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestBotStatusCommandReportsTheSession(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.errors[otherSteamID] = "Invalid steamid"
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID, otherSteamID}, testChannel), nil)
+	connectTestBot(t, b, twitch, testChannel)
+
+	// as Serve records them, the first connection and a reconnect
+	b.stats.recordConnection()
+	b.stats.recordConnection()
+	b.pollOnce(context.Background())
+	twitch.flush(t, b)
+
+	// only moderators get an answer
+	b.handleChatMessage(context.Background(), chatMessage{user: "viewer", channel: testChannel, text: "!botstatus"})
+	if got := twitch.flush(t, b); len(got) != 0 {
+		t.Errorf("Replied %q to a viewer, want nothing", got)
+	}
+
+	b.handleChatMessage(context.Background(), chatMessage{user: "mod", channel: testChannel, text: "!botstatus", badges: map[string]string{"moderator": "1"}})
+	got := privmsgs(twitch.flush(t, b))
+	want := regexp.MustCompile(`^PRIVMSG #lansky :Up \d+s, 1 reconnect, 1 log posted, 1 of the last 2 polls failed$`)
+	if len(got) != 1 || !want.MatchString(got[0]) {
+		t.Errorf("Replied %q, want it to match %v", got, want)
+	}
+}
+
+func TestSessionStatsSummary(t *testing.T) {
+	s := newSessionStats()
+	s.started = time.Now().Add(-(3*time.Hour + 2*time.Minute + 10*time.Second))
+	if got, want := s.summary(), "Up 3h2m10s, 0 reconnects, 0 logs posted, no polls yet"; got != want {
+		t.Errorf("summary() = %q, want %q", got, want)
+	}
+
+	for i := 0; i < 3; i++ {
+		s.recordConnection()
+		s.recordLogPosted()
+	}
+	// only the latest polls count towards the failures
+	for i := 0; i < recentPollsSize; i++ {
+		s.recordPoll(true)
+	}
+	for i := 0; i < recentPollsSize-2; i++ {
+		s.recordPoll(false)
+	}
+	if got, want := s.summary(), "Up 3h2m10s, 2 reconnects, 3 logs posted, 2 of the last 100 polls failed"; got != want {
+		t.Errorf("summary() = %q, want %q", got, want)
+	}
+}
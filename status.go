@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// playerStatus is the outcome of the recent checks for a player
+type playerStatus struct {
+	LastSuccess time.Time // zero if no check has succeeded yet
+	LastError   string    // the most recent failure, kept after later checks succeed
+	LastErrorAt time.Time
+}
+
+// playerStatuses records how each player's checks are going, for diagnosing players whose logs aren't
+// being posted. Only configured players are kept, removed players are dropped when the config changes.
+type playerStatuses struct {
+	mutex    *sync.Mutex
+	byPlayer map[string]playerStatus
+}
+
+func newPlayerStatuses() *playerStatuses {
+	return &playerStatuses{mutex: &sync.Mutex{}, byPlayer: map[string]playerStatus{}}
+}
+
+// record updates the player's status with the result of a check, a player without any logs is still a
+// successful check
+func (p *playerStatuses) record(steamid string, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s := p.byPlayer[steamid]
+	if err == nil || errors.Is(err, errNoLogs) {
+		s.LastSuccess = time.Now()
+	} else {
+		s.LastError = err.Error()
+		s.LastErrorAt = time.Now()
+	}
+	p.byPlayer[steamid] = s
+}
+
+// get returns the player's status, false if the player hasn't been checked yet
+func (p *playerStatuses) get(steamid string) (playerStatus, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s, ok := p.byPlayer[steamid]
+	return s, ok
+}
+
+// snapshot returns a copy of every player's status
+func (p *playerStatuses) snapshot() map[string]playerStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	statuses := make(map[string]playerStatus, len(p.byPlayer))
+	for steamid, s := range p.byPlayer {
+		statuses[steamid] = s
+	}
+
+	return statuses
+}
+
+// retain drops the statuses of players that aren't in the config
+func (p *playerStatuses) retain(steamIDToTwitchChannel map[string]channelList) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for steamid := range p.byPlayer {
+		if _, ok := steamIDToTwitchChannel[steamid]; !ok {
+			delete(p.byPlayer, steamid)
+		}
+	}
+}
+
+// playerStatusResponse is a tracked player's state as served by /status, times are left out until there is
+// one to report
+type playerStatusResponse struct {
+	SteamID     string     `json:"steamid"`
+	Channels    []string   `json:"channels"`
+	LastLogID   int        `json:"last_log_id,omitempty"`
+	LastLogTime *time.Time `json:"last_log_time,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// optionalTime returns nil for a zero time so it's left out of the response
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// handleStatus responds with the state of the logs.tf circuit breaker and mirrors, and every tracked player
+// sorted by steamid, as JSON
+func (b *botConfig) handleStatus(w http.ResponseWriter, r *http.Request) {
+	b.mutex.Lock()
+	players := b.steamIDToTwitchChannel
+	lastLogs := make(map[string]lastLog, len(b.steamIDToLastLog))
+	for steamid, last := range b.steamIDToLastLog {
+		lastLogs[steamid] = last
+	}
+	b.mutex.Unlock()
+	statuses := b.statuses.snapshot()
+
+	response := struct {
+		LogsTFCircuit string                 `json:"logs_tf_circuit"`
+		LogsTFMirrors []mirrorStatus         `json:"logs_tf_mirrors"`
+		Players       []playerStatusResponse `json:"players"`
+	}{
+		LogsTFCircuit: b.breaker.current(),
+		LogsTFMirrors: b.mirrors.snapshot(),
+		Players:       make([]playerStatusResponse, 0, len(players)),
+	}
+	for steamid, channels := range players {
+		p := playerStatusResponse{SteamID: steamid, Channels: make([]string, 0, len(channels))}
+		for _, channel := range channels {
+			p.Channels = append(p.Channels, channel.Name)
+		}
+
+		if last, ok := lastLogs[steamid]; ok {
+			p.LastLogID = last.ID
+			p.LastLogTime = optionalTime(last.Time)
+		}
+
+		if s, ok := statuses[steamid]; ok {
+			p.LastSuccess = optionalTime(s.LastSuccess)
+			p.LastError = s.LastError
+			p.LastErrorAt = optionalTime(s.LastErrorAt)
+		}
+
+		response.Players = append(response.Players, p)
+	}
+	sort.Slice(response.Players, func(i, j int) bool {
+		return response.Players[i].SteamID < response.Players[j].SteamID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
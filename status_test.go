@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckLogsForPlayerRecordsLastError(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.errors[testSteamID] = "Invalid steamid"
+	logsTF.setLogs(otherSteamID, testLog(100, 48*time.Hour))
+	b := newTestBot(t, logsTF, players([]string{testSteamID, otherSteamID}, testChannel), nil)
+
+	before := time.Now()
+	for _, steamid := range []string{testSteamID, otherSteamID} {
+		b.checkLogsForPlayer(context.Background(), steamid, b.playerChannels()[steamid])
+	}
+	b.posts.Wait()
+
+	failed, ok := b.statuses.get(testSteamID)
+	if !ok {
+		t.Fatalf("No status recorded for %v", testSteamID)
+	}
+	if !strings.Contains(failed.LastError, "Invalid steamid") {
+		t.Errorf("Last error is %q, want it to mention the logs.tf error", failed.LastError)
+	}
+	if failed.LastErrorAt.Before(before) || failed.LastErrorAt.After(time.Now()) {
+		t.Errorf("Last error at %v, want it during the check", failed.LastErrorAt)
+	}
+	if !failed.LastSuccess.IsZero() {
+		t.Errorf("Last success is %v, want none", failed.LastSuccess)
+	}
+
+	succeeded, ok := b.statuses.get(otherSteamID)
+	if !ok {
+		t.Fatalf("No status recorded for %v", otherSteamID)
+	}
+	if succeeded.LastError != "" || succeeded.LastSuccess.Before(before) {
+		t.Errorf("Status is %+v, want a recent success and no error", succeeded)
+	}
+}
+
+func TestLastErrorIsKeptAfterASuccess(t *testing.T) {
+	statuses := newPlayerStatuses()
+	statuses.record(testSteamID, errNoLogs)
+	statuses.record(testSteamID, errors.New("logs.tf is down"))
+	statuses.record(testSteamID, nil)
+
+	s, _ := statuses.get(testSteamID)
+	if s.LastError != "logs.tf is down" || s.LastErrorAt.IsZero() || s.LastSuccess.Before(s.LastErrorAt) {
+		t.Errorf("Status is %+v, want the earlier error and a later success", s)
+	}
+}
+
+func TestStatusEndpointServesLastError(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.errors[testSteamID] = "Invalid steamid"
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel), nil)
+	b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID])
+
+	w := httptest.NewRecorder()
+	b.handleStatus(w, httptest.NewRequest("GET", "/status", nil))
+
+	var response struct {
+		Players []playerStatusResponse `json:"players"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse %q: %v", w.Body.String(), err)
+	}
+	if len(response.Players) != 1 {
+		t.Fatalf("Served %d players, want 1", len(response.Players))
+	}
+	p := response.Players[0]
+	if p.SteamID != testSteamID || !strings.Contains(p.LastError, "Invalid steamid") || p.LastErrorAt == nil || p.LastSuccess != nil {
+		t.Errorf("Served %+v, want the player's error and when it happened", p)
+	}
+}
+
+func TestStatusesOfRemovedPlayersAreDropped(t *testing.T) {
+	b := newTestBot(t, newFakeLogsTF(t), players([]string{testSteamID, otherSteamID}, testChannel), nil)
+	b.statuses.record(testSteamID, nil)
+	b.statuses.record(otherSteamID, nil)
+
+	b.setChannels(context.Background(), players([]string{testSteamID}, testChannel))
+
+	if _, ok := b.statuses.get(testSteamID); !ok {
+		t.Errorf("Status of %v was dropped, want it kept", testSteamID)
+	}
+	if _, ok := b.statuses.get(otherSteamID); ok {
+		t.Errorf("Status of %v was kept, want it dropped", otherSteamID)
+	}
+}
+
+func TestStatusEndpointReflectsPostedLogs(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	l := testLog(100, time.Minute)
+	logsTF.setLogs(testSteamID, l)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, logsTF, players([]string{testSteamID}, testChannel, otherTestChannel), nil)
+	connectTestBot(t, b, twitch, testChannel, otherTestChannel)
+
+	if err := b.checkLogsForPlayer(context.Background(), testSteamID, b.playerChannels()[testSteamID]); err != nil {
+		t.Fatalf("checkLogsForPlayer failed: %v", err)
+	}
+	b.posts.Wait()
+
+	w := httptest.NewRecorder()
+	b.handleStatus(w, httptest.NewRequest("GET", "/status", nil))
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Served Content-Type %q, want application/json", got)
+	}
+	if strings.Contains(w.Body.String(), b.oauthKey) {
+		t.Errorf("Served %q, which includes the oauth key", w.Body.String())
+	}
+
+	var response struct {
+		LogsTFCircuit string                 `json:"logs_tf_circuit"`
+		Players       []playerStatusResponse `json:"players"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse %q: %v", w.Body.String(), err)
+	}
+	if response.LogsTFCircuit == "" {
+		t.Error("Served no logs.tf circuit state")
+	}
+	if len(response.Players) != 1 {
+		t.Fatalf("Served %d players, want 1", len(response.Players))
+	}
+	p := response.Players[0]
+	if p.SteamID != testSteamID || !reflect.DeepEqual(p.Channels, []string{testChannel, otherTestChannel}) {
+		t.Errorf("Served %v posted to %v, want %v posted to %v", p.SteamID, p.Channels, testSteamID, []string{testChannel, otherTestChannel})
+	}
+	if p.LastLogID != 100 || p.LastLogTime == nil || p.LastLogTime.Unix() != l.Date {
+		t.Errorf("Served last log %v at %v, want log 100 at %v", p.LastLogID, p.LastLogTime, time.Unix(l.Date, 0))
+	}
+	if p.LastSuccess == nil || p.LastError != "" || p.LastErrorAt != nil {
+		t.Errorf("Served %+v, want a successful check without errors", p)
+	}
+}
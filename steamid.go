@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// steamID64Base is the SteamID64 of the individual account with account number 0 in the public universe
+const steamID64Base = 76561197960265728
+
+var (
+	steamID2Pattern = regexp.MustCompile(`^STEAM_[0-5]:([01]):(\d+)$`)
+	steamID3Pattern = regexp.MustCompile(`^\[U:1:(\d+)\]$`)
+
+	// Steam community profile URLs, by SteamID64 or by the vanity name the player picked
+	steamProfileURLPattern = regexp.MustCompile(`^(?:https?://)?(?:www\.)?steamcommunity\.com/profiles/(\d+)/?$`)
+	steamVanityURLPattern  = regexp.MustCompile(`^(?:https?://)?(?:www\.)?steamcommunity\.com/id/([^/?#]+)/?$`)
+)
+
+// normalizeSteamID converts a SteamID64 (7656119...), SteamID3 ([U:1:...]), SteamID2 (STEAM_0:...) or Steam
+// profile URL (https://steamcommunity.com/profiles/7656119...) to the SteamID64 form that logs.tf searches
+// by. Vanity profile URLs (/id/name) have to be resolved with the Steam API first, see resolveVanityURLs.
+func normalizeSteamID(steamid string) (string, error) {
+	if m := steamProfileURLPattern.FindStringSubmatch(steamid); m != nil {
+		return normalizeSteamID(m[1])
+	}
+
+	if steamVanityURLPattern.MatchString(steamid) {
+		return "", fmt.Errorf("Steam profile URL %q wasn't resolved to a SteamID, /id/ URLs are looked up with the Steam API when the config is loaded, which needs %v", steamid, steamAPIKeyEnvName)
+	}
+
+	if m := steamID2Pattern.FindStringSubmatch(steamid); m != nil {
+		y, _ := strconv.ParseUint(m[1], 10, 64)
+		z, err := strconv.ParseUint(m[2], 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("Invalid SteamID2 %q: %v", steamid, err)
+		}
+
+		return strconv.FormatUint(steamID64Base+z*2+y, 10), nil
+	}
+
+	if m := steamID3Pattern.FindStringSubmatch(steamid); m != nil {
+		w, err := strconv.ParseUint(m[1], 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("Invalid SteamID3 %q: %v", steamid, err)
+		}
+
+		return strconv.FormatUint(steamID64Base+w, 10), nil
+	}
+
+	id, err := strconv.ParseUint(steamid, 10, 64)
+	if err != nil || id < steamID64Base || id > steamID64Base+(1<<32-1) {
+		return "", fmt.Errorf("Invalid SteamID %q, expected a SteamID64, SteamID3 or SteamID2", steamid)
+	}
+
+	return steamid, nil
+}
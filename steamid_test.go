@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNormalizeSteamID(t *testing.T) {
+	for _, steamid := range []string{
+		"76561198107240606",
+		"[U:1:146974878]",
+		"STEAM_0:0:73487439",
+		"STEAM_1:0:73487439",
+		"https://steamcommunity.com/profiles/76561198107240606",
+		"steamcommunity.com/profiles/76561198107240606/",
+	} {
+		got, err := normalizeSteamID(steamid)
+		if err != nil {
+			t.Errorf("normalizeSteamID(%q) failed: %v", steamid, err)
+		} else if got != testSteamID {
+			t.Errorf("normalizeSteamID(%q) = %v, want %v", steamid, got, testSteamID)
+		}
+	}
+}
+
+func TestNormalizeSteamIDOddAccount(t *testing.T) {
+	// the low bit of the account is the SteamID2's Y
+	got, err := normalizeSteamID("STEAM_0:1:15735106")
+	if err != nil || got != otherSteamID {
+		t.Errorf("normalizeSteamID = %v, %v, want %v", got, err, otherSteamID)
+	}
+}
+
+func TestNormalizeSteamIDRejectsInvalidIDs(t *testing.T) {
+	for _, steamid := range []string{
+		"",
+		"lansky",
+		"12345",
+		"[U:1:99999999999]",
+		"STEAM_0:2:73487439",
+		"https://steamcommunity.com/id/lansky",
+	} {
+		if got, err := normalizeSteamID(steamid); err == nil {
+			t.Errorf("normalizeSteamID(%q) = %v, want an error", steamid, got)
+		}
+	}
+}
+
+func TestGetRecentLogsForPlayerSearchesBySteamID64(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	b := newTestBot(t, logsTF, nil, nil)
+	logsTF.setLogs(testSteamID, testLog(100, 0))
+
+	logs, err := b.getRecentLogsForPlayer(context.Background(), "[U:1:146974878]")
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("getRecentLogsForPlayer returned %v, %v, want log 100", logs, err)
+	}
+
+	var invalidErr *invalidSteamIDError
+	if _, err := b.getRecentLogsForPlayer(context.Background(), "lansky"); !errors.As(err, &invalidErr) {
+		t.Errorf("getRecentLogsForPlayer returned %v for an invalid steamid, want an *invalidSteamIDError", err)
+	}
+	if requests := logsTF.requests(); len(requests) != 1 {
+		t.Errorf("logs.tf got %q, want only the valid steamid searched for", requests)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+)
+
+// validateSteamIDs looks up every configured steamid on logs.tf once and logs the ones that don't work, so
+// a typo in the config shows up at startup instead of as repeated poll errors. It returns an error listing
+// the invalid ids, players without any logs yet aren't considered invalid.
+func (b *botConfig) validateSteamIDs(ctx context.Context) error {
+	players := b.playerChannels()
+	steamids := make([]string, 0, len(players))
+	for steamid := range players {
+		steamids = append(steamids, steamid)
+	}
+	sort.Strings(steamids)
+
+	var invalid []string
+	for _, steamid := range steamids {
+		_, err := b.getNewestLogForPlayer(ctx, steamid)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var invalidErr *invalidSteamIDError
+		switch {
+		case err == nil:
+		case errors.Is(err, errNoLogs):
+			slog.Info("No logs yet", "steamid", steamid)
+		case errors.As(err, &invalidErr):
+			slog.Error("Invalid steamid in config", "steamid", steamid, "error", err)
+			invalid = append(invalid, steamid)
+		default:
+			slog.Warn("Failed to check steamid", "steamid", steamid, "error", err)
+		}
+	}
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid steamids in config: %v", invalid)
+	}
+
+	return nil
+}
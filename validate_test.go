@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateSteamIDsReportsInvalidIDs(t *testing.T) {
+	const noLogsSteamID = "76561197960287930"
+
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	logsTF.errors[otherSteamID] = "Invalid steamid"
+	b := newTestBot(t, logsTF, players([]string{testSteamID, otherSteamID, noLogsSteamID, "notasteamid"}, testChannel), nil)
+
+	err := b.validateSteamIDs(context.Background())
+	if err == nil {
+		t.Fatal("validateSteamIDs accepted the invalid steamids")
+	}
+
+	for _, steamid := range []string{otherSteamID, "notasteamid"} {
+		if !strings.Contains(err.Error(), steamid) {
+			t.Errorf("validateSteamIDs returned %q, want it to name %v", err, steamid)
+		}
+	}
+	for _, steamid := range []string{testSteamID, noLogsSteamID} {
+		if strings.Contains(err.Error(), steamid) {
+			t.Errorf("validateSteamIDs returned %q, but %v is valid", err, steamid)
+		}
+	}
+}
+
+func TestValidateSteamIDsAcceptsPlayersWithoutLogs(t *testing.T) {
+	logsTF := newFakeLogsTF(t)
+	logsTF.setLogs(testSteamID, testLog(100, time.Minute))
+	b := newTestBot(t, logsTF, players([]string{testSteamID, otherSteamID}, testChannel), nil)
+
+	if err := b.validateSteamIDs(context.Background()); err != nil {
+		t.Errorf("validateSteamIDs failed for valid steamids: %v", err)
+	}
+}
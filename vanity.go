@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+)
+
+const defaultSteamAPIURL = "https://api.steampowered.com"
+
+// steamVanityNoMatch is the success value the Steam API responds with when no profile has the vanity name
+const steamVanityNoMatch = 42
+
+// vanityCache remembers which SteamID64 each vanity name resolved to, so reloading the config doesn't look
+// every name up again. Only names in the config are resolved, so it doesn't need a limit.
+type vanityCache struct {
+	mutex    *sync.Mutex
+	steamids map[string]string
+}
+
+func newVanityCache() *vanityCache {
+	return &vanityCache{mutex: &sync.Mutex{}, steamids: map[string]string{}}
+}
+
+func (c *vanityCache) get(name string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	steamid, ok := c.steamids[name]
+	return steamid, ok
+}
+
+func (c *vanityCache) put(name, steamid string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.steamids[name] = steamid
+}
+
+// resolveVanityName asks the Steam API for the SteamID64 of the profile with the vanity name
+func (b *botConfig) resolveVanityName(ctx context.Context, name string) (string, error) {
+	if steamid, ok := b.vanityNames.get(name); ok {
+		return steamid, nil
+	}
+
+	query := url.Values{"key": {b.steamAPIKey}, "vanityurl": {name}}
+	body, err := b.get(ctx, b.steamAPIURL+"/ISteamUser/ResolveVanityURL/v0001/?"+query.Encode())
+	if err != nil {
+		// the request's url has the API key in it, so leave it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", err
+	}
+
+	var response struct {
+		Response struct {
+			SteamID string `json:"steamid"`
+			Success int    `json:"success"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", &decodeError{err: err, body: bodySnippet(body)}
+	}
+
+	switch {
+	case response.Response.Success == steamVanityNoMatch:
+		return "", fmt.Errorf("no Steam profile has the vanity name %q", name)
+	case response.Response.Success != 1:
+		return "", fmt.Errorf("Steam API responded with success=%v", response.Response.Success)
+	}
+
+	steamid, err := normalizeSteamID(response.Response.SteamID)
+	if err != nil {
+		return "", err
+	}
+
+	b.vanityNames.put(name, steamid)
+	return steamid, nil
+}
+
+// resolveVanityURLs replaces the vanity profile URLs among the config's players with the SteamID64 they
+// resolve to, adding to that player's channels if they're also listed by another form. Players that can't be
+// resolved, or every vanity URL if there's no Steam API key, are left as they are and fail to be looked up
+// like any other invalid steamid.
+func (b *botConfig) resolveVanityURLs(ctx context.Context, steamIDToTwitchChannel map[string]channelList) map[string]channelList {
+	resolved := make(map[string]channelList, len(steamIDToTwitchChannel))
+	for steamid, channels := range steamIDToTwitchChannel {
+		m := steamVanityURLPattern.FindStringSubmatch(steamid)
+		if m == nil || b.steamAPIKey == "" {
+			resolved[steamid] = append(resolved[steamid], channels...)
+			continue
+		}
+
+		steamID64, err := b.resolveVanityName(ctx, m[1])
+		if err != nil {
+			slog.Warn("Failed to resolve Steam profile URL", "steamid", steamid, "error", err)
+			resolved[steamid] = append(resolved[steamid], channels...)
+			continue
+		}
+
+		slog.Debug("Resolved Steam profile URL", "steamid", steamid, "steamid64", steamID64)
+		resolved[steamID64] = append(resolved[steamID64], channels...)
+	}
+
+	return resolved
+}
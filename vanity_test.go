@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSteamAPI resolves the vanity names in steamids, and answers like Steam does for any other name
+func fakeSteamAPI(t *testing.T, steamids map[string]string) (*httptest.Server, func() int) {
+	var mutex sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		mutex.Unlock()
+
+		if r.URL.Path != "/ISteamUser/ResolveVanityURL/v0001/" || r.URL.Query().Get("key") != "steamkey" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if steamid, ok := steamids[r.URL.Query().Get("vanityurl")]; ok {
+			w.Write([]byte(`{"response": {"steamid": "` + steamid + `", "success": 1}}`))
+			return
+		}
+		w.Write([]byte(`{"response": {"success": 42, "message": "No match"}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return requests
+	}
+}
+
+func TestVanityURLsAreResolved(t *testing.T) {
+	steamAPI, requests := fakeSteamAPI(t, map[string]string{"lansky": testSteamID})
+	b := newTestBot(t, nil, nil, map[string]string{steamAPIKeyEnvName: "steamkey", steamAPIURLEnvName: steamAPI.URL})
+
+	config := map[string]channelList{
+		"https://steamcommunity.com/id/lansky/": {{Name: testChannel}},
+		testSteamID:                             {{Name: otherTestChannel}},
+		"steamcommunity.com/id/nobody":          {{Name: testChannel}},
+	}
+	got := map[string][]string{}
+	for steamid, channels := range b.resolveVanityURLs(context.Background(), config) {
+		names := channels.names()
+		sort.Strings(names)
+		got[steamid] = names
+	}
+
+	// the resolved player is merged with the same player listed by id, the unknown name is left to fail
+	want := map[string][]string{
+		testSteamID:                    {otherTestChannel, testChannel},
+		"steamcommunity.com/id/nobody": {testChannel},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolved %v, want %v", got, want)
+	}
+
+	// names that resolved are cached
+	before := requests()
+	if steamid, err := b.resolveVanityName(context.Background(), "lansky"); err != nil || steamid != testSteamID {
+		t.Errorf("resolveVanityName = %v, %v, want %v", steamid, err, testSteamID)
+	}
+	if requests() != before {
+		t.Errorf("The Steam API got %d more requests, want the cached id used", requests()-before)
+	}
+}
+
+func TestVanityURLsNeedASteamAPIKey(t *testing.T) {
+	steamAPI, requests := fakeSteamAPI(t, map[string]string{"lansky": testSteamID})
+	b := newTestBot(t, nil, nil, map[string]string{steamAPIKeyEnvName: "", steamAPIURLEnvName: steamAPI.URL})
+
+	const vanityURL = "https://steamcommunity.com/id/lansky"
+	resolved := b.resolveVanityURLs(context.Background(), map[string]channelList{vanityURL: {{Name: testChannel}}})
+	if _, ok := resolved[vanityURL]; !ok || len(resolved) != 1 {
+		t.Errorf("Resolved %v, want the vanity URL left as it is", resolved)
+	}
+	if requests() != 0 {
+		t.Errorf("The Steam API got %d requests, want none without a key", requests())
+	}
+
+	if _, err := normalizeSteamID(vanityURL); err == nil || !strings.Contains(err.Error(), steamAPIKeyEnvName) {
+		t.Errorf("normalizeSteamID returned %v, want an error mentioning %v", err, steamAPIKeyEnvName)
+	}
+}
+
+func TestVanityErrorsLeaveOutTheKey(t *testing.T) {
+	b := newTestBot(t, nil, nil, map[string]string{steamAPIKeyEnvName: "steamkey", steamAPIURLEnvName: "http://127.0.0.1:1"})
+
+	_, err := b.resolveVanityName(context.Background(), "lansky")
+	if err == nil || strings.Contains(err.Error(), "steamkey") {
+		t.Errorf("resolveVanityName returned %v, want an error without the API key", err)
+	}
+}
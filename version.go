@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo returns the version, commit and build date, falling back to what the Go toolchain recorded
+// about the build for anything that wasn't set with -ldflags
+func buildInfo() (v, c, date string) {
+	v, c, date = version, commit, buildDate
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value
+				if len(c) > 12 {
+					c = c[:12]
+				}
+			case s.Key == "vcs.time" && date == "":
+				date = s.Value
+			}
+		}
+	}
+
+	if c == "" {
+		c = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+
+	return v, c, date
+}
+
+// defaultUserAgent identifies the bot to logs.tf when LOGS_BOT_USER_AGENT isn't set
+func defaultUserAgent() string {
+	return "logs-bot/" + version + " (+https://github.com/dpolansky/logs-bot)"
+}
+
+// versionString describes the build for -version
+func versionString() string {
+	v, c, date := buildInfo()
+	return fmt.Sprintf("logs-bot %v (commit %v, built %v)", v, c, date)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	if got := versionString(); !strings.HasPrefix(got, "logs-bot dev (commit ") {
+		t.Errorf("versionString = %q, want the dev version", got)
+	}
+
+	saved := []string{version, commit, buildDate}
+	defer func() { version, commit, buildDate = saved[0], saved[1], saved[2] }()
+	version, commit, buildDate = "1.2.0", "0123abcd", "2023-04-01T18:42:00Z"
+
+	if got, want := versionString(), "logs-bot 1.2.0 (commit 0123abcd, built 2023-04-01T18:42:00Z)"; got != want {
+		t.Errorf("versionString = %q, want %q", got, want)
+	}
+	if got := defaultUserAgent(); !strings.HasPrefix(got, "logs-bot/1.2.0 ") {
+		t.Errorf("defaultUserAgent = %q, want it to have the version", got)
+	}
+}
+
+// runMainEnvName makes the test binary run main with the arguments after --, for testing main's flags
+const runMainEnvName = "LOGS_BOT_TEST_RUN_MAIN"
+
+func TestVersionFlagSkipsStartup(t *testing.T) {
+	if os.Getenv(runMainEnvName) == "1" {
+		for i, arg := range os.Args {
+			if arg == "--" {
+				os.Args = append([]string{"logs-bot"}, os.Args[i+1:]...)
+				break
+			}
+		}
+		main()
+		return
+	}
+
+	// without credentials the bot would exit with an error if it started
+	cmd := exec.Command(os.Args[0], "-test.run=^TestVersionFlagSkipsStartup$", "--", "-version")
+	cmd.Env = []string{runMainEnvName + "=1"}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("logs-bot -version failed: %v, output: %s", err, out)
+	}
+	if !strings.HasPrefix(string(out), "logs-bot dev (commit ") {
+		t.Errorf("logs-bot -version printed %q, want the version", out)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	webhookQueueSize    = 100              // how many posted logs can wait to be sent to LOGS_BOT_WEBHOOK_URL before they're dropped
+	webhookDrainTimeout = 30 * time.Second // how long -once waits for the queued events to be sent before exiting
+)
+
+// webhookEvent is what's sent to LOGS_BOT_WEBHOOK_URL for each log posted to a channel
+type webhookEvent struct {
+	SteamID  string `json:"steamid"`
+	Channel  string `json:"channel"`
+	LogID    int    `json:"log_id"`
+	URL      string `json:"url"`
+	Title    string `json:"title"`
+	Date     string `json:"date"`      // when the log was uploaded, in RFC 3339
+	PostedAt string `json:"posted_at"` // when the log was posted to the channel, in RFC 3339
+}
+
+// queueWebhookEvent queues an event for the log being posted to the channel, if there's a webhook. Events
+// are dropped if the queue is full, so a slow webhook never holds up posting.
+func (b *botConfig) queueWebhookEvent(steamid string, l *logResponse, channel channelConfig) {
+	if b.webhookURL == "" {
+		return
+	}
+
+	event := webhookEvent{
+		SteamID:  steamid,
+		Channel:  channel.Name,
+		LogID:    l.ID,
+		URL:      b.logURL(l.ID),
+		Title:    l.Title,
+		Date:     logTime(l).UTC().Format(time.RFC3339),
+		PostedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	select {
+	case b.webhookEvents <- event:
+	default:
+		slog.Warn("Dropping webhook event, too many are waiting to be sent", "log_id", l.ID, "channel", channel.Name)
+	}
+}
+
+// sendWebhookEvents sends queued events to the webhook one at a time until the context is done. Failures
+// are logged and otherwise ignored.
+func (b *botConfig) sendWebhookEvents(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-b.webhookEvents:
+			if err := b.postWebhookEvent(ctx, event); err != nil && ctx.Err() == nil {
+				slog.Warn("Failed to send webhook event", "log_id", event.LogID, "channel", event.Channel, "error", err)
+			}
+		}
+	}
+}
+
+// sendQueuedWebhookEvents sends the events already queued, giving up on the rest after webhookDrainTimeout.
+// -once sends them this way instead of in the background so a pass's events are sent before it exits.
+func (b *botConfig) sendQueuedWebhookEvents(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, webhookDrainTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case event := <-b.webhookEvents:
+			if err := b.postWebhookEvent(ctx, event); err != nil {
+				slog.Warn("Failed to send webhook event", "log_id", event.LogID, "channel", event.Channel, "error", err)
+			}
+			if ctx.Err() != nil {
+				if unsent := len(b.webhookEvents); unsent > 0 {
+					slog.Warn("Gave up sending webhook events", "unsent", unsent)
+				}
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (b *botConfig) postWebhookEvent(ctx context.Context, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", b.userAgent)
+
+	res, err := b.httpClient.Do(req)
+	if err != nil {
+		// the webhook's url may have a token in it, so leave it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %v", res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostedLogsAreSentToTheWebhook(t *testing.T) {
+	// the fake Discord webhook keeps whatever JSON is posted to it, which is all a receiver needs to do
+	receiver := newFakeDiscord(t, http.StatusOK)
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{logsTFBaseURLEnvName: "https://logs.tf", webhookURLEnvName: receiver.URL})
+	connectTestBot(t, b, twitch, testChannel)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.sendWebhookEvents(ctx)
+
+	l := testLog(100, time.Minute)
+	l.Date = time.Date(2023, 4, 1, 18, 42, 0, 0, time.UTC).Unix()
+	before := time.Now().Truncate(time.Second)
+	if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: testChannel}); err != nil {
+		t.Fatalf("sendLogToChannel failed: %v", err)
+	}
+	waitFor(t, "the event to be sent to the webhook", func() bool { return len(receiver.posted()) > 0 })
+
+	got := receiver.posted()[0]
+	postedAt, err := time.Parse(time.RFC3339, got["posted_at"].(string))
+	if err != nil || postedAt.Before(before) || postedAt.After(time.Now()) {
+		t.Errorf("Sent posted_at %v, want when the log was posted", got["posted_at"])
+	}
+	delete(got, "posted_at")
+	want := map[string]interface{}{
+		"steamid": testSteamID,
+		"channel": testChannel,
+		"log_id":  float64(100),
+		"url":     "https://logs.tf/100",
+		"title":   "serveme.tf #100",
+		"date":    "2023-04-01T18:42:00Z",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Sent %v, want %v", got, want)
+	}
+}
+
+func TestFailedSendsArentSentToTheWebhook(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{webhookURLEnvName: "http://127.0.0.1:1/events"})
+	connectTestBot(t, b, twitch, testChannel)
+	b.writer.stop()
+
+	l := testLog(100, time.Minute)
+	if err := b.sendLogToChannel(context.Background(), testSteamID, &l, channelConfig{Name: testChannel}); err == nil {
+		t.Fatal("sendLogToChannel succeeded with the connection gone")
+	}
+	if n := len(b.webhookEvents); n != 0 {
+		t.Errorf("Queued %d webhook events for a log that wasn't posted", n)
+	}
+}
+
+func TestWebhookEventsAreDroppedWhenTheQueueIsFull(t *testing.T) {
+	// nothing sends the events, like a webhook that's stopped responding
+	b := newTestBot(t, nil, nil, map[string]string{webhookURLEnvName: "http://127.0.0.1:1/events"})
+
+	queued := make(chan struct{})
+	go func() {
+		defer close(queued)
+		for i := 0; i < webhookQueueSize+10; i++ {
+			l := testLog(100+i, time.Minute)
+			b.queueWebhookEvent(testSteamID, &l, channelConfig{Name: testChannel})
+		}
+	}()
+	select {
+	case <-queued:
+	case <-time.After(harnessTimeout):
+		t.Fatal("Queueing webhook events blocked on a full queue")
+	}
+	if n := len(b.webhookEvents); n != webhookQueueSize {
+		t.Errorf("Queued %d webhook events, want %d", n, webhookQueueSize)
+	}
+}
+
+func TestWebhookURLMustBeHTTP(t *testing.T) {
+	setCredentials(t)
+	t.Setenv(webhookURLEnvName, "example.com/events")
+
+	if _, err := newBotConfigFromEnv(); err == nil || !strings.Contains(err.Error(), webhookURLEnvName) {
+		t.Errorf("newBotConfigFromEnv returned %v, want an error naming %v", err, webhookURLEnvName)
+	}
+}
+
+func TestWebhookErrorsLeaveOutTheURL(t *testing.T) {
+	logs := captureLogs(t)
+	receiver := httptest.NewServer(http.NotFoundHandler())
+	receiver.Close()
+	b := newTestBot(t, nil, nil, map[string]string{webhookURLEnvName: receiver.URL + "/events?token=secret"})
+
+	l := testLog(100, time.Minute)
+	b.queueWebhookEvent(testSteamID, &l, channelConfig{Name: testChannel})
+	b.sendQueuedWebhookEvents(context.Background())
+
+	if !strings.Contains(logs.String(), "Failed to send webhook event") {
+		t.Fatalf("Logged %q, want the failure logged", logs)
+	}
+	if strings.Contains(logs.String(), "secret") {
+		t.Errorf("Logged the webhook's token:\n%v", logs)
+	}
+}
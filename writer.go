@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+)
+
+const (
+	outboundQueueSize     = 100             // how many IRC lines can be queued before senders block
+	finalLineWriteTimeout = 1 * time.Second // how long each line written when stopping can take
+)
+
+var errWriterClosed = errors.New("IRC writer is closed")
+
+// ircWriter owns all writes to an IRC connection. Lines are queued by any number of goroutines and written
+// one at a time by a single goroutine, so concurrent senders can't interleave bytes on the connection.
+type ircWriter struct {
+	conn         ircConn
+	limiter      *rateLimiter
+	writeTimeout time.Duration // how long each line can take to write
+	lines        chan string
+
+	ctx        context.Context
+	cancel     context.CancelFunc
+	finalLines []string
+	done       chan struct{}
+}
+
+func newIRCWriter(conn ircConn, limiter *rateLimiter, writeTimeout time.Duration) *ircWriter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ircWriter{
+		conn:         conn,
+		limiter:      limiter,
+		writeTimeout: writeTimeout,
+		lines:        make(chan string, outboundQueueSize),
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+}
+
+// run writes queued lines until the writer is stopped or a write fails or takes longer than writeTimeout, in
+// which case the connection is closed so the reader notices the connection dropped
+func (w *ircWriter) run() {
+	defer close(w.done)
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			if len(w.finalLines) > 0 {
+				w.writeFinalLines()
+			}
+			return
+		case line := <-w.lines:
+			if err := w.limiter.wait(w.ctx); err != nil {
+				continue
+			}
+
+			// a dead or congested connection can block a write forever, the deadline turns that into an error
+			w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+			if err := w.writeLine(line); err != nil {
+				slog.Error("Failed to write to Twitch IRC server", "error", err)
+				w.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// writeFinalLines makes a best effort to write anything still queued and then the lines the writer was
+// stopped with, giving up after quitTimeout. The last line is written even if the rate limit held up the
+// others, since it's the QUIT.
+func (w *ircWriter) writeFinalLines() {
+	ctx, cancel := context.WithTimeout(context.Background(), quitTimeout)
+	defer cancel()
+
+	var lines []string
+	for queued := true; queued; {
+		select {
+		case line := <-w.lines:
+			lines = append(lines, line)
+		default:
+			queued = false
+		}
+	}
+	lines = append(lines, w.finalLines...)
+
+	last := len(lines) - 1
+	for i, line := range lines {
+		if err := w.limiter.wait(ctx); err != nil && i != last {
+			continue
+		}
+
+		// the deadline may have passed waiting on the rate limit, so give each write a moment of its own
+		w.conn.SetWriteDeadline(time.Now().Add(finalLineWriteTimeout))
+		if err := w.writeLine(line); err != nil {
+			return
+		}
+	}
+}
+
+// writeLine writes the whole line to the connection. A connection that writes part of the line without an
+// error is written to again with the rest, since stopping partway would run the line into the next one. One
+// that stops making progress is treated as failed.
+func (w *ircWriter) writeLine(line string) error {
+	data := []byte(line + "\r\n")
+	for len(data) > 0 {
+		n, err := w.conn.Write(data)
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return io.ErrShortWrite
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
+
+// send queues a line to be written, blocking if the queue is full
+func (w *ircWriter) send(ctx context.Context, line string) error {
+	// a stopped writer's queue is never written, so don't let a line in just because there's room for it
+	select {
+	case <-w.done:
+		return errWriterClosed
+	case <-w.ctx.Done():
+		return errWriterClosed
+	default:
+	}
+
+	select {
+	case w.lines <- line:
+		return nil
+	case <-w.done:
+		return errWriterClosed
+	case <-w.ctx.Done():
+		return errWriterClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop stops the writer and waits for it to exit. With finalLines, anything still queued is written before
+// them, otherwise it's dropped since the connection is already gone.
+func (w *ircWriter) stop(finalLines ...string) {
+	w.finalLines = finalLines
+	w.cancel()
+	<-w.done
+}
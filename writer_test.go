@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentSendsWriteWholeLines(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, map[string]string{rateLimitBurstEnvName: "10000"})
+	connectTestBot(t, b, twitch)
+
+	const senders, sends = 20, 50
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < sends; j++ {
+				if err := b.send(context.Background(), "PRIVMSG #lansky :message %d %d", i, j); err != nil {
+					t.Errorf("send failed: %v", err)
+				}
+			}
+		}(i)
+	}
+
+	line := regexp.MustCompile(`^PRIVMSG #lansky :message (\d+) (\d+)$`)
+	seen := map[string]bool{}
+	for len(seen) < senders*sends {
+		got := twitch.next(t)
+		if !line.MatchString(got) || seen[got] {
+			t.Fatalf("Bot wrote %q, want each message once on a line of its own", got)
+		}
+		seen[got] = true
+	}
+	wg.Wait()
+}
+
+func TestSendFailsOnceTheWriterIsStopped(t *testing.T) {
+	twitch := newFakeTwitch(t)
+	b := newTestBot(t, nil, nil, nil)
+	connectTestBot(t, b, twitch)
+
+	b.writer.stop()
+	for i := 0; i < 100; i++ {
+		if err := b.send(context.Background(), fmt.Sprintf("PRIVMSG #lansky :%d", i)); err != errWriterClosed {
+			t.Fatalf("send returned %v, want %v", err, errWriterClosed)
+		}
+	}
+}
+
+// recordingConn is an ircConn that keeps what's written to it, accepting at most chunk bytes per write,
+// failing every write once failing is set and writing nothing without an error once stalled is set
+type recordingConn struct {
+	mutex   sync.Mutex
+	written bytes.Buffer
+	chunk   int
+	failing bool
+	stalled bool
+	closed  bool
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) { return 0, io.EOF }
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.failing || c.closed {
+		return 0, errors.New("connection reset by peer")
+	}
+	if c.stalled {
+		return 0, nil
+	}
+	if c.chunk > 0 && len(p) > c.chunk {
+		p = p[:c.chunk]
+	}
+	return c.written.Write(p)
+}
+
+func (c *recordingConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	return nil
+}
+
+func (c *recordingConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *recordingConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *recordingConn) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.written.String()
+}
+
+func (c *recordingConn) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.closed
+}
+
+func TestWriterFinishesShortWrites(t *testing.T) {
+	conn := &recordingConn{chunk: 3}
+	w := newIRCWriter(conn, newRateLimiter(1000, time.Second), time.Second)
+	go w.run()
+
+	for _, line := range []string{"JOIN #lansky", "PRIVMSG #lansky :https://logs.tf/100"} {
+		if err := w.send(context.Background(), line); err != nil {
+			t.Fatalf("send failed: %v", err)
+		}
+	}
+	w.stop("QUIT")
+
+	if got, want := conn.String(), "JOIN #lansky\r\nPRIVMSG #lansky :https://logs.tf/100\r\nQUIT\r\n"; got != want {
+		t.Errorf("Wrote %q, want %q", got, want)
+	}
+}
+
+func TestWriterClosesTheConnectionWhenAWriteFails(t *testing.T) {
+	conn := &recordingConn{failing: true}
+	w := newIRCWriter(conn, newRateLimiter(1000, time.Second), time.Second)
+	go w.run()
+
+	if err := w.send(context.Background(), "PRIVMSG #lansky :https://logs.tf/100"); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	waitFor(t, "the connection to be closed", conn.isClosed)
+
+	<-w.done
+	if err := w.send(context.Background(), "PRIVMSG #lansky :https://logs.tf/101"); err != errWriterClosed {
+		t.Errorf("send after the write failed returned %v, want %v", err, errWriterClosed)
+	}
+}
+
+func TestWriterStopGivesUpOnAStalledConnection(t *testing.T) {
+	// nothing reads the other end of the pipe, so writes block until their deadline
+	client, server := net.Pipe()
+	defer server.Close()
+	w := newIRCWriter(client, newRateLimiter(1000, time.Second), time.Second)
+	go w.run()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		w.stop("PART #clockwork", "PART #lansky", "QUIT")
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * finalLineWriteTimeout):
+		t.Fatal("stop didn't give up on a connection that isn't being read")
+	}
+}
+
+func TestWriterFailsOnAConnectionThatStopsWriting(t *testing.T) {
+	conn := &recordingConn{chunk: 4}
+	w := newIRCWriter(conn, newRateLimiter(1000, time.Second), time.Second)
+	if err := w.writeLine("PRIVMSG #lansky :https://logs.tf/100"); err != nil {
+		t.Fatalf("writeLine failed: %v", err)
+	}
+
+	conn.mutex.Lock()
+	conn.stalled = true
+	conn.mutex.Unlock()
+	if err := w.writeLine("PRIVMSG #lansky :https://logs.tf/101"); err != io.ErrShortWrite {
+		t.Errorf("writeLine returned %v, want %v", err, io.ErrShortWrite)
+	}
+
+	// the writer gives up on the connection so the bot reconnects
+	go w.run()
+	if err := w.send(context.Background(), "PRIVMSG #lansky :https://logs.tf/102"); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	waitFor(t, "the connection to be closed", conn.isClosed)
+	<-w.done
+}
+
+func TestWritesToABlockedConnectionTimeOut(t *testing.T) {
+	// nothing reads the other end of the pipe, so writes block until their deadline
+	client, server := net.Pipe()
+	defer server.Close()
+	w := newIRCWriter(client, newRateLimiter(1000, time.Second), 50*time.Millisecond)
+
+	client.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	var netErr net.Error
+	if err := w.writeLine("PRIVMSG #lansky :https://logs.tf/100"); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("writeLine returned %v, want a timeout", err)
+	}
+
+	// the writer gives up on the connection rather than blocking, so the bot reconnects
+	go w.run()
+	if err := w.send(context.Background(), "PRIVMSG #lansky :https://logs.tf/101"); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	select {
+	case <-w.done:
+	case <-time.After(harnessTimeout):
+		t.Fatal("The writer kept waiting on a blocked write")
+	}
+	if err := w.send(context.Background(), "PRIVMSG #lansky :https://logs.tf/102"); err != errWriterClosed {
+		t.Errorf("send after the write timed out returned %v, want %v", err, errWriterClosed)
+	}
+}